@@ -0,0 +1,259 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// AdminToken guards the admin routes, which are disabled when it is empty
+var AdminToken = ""
+
+// requireAdmin checks the bearer token against AdminToken
+func requireAdmin(c *gin.Context) bool {
+	token := AdminToken
+	if token == "" {
+		c.JSON(http.StatusForbidden, ErrorMessage{Error: "Admin API is disabled."})
+		return false
+	}
+	given := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		c.JSON(http.StatusUnauthorized, ErrorMessage{Error: "Invalid admin token."})
+		return false
+	}
+	return true
+}
+
+// ResetAll disconnects every octapod and forgets them, optionally generating a new
+// maze. The maze is built from seed, or from the next seed of the lobby when 0.
+func (l *Lobby) ResetAll(regenerate bool, seed int64) (int, error) {
+	return l.reset(0, 0, regenerate, seed)
+}
+
+// ResizeRound starts a fresh round on a new maze of the given size, 0 keeps the
+// current width or height
+func (l *Lobby) ResizeRound(width, height int, seed int64) (int, error) {
+	if width < 0 || height < 0 {
+		return 0, fmt.Errorf("invalid maze size %dx%d", width, height)
+	}
+	return l.reset(width, height, true, seed)
+}
+
+func (l *Lobby) reset(width, height int, regenerate bool, seed int64) (int, error) {
+	l.Mutex.Lock()
+	pods := make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		pods = append(pods, o)
+	}
+	if regenerate {
+		if seed == 0 {
+			seed = l.mazeRand.Int63()
+		}
+		if width == 0 {
+			width = l.Maze.Width
+		}
+		if height == 0 {
+			height = l.Maze.Height
+		}
+		if err := CheckSize(l.generator(), width, height); err != nil {
+			l.Mutex.Unlock()
+			return 0, err
+		}
+		maze := NewMaze(width, height)
+		if err := maze.GenerateFromSeed(l.generator(), seed); err != nil {
+			l.Mutex.Unlock()
+			return 0, err
+		}
+		l.Maze = maze
+		l.MazeSeed = seed
+		l.logger().Info("New maze", "mazeSeed", seed)
+	}
+	l.Octapods = make(map[string]*Octapod)
+	l.teams = nil
+	l.occupyMutex.Lock()
+	l.occupied = make(map[Point]*Octapod)
+	l.occupyMutex.Unlock()
+	l.Mutex.Unlock()
+
+	for _, o := range pods {
+		o.Kick(websocket.CloseNormalClosure, "Round over")
+	}
+	l.revokeTokens()
+	l.resetMoveQuotas()
+	l.resetEvents()
+	l.resetRound()
+	l.openMatch()
+	return len(pods), nil
+}
+
+// generator is the lobby's chosen generator, falling back to MazeGenerator. Must hold l.Mutex.
+func (l *Lobby) generator() Generator {
+	if l.Generator != nil {
+		return l.Generator
+	}
+	return MazeGenerator
+}
+
+// NewRound switches the lobby to the named generator or preset and starts a fresh
+// round on a new maze
+func (l *Lobby) NewRound(name string) (int, error) {
+	g, err := GeneratorByName(name)
+	if err != nil {
+		return 0, err
+	}
+	l.Mutex.Lock()
+	previous := l.Generator
+	l.Generator = g
+	l.Mutex.Unlock()
+
+	count, err := l.ResetAll(true, 0)
+	if err != nil {
+		l.Mutex.Lock()
+		l.Generator = previous
+		l.Mutex.Unlock()
+	}
+	return count, err
+}
+
+func (l *Lobby) HandleResetAll(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	regenerate := c.Query("regenerate") == "true"
+	var seed int64
+	if c.Query("seed") != "" {
+		var err error
+		if seed, err = strconv.ParseInt(c.Query("seed"), 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorMessage{Error: "Invalid seed: " + err.Error()})
+			return
+		}
+		regenerate = true
+	}
+	count, err := l.ResetAll(regenerate, seed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorMessage{Error: err.Error()})
+		return
+	}
+
+	mazeSeed := l.mazeSeed()
+	message := "Round over, all octapods have been disconnected."
+	if regenerate {
+		message += fmt.Sprintf(" A new maze has been generated (maze seed %d).", mazeSeed)
+	}
+	l.DiscordBot.SendMessage(message)
+	c.JSON(http.StatusOK, gin.H{"disconnected": count, "regenerated": regenerate, "mazeSeed": mazeSeed})
+}
+
+// KickPod disconnects one octapod, it may reconnect with its password
+func (l *Lobby) KickPod(id string) (PodState, bool) {
+	l.Mutex.RLock()
+	o, exists := l.Octapods[strings.ToLower(id)]
+	l.Mutex.RUnlock()
+	if !exists {
+		return PodState{}, false
+	}
+	o.Kick(websocket.ClosePolicyViolation, "Kicked by an admin")
+	return o.State(), true
+}
+
+func (l *Lobby) HandleKick(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	id := strings.ToLower(c.Param("id"))
+	state, exists := l.KickPod(id)
+	if !exists {
+		c.JSON(http.StatusNotFound, ErrorMessage{Error: "No octapod [" + id + "] in the lobby."})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+func (l *Lobby) HandlePause(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	l.Pause()
+	c.JSON(http.StatusOK, gin.H{"paused": true})
+}
+
+func (l *Lobby) HandleResume(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	l.Resume()
+	c.JSON(http.StatusOK, gin.H{"paused": false})
+}
+
+// HandleSetInterval changes the update interval, given in milliseconds as ?ms=
+func (l *Lobby) HandleSetInterval(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	ms, err := strconv.Atoi(c.Query("ms"))
+	if err != nil || ms <= 0 {
+		c.JSON(http.StatusBadRequest, ErrorMessage{Error: "ms must be a positive number of milliseconds."})
+		return
+	}
+	l.SetUpdateInterval(time.Duration(ms) * time.Millisecond)
+	c.JSON(http.StatusOK, gin.H{"updateInterval": ms})
+}
+
+// SetSensors switches the lobby to the named sensor package from the next tick
+func (l *Lobby) SetSensors(name string) (SensorPackage, error) {
+	pkg, err := SensorPackageByName(name)
+	if err != nil {
+		return pkg, err
+	}
+	l.Mutex.Lock()
+	l.Sensors = pkg
+	l.Mutex.Unlock()
+	l.logger().Info("Sensor package set", "package", name)
+	return pkg, nil
+}
+
+func (l *Lobby) HandleSetSensors(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	pkg, err := l.SetSensors(c.Query("package"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorMessage{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, pkg)
+}
+
+// HandleForceTimeout sends the timeout signal now instead of waiting for the timer
+func (l *Lobby) HandleForceTimeout(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	l.TimeoutUpdate()
+	c.Status(http.StatusNoContent)
+}
+
+func (l *Lobby) HandleStartRound(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	if err := l.StartRound(); err != nil {
+		c.JSON(http.StatusConflict, ErrorMessage{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"phase": l.Phase()})
+}
+
+func (l *Lobby) HandleFinishRound(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	l.FinishRound()
+	c.JSON(http.StatusOK, gin.H{"phase": l.Phase()})
+}
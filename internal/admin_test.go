@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// adminRequest sends method path to handler with the admin token
+func adminRequest(t *testing.T, handler gin.HandlerFunc, method, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, "/admin/*action", handler)
+	request := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request)
+	return w
+}
+
+func TestResetAllDisconnectsEveryPod(t *testing.T) {
+	setFor(t, &AdminToken, "organiser")
+	l := newTestLobby(t, 9, 9, 1)
+	addPod(t, l, "poller")
+	conn := dialPod(t, l, AuthMessage{ID: "socket", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	seed := l.mazeSeed()
+
+	if w := adminRequest(t, l.HandleResetAll, http.MethodPost, "/admin/reset", "guess"); w.Code != http.StatusUnauthorized {
+		t.Errorf("reset with a wrong token: status %d, want 401", w.Code)
+	}
+
+	// Ticks keep running while the lobby resets
+	stop := make(chan struct{})
+	var ticking sync.WaitGroup
+	ticking.Add(1)
+	go func() {
+		defer ticking.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				l.Update()
+			}
+		}
+	}()
+	w := adminRequest(t, l.HandleResetAll, http.MethodPost, "/admin/reset?regenerate=true", "organiser")
+	close(stop)
+	ticking.Wait()
+
+	var result struct {
+		Disconnected int   `json:"disconnected"`
+		Regenerated  bool  `json:"regenerated"`
+		MazeSeed     int64 `json:"mazeSeed"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &result) != nil {
+		t.Fatalf("reset: status %d body %s", w.Code, w.Body)
+	}
+	if result.Disconnected != 2 || !result.Regenerated || result.MazeSeed == seed {
+		t.Errorf("reset result %+v, want 2 pods disconnected on a new maze", result)
+	}
+	l.Mutex.RLock()
+	left := len(l.Octapods)
+	l.Mutex.RUnlock()
+	if left != 0 {
+		t.Errorf("%d octapods left after the reset", left)
+	}
+
+	var bye GoodbyeMessage
+	readEnvelope(t, conn, GoodbyeMessageType, &bye)
+	if err := conn.WriteJSON(CommandMessage{Type: GoodbyeCommand}); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closed *websocket.CloseError
+			if !errors.As(err, &closed) || closed.Code != websocket.CloseNormalClosure || closed.Text != "Round over" {
+				t.Errorf("closed with %v, want a normal close saying the round is over", err)
+			}
+			break
+		}
+	}
+}
+
+func TestRoundRoutesNeedRoundMode(t *testing.T) {
+	setFor(t, &AdminToken, "organiser")
+	setFor(t, &CountdownDuration, 0)
+	l := newTestLobby(t, 9, 9, 1)
+	addPod(t, l, "racer")
+
+	setFor(t, &RoundMode, false)
+	if w := adminRequest(t, l.HandleStartRound, http.MethodPost, "/admin/round/start", "organiser"); w.Code != http.StatusConflict {
+		t.Errorf("start without round mode: status %d, want 409", w.Code)
+	}
+
+	RoundMode = true
+	if phase := l.Phase(); phase != PhaseWaiting {
+		t.Fatalf("phase %s before the start, want waiting", phase)
+	}
+	w := adminRequest(t, l.HandleStartRound, http.MethodPost, "/admin/round/start", "organiser")
+	if w.Code != http.StatusOK {
+		t.Fatalf("start: status %d, %s", w.Code, w.Body)
+	}
+	if phase := l.Phase(); phase != PhaseRunning {
+		t.Errorf("phase %s after a start with no countdown, want running", phase)
+	}
+	w = adminRequest(t, l.HandleFinishRound, http.MethodPost, "/admin/round/finish", "organiser")
+	var body struct{ Phase Phase }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Phase != PhaseFinished {
+		t.Errorf("phase %s after finishing, want finished", body.Phase)
+	}
+}
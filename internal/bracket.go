@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Bracket mazes must score within this difficulty band and differ from each other
+// in at least BracketMinDifference of their cells
+var BracketMinDifficulty = 0.3
+var BracketMaxDifficulty = 0.9
+var BracketMinDifference = 0.1
+var BracketMaxAttempts = 100 // Candidate seeds tried per maze
+
+var ErrBracketShort = errors.New("ran out of attempts for distinct bracket mazes")
+
+// GenerateBracketMazes builds n distinct, valid mazes of comparable difficulty for a
+// tournament. Seeds are derived from baseSeed so the same bracket can be rebuilt.
+// If the attempts run out, the mazes found so far come back with ErrBracketShort.
+func GenerateBracketMazes(n int, w, h int, baseSeed int64) ([]*Maze, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if err := CheckSize(MazeGenerator, w, h); err != nil {
+		return nil, err
+	}
+	mazes := make([]*Maze, 0, n)
+	for round := 0; len(mazes) < n && round < n*BracketMaxAttempts; round++ {
+		m := NewMaze(w, h)
+		if err := m.GenerateSolvable(newRand(baseSeed, "bracket-"+strconv.Itoa(round))); err != nil {
+			continue
+		}
+		if d := m.DifficultyScore(); d < BracketMinDifficulty || d > BracketMaxDifficulty {
+			continue
+		}
+		if !distinctFrom(m, mazes) {
+			continue
+		}
+		mazes = append(mazes, m)
+	}
+	if len(mazes) < n {
+		return mazes, fmt.Errorf("%w: %d of %d generated", ErrBracketShort, len(mazes), n)
+	}
+	return mazes, nil
+}
+
+func distinctFrom(m *Maze, others []*Maze) bool {
+	walls := m.Walls()
+	for _, other := range others {
+		if cellDifference(walls, other.Walls()) < BracketMinDifference {
+			return false
+		}
+	}
+	return true
+}
+
+// cellDifference is the share of cells that differ between two equally sized layouts
+func cellDifference(a, b [][]bool) float64 {
+	total, diff := 0, 0
+	for x := range a {
+		for y := range a[x] {
+			total++
+			if a[x][y] != b[x][y] {
+				diff++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(diff) / float64(total)
+}
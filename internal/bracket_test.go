@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGenerateBracketMazes(t *testing.T) {
+	mazes, err := GenerateBracketMazes(6, 15, 15, 99)
+	if err != nil || len(mazes) != 6 {
+		t.Fatalf("%d mazes generated, want 6: %v", len(mazes), err)
+	}
+	for i, m := range mazes {
+		if err := m.Validate(); err != nil {
+			t.Errorf("maze %d invalid: %v", i, err)
+		}
+		if d := m.DifficultyScore(); d < BracketMinDifficulty || d > BracketMaxDifficulty {
+			t.Errorf("maze %d difficulty %.2f outside %.2f-%.2f", i, d, BracketMinDifficulty, BracketMaxDifficulty)
+		}
+		for j := range i {
+			if diff := cellDifference(m.Walls(), mazes[j].Walls()); diff < BracketMinDifference {
+				t.Errorf("mazes %d and %d differ in only %.0f%% of cells", j, i, diff*100)
+			}
+		}
+	}
+
+	again, err := GenerateBracketMazes(6, 15, 15, 99)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range mazes {
+		if !reflect.DeepEqual(mazes[i].Message(), again[i].Message()) {
+			t.Errorf("maze %d differs when the bracket is rebuilt from its seed", i)
+		}
+	}
+}
+
+func TestGenerateBracketMazesRunsOut(t *testing.T) {
+	// No maze can be this hard, the bracket comes back short instead of looping
+	setFor(t, &BracketMinDifficulty, 2)
+	setFor(t, &BracketMaxAttempts, 3)
+	mazes, err := GenerateBracketMazes(2, 9, 9, 1)
+	if len(mazes) != 0 {
+		t.Errorf("%d mazes generated outside the difficulty band", len(mazes))
+	}
+	if !errors.Is(err, ErrBracketShort) {
+		t.Errorf("err = %v, want ErrBracketShort", err)
+	}
+}
+
+func TestGenerateBracketMazesRejectsBadArguments(t *testing.T) {
+	if mazes, err := GenerateBracketMazes(-1, 15, 15, 1); mazes != nil || err != nil {
+		t.Errorf("negative count gave %d mazes and %v, want neither", len(mazes), err)
+	}
+	if _, err := GenerateBracketMazes(2, 1, 1, 1); err == nil {
+		t.Error("a 1x1 bracket was accepted")
+	}
+}
@@ -0,0 +1,27 @@
+package internal
+
+var ChaosToggles = 0 // Cells toggled per tick in chaos mode, 0 disables it
+
+// perturbMaze toggles walls for chaos mode without closing occupied cells, must hold l.Mutex.
+func (l *Lobby) perturbMaze() {
+	if ChaosToggles <= 0 {
+		return
+	}
+	toggled := l.shiftWalls(ChaosToggles)
+	if len(toggled) > 0 {
+		l.logger().Debug("Chaos mode toggled cells", "cells", toggled)
+	}
+}
+
+// shiftWalls toggles up to n cells, never walling an occupied cell or a pickup. Must
+// hold l.Mutex. Pod locks are held throughout so no pod can step into a cell while
+// it is being walled.
+func (l *Lobby) shiftWalls(n int) []Point {
+	keepOpen := l.pickupCells()
+	pods, unlock := l.lockPods()
+	defer unlock()
+	for _, o := range pods {
+		keepOpen[pointOf(o.Position)] = true
+	}
+	return l.Maze.Perturb(l.chaosRand, n, keepOpen)
+}
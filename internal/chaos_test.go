@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChaosChangesMazeButKeepsItSolvable(t *testing.T) {
+	setFor(t, &ChaosToggles, 3)
+	setFor(t, &MaxInactive, 1000)
+	l := newTestLobby(t, 11, 11, 5)
+	o := addPod(t, l, "planner")
+
+	changed := 0
+	for tick := 1; tick <= 20; tick++ {
+		before := l.Maze.Walls()
+		l.Update()
+		if !reflect.DeepEqual(before, l.Maze.Walls()) {
+			changed++
+		}
+		if err := l.Maze.Validate(); err != nil {
+			t.Fatalf("tick %d: %v", tick, err)
+		}
+		if p := o.State().Position; l.Maze.Walls()[p.X][p.Y] {
+			t.Fatalf("tick %d: wall raised under the pod at %v", tick, p)
+		}
+	}
+	if changed < 10 {
+		t.Errorf("maze changed on %d of 20 ticks", changed)
+	}
+}
+
+func TestChaosIsSeeded(t *testing.T) {
+	setFor(t, &ChaosToggles, 3)
+	walls := func() [][]bool {
+		l := newTestLobby(t, 11, 11, 5)
+		for range 5 {
+			l.Update()
+		}
+		return l.Maze.Walls()
+	}
+	if !reflect.DeepEqual(walls(), walls()) {
+		t.Error("same seed toggled different cells")
+	}
+}
@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config gathers the deployment settings. LoadConfig starts from the package
+// defaults, reads a YAML file over them and lets environment variables override both.
+type Config struct {
+	Listen string `yaml:"listen"` // LISTEN_ADDR, or PORT for ":<port>"
+
+	Width  int   `yaml:"width"`  // MAZE_WIDTH
+	Height int   `yaml:"height"` // MAZE_HEIGHT
+	Seed   int64 `yaml:"seed"`   // SEED, random when 0
+
+	UpdateInterval  time.Duration `yaml:"updateInterval"`  // UPDATE_INTERVAL, e.g. 15s
+	TimeoutInterval time.Duration `yaml:"timeoutInterval"` // TIMEOUT_INTERVAL
+	MaxInactive     int           `yaml:"maxInactive"`     // MAX_INACTIVE, missed responses before a pod is dropped, at least 1
+	EventInterval   int           `yaml:"eventInterval"`   // EVENT_INTERVAL, ticks between maze events, 0 disables them
+	LobbyTTL        time.Duration `yaml:"lobbyTTL"`        // LOBBY_TTL, extra lobbies idle this long are removed, 0 keeps them
+
+	Generator     string             `yaml:"generator"`     // MAZE_GENERATOR, MazeGenerator when empty
+	Theme         string             `yaml:"theme"`         // MAZE_THEME, the viewer's colours, see Themes
+	ExtraExits    int                `yaml:"extraExits"`    // EXTRA_EXITS, exits opened besides the generator's own
+	ExitBearings  bool               `yaml:"exitBearings"`  // EXIT_BEARINGS, answers the exits command
+	Collisions    CollisionPolicy    `yaml:"collisions"`    // COLLISIONS
+	SensorPackage string             `yaml:"sensorPackage"` // SENSOR_PACKAGE
+	Scorer        string             `yaml:"scorer"`        // SCORER, time, steps or checkpoints
+	Handicaps     map[string]float64 `yaml:"handicaps"`     // HANDICAPS, e.g. alice=1.5,bob=0.8, scales the scorer per pod
+
+	RoundMode              bool          `yaml:"roundMode"`              // ROUND_MODE, rounds are started from the admin API
+	Countdown              time.Duration `yaml:"countdown"`              // COUNTDOWN, before a started round runs
+	RoundDuration          time.Duration `yaml:"roundDuration"`          // ROUND_DURATION, 0 runs until every pod has finished
+	MoveResolution         Resolution    `yaml:"moveResolution"`         // MOVE_RESOLUTION, immediate, last-wins, first-wins or queue
+	MaxIllegalMoves        int           `yaml:"maxIllegalMoves"`        // MAX_ILLEGAL_MOVES, 0 disables the limit
+	SensorTrail            bool          `yaml:"sensorTrail"`            // SENSOR_TRAIL
+	TrailLength            int           `yaml:"trailLength"`            // TRAIL_LENGTH
+	EdgeAsWall             bool          `yaml:"edgeAsWall"`             // EDGE_AS_WALL
+	RevealMaze             bool          `yaml:"revealMaze"`             // REVEAL_MAZE, disables fog of war
+	PreserveFogOnReconnect bool          `yaml:"preserveFogOnReconnect"` // PRESERVE_FOG_ON_RECONNECT
+	AllowFinishedReconnect bool          `yaml:"allowFinishedReconnect"` // ALLOW_FINISHED_RECONNECT
+	BroadcastPresence      bool          `yaml:"broadcastPresence"`      // BROADCAST_PRESENCE
+	ChaosToggles           int           `yaml:"chaosToggles"`           // CHAOS_TOGGLES, cells toggled per tick, 0 disables chaos mode
+	ReadBufferSize         int           `yaml:"readBufferSize"`         // READ_BUFFER_SIZE, 0 uses the websocket default
+	WriteBufferSize        int           `yaml:"writeBufferSize"`        // WRITE_BUFFER_SIZE
+
+	AdminToken string        `yaml:"adminToken"` // ADMIN_TOKEN, admin routes are disabled when empty
+	Viewer     bool          `yaml:"viewer"`     // VIEWER, serves the board viewer at /viewer
+	Discord    DiscordConfig `yaml:"discord"`
+	Log        LogConfig     `yaml:"log"`
+
+	StorePath string `yaml:"storePath"` // STORE_PATH
+	StatePath string `yaml:"statePath"` // STATE_PATH
+	ReplayDir string `yaml:"replayDir"` // REPLAY_DIR
+	FrameLog  string `yaml:"frameLog"`  // FRAME_LOG
+	PodLogDir string `yaml:"podLogDir"` // POD_LOG_DIR
+}
+
+type DiscordConfig struct {
+	Token     string `yaml:"token"`     // DISCORD_BOT_TOKEN
+	ChannelId string `yaml:"channelId"` // DISCORD_CHANNEL_ID
+	AdminRole string `yaml:"adminRole"` // DISCORD_ADMIN_ROLE, organizer commands are disabled when empty
+	GuildId   string `yaml:"guildId"`   // DISCORD_GUILD_ID, slash commands are global when empty
+	Offline   bool   `yaml:"offline"`   // DISCORD_OFFLINE, logs messages instead of connecting
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`  // LOG_LEVEL
+	Format string `yaml:"format"` // LOG_FORMAT
+	File   string `yaml:"file"`   // LOG_FILE
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Listen:          ":3000",
+		Width:           DefaultLobbyWidth,
+		Height:          DefaultLobbyHeight,
+		UpdateInterval:  UpdateInterval,
+		TimeoutInterval: TimeoutInterval,
+		MaxInactive:     MaxInactive,
+		EventInterval:   EventInterval,
+		LobbyTTL:        LobbyTTL,
+		Collisions:      Collisions,
+		SensorPackage:   DefaultSensorPackage,
+		Scorer:          "time",
+		Theme:           MazeTheme,
+		ExtraExits:      ExtraExitCount,
+		ExitBearings:    ExitBearings,
+		Viewer:          Viewer,
+
+		RoundMode:              RoundMode,
+		Countdown:              CountdownDuration,
+		RoundDuration:          RoundDuration,
+		MoveResolution:         MoveResolution,
+		MaxIllegalMoves:        MaxIllegalMoves,
+		SensorTrail:            SensorTrail,
+		TrailLength:            TrailLength,
+		EdgeAsWall:             EdgeAsWall,
+		RevealMaze:             RevealMaze,
+		PreserveFogOnReconnect: PreserveFogOnReconnect,
+		AllowFinishedReconnect: AllowFinishedReconnect,
+		BroadcastPresence:      BroadcastPresence,
+		ChaosToggles:           ChaosToggles,
+		ReadBufferSize:         ReadBufferSize,
+		WriteBufferSize:        WriteBufferSize,
+	}
+}
+
+// LoadConfig reads the YAML file at path, if any, applies the environment and validates the result
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return config, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true) // A typo must not silently fall back to the default
+		if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+			return config, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := config.readEnv(); err != nil {
+		return config, err
+	}
+	return config, config.Validate()
+}
+
+func (c *Config) readEnv() error {
+	if port := os.Getenv("PORT"); port != "" {
+		c.Listen = ":" + port
+	}
+	envString(&c.Listen, "LISTEN_ADDR")
+	envString(&c.Generator, "MAZE_GENERATOR")
+	envString(&c.Theme, "MAZE_THEME")
+	envString(&c.SensorPackage, "SENSOR_PACKAGE")
+	envString(&c.Scorer, "SCORER")
+	envString(&c.AdminToken, "ADMIN_TOKEN")
+	envString(&c.Discord.Token, "DISCORD_BOT_TOKEN")
+	envString(&c.Discord.ChannelId, "DISCORD_CHANNEL_ID")
+	envString(&c.Discord.AdminRole, "DISCORD_ADMIN_ROLE")
+	envString(&c.Discord.GuildId, "DISCORD_GUILD_ID")
+	envString(&c.Log.Level, "LOG_LEVEL")
+	envString(&c.Log.Format, "LOG_FORMAT")
+	envString(&c.Log.File, "LOG_FILE")
+	envString(&c.StorePath, "STORE_PATH")
+	envString(&c.StatePath, "STATE_PATH")
+	envString(&c.ReplayDir, "REPLAY_DIR")
+	envString(&c.FrameLog, "FRAME_LOG")
+	envString(&c.PodLogDir, "POD_LOG_DIR")
+	if policy := os.Getenv("COLLISIONS"); policy != "" {
+		c.Collisions = CollisionPolicy(policy)
+	}
+	if resolution := os.Getenv("MOVE_RESOLUTION"); resolution != "" {
+		c.MoveResolution = Resolution(resolution)
+	}
+	var handicapErr error
+	if value := os.Getenv("HANDICAPS"); value != "" {
+		c.Handicaps, handicapErr = parseHandicaps(value)
+	}
+
+	return errors.Join(
+		handicapErr,
+		envBool(&c.Discord.Offline, "DISCORD_OFFLINE"),
+		envBool(&c.Viewer, "VIEWER"),
+		envBool(&c.ExitBearings, "EXIT_BEARINGS"),
+		envBool(&c.RoundMode, "ROUND_MODE"),
+		envBool(&c.SensorTrail, "SENSOR_TRAIL"),
+		envBool(&c.EdgeAsWall, "EDGE_AS_WALL"),
+		envBool(&c.RevealMaze, "REVEAL_MAZE"),
+		envBool(&c.PreserveFogOnReconnect, "PRESERVE_FOG_ON_RECONNECT"),
+		envBool(&c.AllowFinishedReconnect, "ALLOW_FINISHED_RECONNECT"),
+		envBool(&c.BroadcastPresence, "BROADCAST_PRESENCE"),
+		envInt(&c.Width, "MAZE_WIDTH"),
+		envInt(&c.Height, "MAZE_HEIGHT"),
+		envInt(&c.MaxInactive, "MAX_INACTIVE"),
+		envInt(&c.EventInterval, "EVENT_INTERVAL"),
+		envInt(&c.ExtraExits, "EXTRA_EXITS"),
+		envInt(&c.MaxIllegalMoves, "MAX_ILLEGAL_MOVES"),
+		envInt(&c.TrailLength, "TRAIL_LENGTH"),
+		envInt(&c.ChaosToggles, "CHAOS_TOGGLES"),
+		envInt(&c.ReadBufferSize, "READ_BUFFER_SIZE"),
+		envInt(&c.WriteBufferSize, "WRITE_BUFFER_SIZE"),
+		envInt64(&c.Seed, "SEED"),
+		envDuration(&c.UpdateInterval, "UPDATE_INTERVAL"),
+		envDuration(&c.TimeoutInterval, "TIMEOUT_INTERVAL"),
+		envDuration(&c.LobbyTTL, "LOBBY_TTL"),
+		envDuration(&c.Countdown, "COUNTDOWN"),
+		envDuration(&c.RoundDuration, "ROUND_DURATION"),
+	)
+}
+
+// Validate reports every invalid setting at once, so a deployment is fixed in one go
+func (c *Config) Validate() error {
+	var errs []error
+	generator := MazeGenerator
+	if c.Generator != "" {
+		if g, err := GeneratorByName(c.Generator); err != nil {
+			errs = append(errs, err)
+		} else {
+			generator = g
+		}
+	}
+	if err := CheckSize(generator, c.Width, c.Height); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ThemeByName(c.Theme); err != nil {
+		errs = append(errs, err)
+	}
+	if c.UpdateInterval <= 0 {
+		errs = append(errs, fmt.Errorf("updateInterval must be positive, got %s", c.UpdateInterval))
+	}
+	if c.TimeoutInterval <= 0 {
+		errs = append(errs, fmt.Errorf("timeoutInterval must be positive, got %s", c.TimeoutInterval))
+	}
+	if c.MaxInactive < 1 {
+		// At 0 every pod would be dropped on the tick after it joins
+		errs = append(errs, fmt.Errorf("maxInactive must be at least 1, got %d", c.MaxInactive))
+	}
+	if c.LobbyTTL < 0 {
+		errs = append(errs, fmt.Errorf("lobbyTTL must not be negative, got %s", c.LobbyTTL))
+	}
+	if c.EventInterval < 0 {
+		errs = append(errs, fmt.Errorf("eventInterval must not be negative, got %d", c.EventInterval))
+	}
+	switch c.Collisions {
+	case CollisionStack, CollisionBlock, CollisionTag:
+	default:
+		errs = append(errs, fmt.Errorf("invalid collisions %q, expected stack, block or tag", c.Collisions))
+	}
+	if c.ExtraExits < 0 {
+		errs = append(errs, fmt.Errorf("extraExits must not be negative, got %d", c.ExtraExits))
+	}
+	if c.Countdown < 0 || c.RoundDuration < 0 {
+		errs = append(errs, fmt.Errorf("countdown and roundDuration must not be negative, got %s and %s", c.Countdown, c.RoundDuration))
+	}
+	switch c.MoveResolution {
+	case ResolveImmediately, ResolveLastWins, ResolveFirstWins, ResolveQueue:
+	default:
+		errs = append(errs, fmt.Errorf("invalid moveResolution %q, expected immediate, last-wins, first-wins or queue", c.MoveResolution))
+	}
+	if c.MaxIllegalMoves < 0 {
+		errs = append(errs, fmt.Errorf("maxIllegalMoves must not be negative, got %d", c.MaxIllegalMoves))
+	}
+	if c.SensorTrail && c.TrailLength <= 0 {
+		errs = append(errs, fmt.Errorf("trailLength must be positive with sensorTrail, got %d", c.TrailLength))
+	}
+	if c.ChaosToggles < 0 {
+		errs = append(errs, fmt.Errorf("chaosToggles must not be negative, got %d", c.ChaosToggles))
+	}
+	if c.ReadBufferSize < 0 || c.WriteBufferSize < 0 {
+		errs = append(errs, fmt.Errorf("buffer sizes must not be negative, got read %d and write %d", c.ReadBufferSize, c.WriteBufferSize))
+	}
+	if _, err := SensorPackageByName(c.SensorPackage); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := ScorerByName(c.Scorer); err != nil {
+		errs = append(errs, err)
+	}
+	for id, factor := range c.Handicaps {
+		if factor <= 0 {
+			errs = append(errs, fmt.Errorf("handicap for %s must be positive, got %v", id, factor))
+		}
+	}
+	if len(lowerHandicaps(c.Handicaps)) != len(c.Handicaps) {
+		errs = append(errs, errors.New("handicaps name the same pod twice, IDs are case-insensitive"))
+	}
+	if c.Discord.Token == "" && !c.Discord.Offline {
+		errs = append(errs, errors.New("discord token is not set (DISCORD_BOT_TOKEN), or set discord.offline"))
+	}
+	return errors.Join(errs...)
+}
+
+// Apply installs the settings that live in package variables. Call it before NewLobby.
+func (c *Config) Apply() error {
+	if err := ConfigureLogging(c.Log.Level, c.Log.Format, c.Log.File); err != nil {
+		return err
+	}
+	if c.Generator != "" {
+		generator, err := GeneratorByName(c.Generator)
+		if err != nil {
+			return err
+		}
+		MazeGenerator = generator
+	}
+	DefaultLobbyWidth, DefaultLobbyHeight = c.Width, c.Height
+	MazeTheme = strings.ToLower(c.Theme)
+	ExtraExitCount = c.ExtraExits
+	ExitBearings = c.ExitBearings
+	UpdateInterval = c.UpdateInterval
+	TimeoutInterval = c.TimeoutInterval
+	MaxInactive = c.MaxInactive
+	EventInterval = c.EventInterval
+	LobbyTTL = c.LobbyTTL
+	Collisions = c.Collisions
+	DefaultSensorPackage = strings.ToLower(c.SensorPackage)
+	scorer, err := ScorerByName(c.Scorer)
+	if err != nil {
+		return err
+	}
+	if len(c.Handicaps) > 0 {
+		scorer = HandicapScorer{Scorer: scorer}
+	}
+	DefaultScorer = scorer
+	Handicaps = lowerHandicaps(c.Handicaps)
+	AdminToken = c.AdminToken
+	Viewer = c.Viewer
+	FrameLogPath = c.FrameLog
+	PodLogDir = c.PodLogDir
+	ReplayDir = c.ReplayDir
+	StatePath = c.StatePath
+	RoundMode = c.RoundMode
+	CountdownDuration, RoundDuration = c.Countdown, c.RoundDuration
+	MoveResolution = c.MoveResolution
+	MaxIllegalMoves = c.MaxIllegalMoves
+	SensorTrail = c.SensorTrail
+	TrailLength = c.TrailLength
+	EdgeAsWall = c.EdgeAsWall
+	RevealMaze = c.RevealMaze
+	PreserveFogOnReconnect = c.PreserveFogOnReconnect
+	AllowFinishedReconnect = c.AllowFinishedReconnect
+	BroadcastPresence = c.BroadcastPresence
+	ChaosToggles = c.ChaosToggles
+	ReadBufferSize, WriteBufferSize = c.ReadBufferSize, c.WriteBufferSize
+	return nil
+}
+
+func envString(field *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*field = value
+	}
+}
+
+func envBool(field *bool, name string) error {
+	if value := os.Getenv(name); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = b
+	}
+	return nil
+}
+
+func envInt(field *int, name string) error {
+	if value := os.Getenv(name); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = n
+	}
+	return nil
+}
+
+func envInt64(field *int64, name string) error {
+	if value := os.Getenv(name); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = n
+	}
+	return nil
+}
+
+func envDuration(field *time.Duration, name string) error {
+	if value := os.Getenv(name); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		*field = d
+	}
+	return nil
+}
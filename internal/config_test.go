@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig saves content as a YAML file for LoadConfig
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigFeatureSwitches(t *testing.T) {
+	t.Setenv("DISCORD_OFFLINE", "true")
+	path := writeConfig(t, `
+roundMode: true
+countdown: 3s
+roundDuration: 0s
+moveResolution: queue
+maxIllegalMoves: 3
+sensorTrail: true
+trailLength: 4
+edgeAsWall: false
+revealMaze: true
+preserveFogOnReconnect: false
+allowFinishedReconnect: true
+broadcastPresence: true
+chaosToggles: 2
+extraExits: 1
+exitBearings: true
+handicaps:
+  alice: 1.5
+readBufferSize: 1024
+writeBufferSize: 8192
+`)
+	// The environment wins over the file
+	t.Setenv("MOVE_RESOLUTION", "last-wins")
+	t.Setenv("CHAOS_TOGGLES", "5")
+	t.Setenv("HANDICAPS", "Alice=1.5, BOB=0.5")
+	t.Setenv("BROADCAST_PRESENCE", "false")
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultConfig()
+	want.Discord.Offline = true
+	want.Handicaps = map[string]float64{"alice": 1.5, "bob": 0.5}
+	want.ExtraExits = 1
+	want.ExitBearings = true
+	want.RoundMode = true
+	want.Countdown = 3 * time.Second
+	want.RoundDuration = 0
+	want.MoveResolution = ResolveLastWins
+	want.MaxIllegalMoves = 3
+	want.SensorTrail = true
+	want.TrailLength = 4
+	want.EdgeAsWall = false
+	want.RevealMaze = true
+	want.PreserveFogOnReconnect = false
+	want.AllowFinishedReconnect = true
+	want.BroadcastPresence = false
+	want.ChaosToggles = 5
+	want.ReadBufferSize = 1024
+	want.WriteBufferSize = 8192
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("loaded %+v\nwant %+v", config, want)
+	}
+
+	for _, setting := range []*bool{&RoundMode, &SensorTrail, &EdgeAsWall, &RevealMaze, &PreserveFogOnReconnect, &AllowFinishedReconnect, &BroadcastPresence} {
+		setFor(t, setting, *setting)
+	}
+	for _, setting := range []*int{&MaxIllegalMoves, &TrailLength, &ChaosToggles, &ReadBufferSize, &WriteBufferSize} {
+		setFor(t, setting, *setting)
+	}
+	setFor(t, &MoveResolution, MoveResolution)
+	setFor(t, &ExtraExitCount, ExtraExitCount)
+	setFor(t, &ExitBearings, ExitBearings)
+	setFor(t, &CountdownDuration, CountdownDuration)
+	setFor(t, &RoundDuration, RoundDuration)
+	setFor(t, &DefaultScorer, DefaultScorer)
+	setFor(t, &Handicaps, Handicaps)
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if !RoundMode || CountdownDuration != 3*time.Second || RoundDuration != 0 || MoveResolution != ResolveLastWins || MaxIllegalMoves != 3 || !SensorTrail || TrailLength != 4 ||
+		EdgeAsWall || !RevealMaze || PreserveFogOnReconnect || !AllowFinishedReconnect || BroadcastPresence ||
+		ChaosToggles != 5 || ExtraExitCount != 1 || !ExitBearings || ReadBufferSize != 1024 || WriteBufferSize != 8192 {
+		t.Error("Apply did not install every feature switch")
+	}
+	if _, ok := DefaultScorer.(HandicapScorer); !ok || handicap("bob") != 0.5 || handicap("carol") != 1 {
+		t.Errorf("handicaps not installed, scorer %T", DefaultScorer)
+	}
+}
+
+func TestLoadConfigRejectsInvalidSwitches(t *testing.T) {
+	t.Setenv("DISCORD_OFFLINE", "true")
+	path := writeConfig(t, `
+moveResolution: sometimes
+maxIllegalMoves: -1
+sensorTrail: true
+trailLength: 0
+chaosToggles: -2
+readBufferSize: -1
+countdown: -1s
+theme: neon
+handicaps:
+  alice: 0
+`)
+	_, err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("invalid switches were accepted")
+	}
+	for _, setting := range []string{"moveResolution", "maxIllegalMoves", "trailLength", "chaosToggles", "buffer sizes", "countdown", "theme", "handicap"} {
+		if !strings.Contains(err.Error(), setting) {
+			t.Errorf("error does not mention %s: %v", setting, err)
+		}
+	}
+}
+
+func TestLoadConfigRejectsUnknownKeys(t *testing.T) {
+	t.Setenv("DISCORD_OFFLINE", "true")
+	if _, err := LoadConfig(writeConfig(t, "roundmode: true\n")); err == nil {
+		t.Error("a misspelt key was accepted")
+	}
+}
+
+func TestLoadConfigMaxInactiveBoundary(t *testing.T) {
+	t.Setenv("DISCORD_OFFLINE", "true")
+	t.Setenv("MAX_INACTIVE", "0")
+	if _, err := LoadConfig(""); err == nil || !strings.Contains(err.Error(), "maxInactive") {
+		t.Errorf("maxInactive 0 gave %v, want it rejected", err)
+	}
+	t.Setenv("MAX_INACTIVE", "1")
+	if _, err := LoadConfig(""); err != nil {
+		t.Errorf("maxInactive 1 rejected: %v", err)
+	}
+}
+
+func TestLoadConfigRejectsInvalidBooleans(t *testing.T) {
+	t.Setenv("DISCORD_OFFLINE", "true")
+	t.Setenv("REVEAL_MAZE", "TRUE")
+	config, err := LoadConfig("")
+	if err != nil || !config.RevealMaze {
+		t.Errorf("REVEAL_MAZE=TRUE gave %v, reveal %v", err, config.RevealMaze)
+	}
+	t.Setenv("REVEAL_MAZE", "yes")
+	if _, err := LoadConfig(""); err == nil || !strings.Contains(err.Error(), "REVEAL_MAZE") {
+		t.Errorf("REVEAL_MAZE=yes gave %v, want it rejected", err)
+	}
+}
+
+func TestHandicapsMatchMixedCaseIDs(t *testing.T) {
+	t.Setenv("DISCORD_OFFLINE", "true")
+	path := writeConfig(t, `
+handicaps:
+  Carol: 2
+`)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setFor(t, &DefaultScorer, DefaultScorer)
+	setFor(t, &Handicaps, Handicaps)
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	if err := config.Apply(); err != nil {
+		t.Fatal(err)
+	}
+
+	l := newTestLobby(t, 9, 9, 1)
+	addPod(t, l, "CAROL")
+	if board := l.Scoreboard(); len(board) != 1 || board[0].Handicap != 2 {
+		t.Errorf("scoreboard %+v, want carol at handicap 2", board)
+	}
+
+	path = writeConfig(t, `
+handicaps:
+  carol: 2
+  Carol: 3
+`)
+	if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "same pod twice") {
+		t.Errorf("handicaps differing only in case gave %v, want them rejected", err)
+	}
+}
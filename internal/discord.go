@@ -1,25 +1,47 @@
 package internal
 
 import (
+	"fmt"
 	"github.com/bwmarrin/discordgo"
 	"log"
-	"os"
+	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// Discord rejects messages longer than 2000 characters
+const MaxMessageLength = 2000
+
+var MaxIdLength = 32
+var MaxMessagesPerMinute = 20 // 0 disables rate limiting
+
 type DiscordBot struct {
 	Session   *discordgo.Session
 	ChannelId string
 	Lobby     *Lobby // Add reference to Lobby
+	Manager   *LobbyManager
+	AdminRole string // Members with this role may run organizer commands
+	GuildId   string // Where slash commands are registered, globally when empty
+
+	limiter *tokenBucket
+	outbox  outbox
 }
 
-func NewDiscordBot() *DiscordBot {
-	token := os.Getenv("DISCORD_BOT_TOKEN")
-	if token == "" {
+// NewDiscordBot opens the Discord session. An offline bot has no session and logs
+// its messages instead, so the server runs without Discord during development.
+func NewDiscordBot(config DiscordConfig) *DiscordBot {
+	if config.Offline {
+		slog.Info("Discord is offline, messages are logged instead")
+		return &DiscordBot{ChannelId: config.ChannelId, limiter: newTokenBucket(0, time.Minute)}
+	}
+	if config.Token == "" {
 		panic("DISCORD_BOT_TOKEN is not set")
 	}
-	session, err := discordgo.New("Bot " + token)
-	log.Println("New Discord bot created")
+	session, err := discordgo.New("Bot " + config.Token)
+	slog.Info("New Discord bot created")
 	if err != nil {
 		panic(err)
 	}
@@ -28,10 +50,15 @@ func NewDiscordBot() *DiscordBot {
 
 	bot := &DiscordBot{
 		Session:   session,
-		ChannelId: os.Getenv("DISCORD_CHANNEL_ID"),
+		ChannelId: config.ChannelId,
+		AdminRole: config.AdminRole,
+		GuildId:   config.GuildId,
+		limiter:   newTokenBucket(MaxMessagesPerMinute, time.Minute),
 	}
 
 	session.AddHandler(bot.makeMessageHandler())
+	session.AddHandler(bot.registerCommands)
+	session.AddHandler(bot.handleInteraction)
 
 	if err = session.Open(); err != nil {
 		log.Fatalf("Failed to open Discord session: %v", err)
@@ -50,44 +77,224 @@ func (d *DiscordBot) makeMessageHandler() func(*discordgo.Session, *discordgo.Me
 
 		if len(parts) >= 2 && parts[0] == "!where" {
 			id := parts[1]
-			log.Printf("Received Where command from discord. ID: %s", id)
+			slog.Info("Received !where from Discord", "octapod", id)
 
 			if d.Lobby == nil {
-				s.ChannelMessageSend(m.ChannelID, "Lobby not initialized.")
+				d.reply(m.ChannelID, "Lobby not initialized.")
 				return
 			}
 
-			mazeDisplay := d.Lobby.DisplayMaze(id)
-			_, err := s.ChannelMessageSend(m.ChannelID, mazeDisplay)
-			if err != nil {
-				log.Printf("Error sending maze display: %v", err)
+			d.reply(m.ChannelID, d.Lobby.DisplayMaze(id))
+			return
+		}
+
+		if len(parts) >= 1 && (parts[0] == "!lobbies" || parts[0] == "!lobby") {
+			reply := d.lobbyCommand(parts)
+			d.reply(m.ChannelID, reply)
+			return
+		}
+
+		if len(parts) >= 1 && parts[0] == "!status" {
+			reply := "Usage: `!status <ID>`"
+			if len(parts) >= 2 {
+				reply = "Lobby not initialized."
+				if d.Lobby != nil {
+					reply = d.Lobby.StatusReport(parts[1])
+				}
+			}
+			d.reply(m.ChannelID, reply)
+			return
+		}
+
+		if len(parts) == 1 && parts[0] == "!leaderboard" {
+			reply := "Lobby not initialized."
+			if d.Lobby != nil {
+				reply = d.Lobby.LeaderboardReport()
+			}
+			d.reply(m.ChannelID, reply)
+			return
+		}
+
+		if len(parts) == 1 && parts[0] == "!scores" {
+			reply := "Lobby not initialized."
+			if d.Lobby != nil {
+				reply = d.Lobby.Scoreboard().Render()
+			}
+			d.reply(m.ChannelID, reply)
+			return
+		}
+
+		if len(parts) >= 1 && parts[0] == "!round" {
+			reply := d.roundCommand(m, parts)
+			d.reply(m.ChannelID, reply)
+			return
+		}
+
+		if len(parts) == 1 && parts[0] == "!maze" {
+			reply := "Lobby not initialized."
+			if d.Lobby != nil {
+				reply = d.Lobby.DisplayMaze("")
 			}
+			d.reply(m.ChannelID, reply)
 			return
 		}
 
 		if len(parts) == 1 && parts[0] == "!where" {
-			_, err := s.ChannelMessageSend(m.ChannelID, "Usage: `!where <ID>`")
-			if err != nil {
-				log.Printf("Error sending usage: %v", err)
+			d.reply(m.ChannelID, "Usage: `!where <ID>`")
+		}
+	}
+}
+
+// lobbyCommand handles !lobbies, !lobby create <id> [width height] and !lobby close <id>
+func (d *DiscordBot) lobbyCommand(parts []string) string {
+	if d.Manager == nil {
+		return "Lobby manager not initialized."
+	}
+	if parts[0] == "!lobbies" {
+		lobbies := d.Manager.Lobbies()
+		if len(lobbies) == 0 {
+			return "No lobbies running."
+		}
+		lines := make([]string, 0, len(lobbies))
+		for _, info := range lobbies {
+			lines = append(lines, fmt.Sprintf("[%s] %dx%d, %d/%d octapods connected",
+				displayId(info.Id), info.Width, info.Height, info.Connected, info.Pods))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	usage := "Usage: `!lobby create <ID> [width height]` or `!lobby close <ID>`"
+	if len(parts) < 3 {
+		return usage
+	}
+	id := parts[2]
+	switch parts[1] {
+	case "create":
+		width, height := DefaultLobbyWidth, DefaultLobbyHeight
+		if len(parts) >= 5 {
+			var errW, errH error
+			width, errW = strconv.Atoi(parts[3])
+			height, errH = strconv.Atoi(parts[4])
+			if errW != nil || errH != nil {
+				return usage
 			}
 		}
+		if _, err := d.Manager.CreateLobby(id, width, height); err != nil {
+			return "Could not create lobby: " + err.Error()
+		}
+		return "Lobby [" + displayId(id) + "] created, join at /join/" + strings.ToLower(id)
+	case "close":
+		if err := d.Manager.RemoveLobby(id); err != nil {
+			return "Could not close lobby: " + err.Error()
+		}
+		return "Lobby [" + displayId(id) + "] closed"
+	default:
+		return usage
+	}
+}
+
+// roundCommand handles !round <generator> [sensors], limited to organizers
+func (d *DiscordBot) roundCommand(m *discordgo.MessageCreate, parts []string) string {
+	if !d.isOrganizer(m.Member) {
+		return "Only organizers can start a new round."
+	}
+	if len(parts) < 2 {
+		return "Usage: `!round <generator> [sensors]`"
+	}
+	if d.Lobby == nil {
+		return "Lobby not initialized."
+	}
+	if len(parts) >= 3 {
+		if _, err := d.Lobby.SetSensors(parts[2]); err != nil {
+			return "Could not start a new round: " + err.Error()
+		}
+	}
+	count, err := d.Lobby.NewRound(parts[1])
+	if err != nil {
+		return "Could not start a new round: " + err.Error()
 	}
+	return fmt.Sprintf("New %s round started, %d octapods disconnected.", strings.ToLower(parts[1]), count)
+}
+
+// isOrganizer reports whether the member holds AdminRole, nobody does when it is unset
+func (d *DiscordBot) isOrganizer(member *discordgo.Member) bool {
+	return d.AdminRole != "" && member != nil && slices.Contains(member.Roles, d.AdminRole)
 }
 
 func (d *DiscordBot) SetLobby(lobby *Lobby) {
 	d.Lobby = lobby
 }
 
+// Close posts what is still queued, waiting up to DiscordFlushTimeout, and closes the session
 func (d *DiscordBot) Close() {
+	d.flush(DiscordFlushTimeout)
+	if d.Session == nil {
+		return
+	}
 	err := d.Session.Close()
 	if err != nil {
 		return
 	}
 }
 
+// SendMessage queues a message for the channel, posted within the per-minute budget.
+// A nil bot, as in simulations, drops every message.
 func (d *DiscordBot) SendMessage(message string) {
-	_, err := d.Session.ChannelMessageSend(d.ChannelId, message)
-	if err != nil {
-		panic(err)
+	if d == nil {
+		return
+	}
+	d.enqueue(outboundMessage{text: message})
+}
+
+// SendBoard queues a board update. Only the latest board waiting for the budget is
+// posted, older pending boards are replaced.
+func (d *DiscordBot) SendBoard(message string) {
+	if d == nil {
+		return
+	}
+	d.enqueue(outboundMessage{text: message, board: true})
+}
+
+// reply queues the answer to a ! command behind the channel's other messages, so
+// commands spend the same per-minute budget
+func (d *DiscordBot) reply(channel, message string) {
+	d.enqueue(outboundMessage{text: message, channel: channel})
+}
+
+func (d *DiscordBot) send(message outboundMessage) error {
+	channel := message.channel
+	if channel == "" {
+		channel = d.ChannelId
+	}
+	if d.Session == nil {
+		slog.Info("Discord (offline)", "message", message.text, "channel", channel)
+		return nil
+	}
+	_, err := d.Session.ChannelMessageSend(channel, truncateMessage(message.text))
+	return err
+}
+
+// displayId elides octapod IDs that would bloat Discord messages
+func displayId(id string) string {
+	if utf8.RuneCountInString(id) <= MaxIdLength {
+		return id
+	}
+	runes := []rune(id)
+	return string(runes[:MaxIdLength-1]) + "…"
+}
+
+// truncateMessage cuts messages to Discord's length limit, closing any open code block
+func truncateMessage(message string) string {
+	if utf8.RuneCountInString(message) <= MaxMessageLength {
+		return message
+	}
+	slog.Warn("Truncating Discord message", "characters", utf8.RuneCountInString(message))
+
+	const ellipsis, fence = "\n…", "\n```"
+	runes := []rune(message)
+	cut := string(runes[:MaxMessageLength-len([]rune(ellipsis+fence))]) + ellipsis
+	if strings.Count(cut, "```")%2 == 1 {
+		cut += fence
 	}
+	return cut
 }
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateMessage(t *testing.T) {
+	short := "Board updated"
+	if got := truncateMessage(short); got != short {
+		t.Errorf("short message changed to %q", got)
+	}
+
+	board := "```\n" + strings.Repeat("██  🐙", MaxMessageLength) + "\n```"
+	got := truncateMessage(board)
+	if n := utf8.RuneCountInString(got); n > MaxMessageLength {
+		t.Errorf("truncated message has %d characters, limit is %d", n, MaxMessageLength)
+	}
+	if !utf8.ValidString(got) {
+		t.Error("truncation split a character")
+	}
+	if strings.Count(got, "```")%2 != 0 || !strings.HasSuffix(got, "…\n```") {
+		t.Errorf("code block not closed after the ellipsis: ...%q", got[len(got)-20:])
+	}
+
+	plain := strings.Repeat("x", MaxMessageLength+1)
+	if got := truncateMessage(plain); utf8.RuneCountInString(got) > MaxMessageLength || strings.Contains(got, "```") {
+		t.Errorf("plain message truncated to %d characters with a fence %v", utf8.RuneCountInString(got), strings.Contains(got, "```"))
+	}
+}
+
+func TestDisplayIdElidesLongIds(t *testing.T) {
+	if got := displayId("octo"); got != "octo" {
+		t.Errorf("displayId(octo) = %q", got)
+	}
+	long := strings.Repeat("八", MaxIdLength*4)
+	got := displayId(long)
+	if utf8.RuneCountInString(got) != MaxIdLength || !strings.HasSuffix(got, "…") {
+		t.Errorf("displayId of a long ID = %q, want %d characters ending in an ellipsis", got, MaxIdLength)
+	}
+}
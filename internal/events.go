@@ -0,0 +1,282 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EventKind string
+
+// Events announced to pods in an EventMessage. Walls, fog and pickup can also be
+// triggered by an admin.
+const (
+	EventWalls           EventKind = "walls"            // Some walls opened or closed
+	EventFog             EventKind = "fog"              // Sensors fall back to the basic package until the fog lifts
+	EventFogLifted       EventKind = "fog_lifted"       // Sensors are back to the lobby's package
+	EventPickup          EventKind = "pickup"           // A pickup was placed
+	EventPickupCollected EventKind = "pickup_collected" // A pod stepped onto a pickup
+)
+
+// EventInterval runs a random event from ScheduledEvents every n ticks, 0 only runs
+// events triggered by an admin
+var EventInterval = 0
+var ScheduledEvents = []EventKind{EventWalls, EventFog, EventPickup}
+
+var WallShifts = 3 // Cells toggled by a walls event
+var FogTicks = 5
+var MaxPickups = 5
+
+type PickupKind string
+
+const (
+	PickupScore PickupKind = "score" // Adds PickupPoints to the pod's score
+	PickupRange PickupKind = "range" // Extends the pod's sensor rays by RangeBoost for RangeBoostTicks
+)
+
+var PickupPoints = 10
+var RangeBoost = 3
+var RangeBoostTicks = 10
+
+type Pickup struct {
+	Kind     PickupKind `json:"kind"`
+	Position Point      `json:"position"`
+}
+
+// EventMessage announces a maze event to every connected pod
+type EventMessage struct {
+	Event  EventKind  `json:"event"`
+	Tick   int64      `json:"tick"`
+	Cells  []SeenCell `json:"cells,omitempty"`  // Walls: the toggled cells and what they are now
+	Until  int64      `json:"until,omitempty"`  // Fog: the tick it lifts on
+	Pickup *Pickup    `json:"pickup,omitempty"` // Pickup events
+	Id     string     `json:"id,omitempty"`     // The pod that collected the pickup
+}
+
+// mazeEvents is the lobby's event state. Its mutex is a leaf lock, pickups are
+// collected while holding the collecting pod's lock.
+type mazeEvents struct {
+	pickups  map[Point]Pickup
+	fogUntil int64
+	queue    []EventMessage // Announced once no lobby or pod lock is held, see flushEvents
+}
+
+// runEvents lifts the fog when it is due and runs the scheduled event, must hold l.Mutex
+func (l *Lobby) runEvents(tick int64) {
+	l.eventMutex.Lock()
+	lifted := l.events.fogUntil != 0 && tick >= l.events.fogUntil
+	if lifted {
+		l.events.fogUntil = 0
+	}
+	l.eventMutex.Unlock()
+	if lifted {
+		l.announce(EventMessage{Event: EventFogLifted, Tick: tick})
+	}
+
+	if EventInterval > 0 && tick%int64(EventInterval) == 0 && len(ScheduledEvents) > 0 {
+		if err := l.startEvent(ScheduledEvents[l.chaosRand.Intn(len(ScheduledEvents))], tick); err != nil {
+			l.logger().Debug("Scheduled maze event skipped", "err", err)
+		}
+	}
+}
+
+// startEvent runs one walls, fog or pickup event, must hold l.Mutex
+func (l *Lobby) startEvent(kind EventKind, tick int64) error {
+	switch kind {
+	case EventWalls:
+		toggled := l.shiftWalls(WallShifts)
+		if len(toggled) == 0 {
+			return errors.New("no wall could move without cutting off the exit")
+		}
+		cells := make([]SeenCell, 0, len(toggled))
+		l.Maze.mutex.RLock()
+		for _, p := range toggled {
+			cells = append(cells, SeenCell{X: p.X, Y: p.Y, Wall: !l.Maze.isOpen(p)})
+		}
+		l.Maze.mutex.RUnlock()
+		l.announce(EventMessage{Event: EventWalls, Tick: tick, Cells: cells})
+		l.DiscordBot.SendMessage(fmt.Sprintf("The walls are moving, %d cells changed!", len(cells)))
+	case EventFog:
+		until := tick + int64(max(FogTicks, 1))
+		l.eventMutex.Lock()
+		l.events.fogUntil = until
+		l.eventMutex.Unlock()
+		l.announce(EventMessage{Event: EventFog, Tick: tick, Until: until})
+		l.DiscordBot.SendMessage(fmt.Sprintf("Fog rolls in until tick %d.", until))
+	case EventPickup:
+		pickup, err := l.placePickup()
+		if err != nil {
+			return err
+		}
+		l.announce(EventMessage{Event: EventPickup, Tick: tick, Pickup: &pickup})
+		l.DiscordBot.SendMessage(fmt.Sprintf("A %s pickup appeared at (%d,%d).", pickup.Kind, pickup.Position.X, pickup.Position.Y))
+	default:
+		return fmt.Errorf("unknown event %q, expected walls, fog or pickup", kind)
+	}
+	l.logger().Info("Maze event", "event", kind, "tick", tick)
+	return nil
+}
+
+// placePickup puts a random pickup on a free open cell, must hold l.Mutex
+func (l *Lobby) placePickup() (Pickup, error) {
+	taken := l.pickupCells()
+	if len(taken) >= MaxPickups {
+		return Pickup{}, fmt.Errorf("already %d pickups on the board", len(taken))
+	}
+	for _, o := range l.Octapods {
+		o.Mutex.Lock()
+		taken[pointOf(o.Position)] = true
+		o.Mutex.Unlock()
+	}
+	m := l.Maze
+	m.mutex.RLock()
+	var free []Point
+	for x := 0; x < m.Width; x++ {
+		for y := 0; y < m.Height; y++ {
+			p := Point{x, y}
+			if m.isOpen(p) && p != m.Entrance && !m.IsExit(p) && !taken[p] {
+				free = append(free, p)
+			}
+		}
+	}
+	m.mutex.RUnlock()
+	if len(free) == 0 {
+		return Pickup{}, errors.New("no free cell for a pickup")
+	}
+
+	kind := PickupScore
+	if l.chaosRand.Intn(2) == 1 {
+		kind = PickupRange
+	}
+	pickup := Pickup{Kind: kind, Position: free[l.chaosRand.Intn(len(free))]}
+	l.eventMutex.Lock()
+	if l.events.pickups == nil {
+		l.events.pickups = make(map[Point]Pickup)
+	}
+	l.events.pickups[pickup.Position] = pickup
+	l.eventMutex.Unlock()
+	return pickup, nil
+}
+
+func (l *Lobby) pickupCells() map[Point]bool {
+	l.eventMutex.Lock()
+	defer l.eventMutex.Unlock()
+	cells := make(map[Point]bool, len(l.events.pickups))
+	for p := range l.events.pickups {
+		cells[p] = true
+	}
+	return cells
+}
+
+func (l *Lobby) pickups() []Pickup {
+	l.eventMutex.Lock()
+	defer l.eventMutex.Unlock()
+	pickups := make([]Pickup, 0, len(l.events.pickups))
+	for _, p := range l.events.pickups {
+		pickups = append(pickups, p)
+	}
+	return pickups
+}
+
+// collectPickup applies a pickup at the pod's new cell, must hold o.Mutex
+func (o *Octapod) collectPickup(cell Point) {
+	l := o.lobby
+	l.eventMutex.Lock()
+	pickup, exists := l.events.pickups[cell]
+	delete(l.events.pickups, cell)
+	l.eventMutex.Unlock()
+	if !exists {
+		return
+	}
+	tick := l.tick.Load()
+	switch pickup.Kind {
+	case PickupScore:
+		o.Score += PickupPoints
+	case PickupRange:
+		o.rangeBoostUntil = tick + int64(RangeBoostTicks)
+	}
+	o.logger().Info("Octapod collected a pickup", "kind", pickup.Kind)
+	l.announce(EventMessage{Event: EventPickupCollected, Tick: tick, Pickup: &pickup, Id: o.Id})
+}
+
+// sensorsFor is the package a pod senses with this tick, after fog and range
+// boosts. Must hold o.Mutex.
+func (o *Octapod) sensorsFor(pkg SensorPackage, tick int64, foggy bool) SensorPackage {
+	if foggy {
+		pkg = SensorPackage{}
+	}
+	if tick < o.rangeBoostUntil {
+		pkg.Range = max(pkg.Range, SensorRange) + RangeBoost
+	}
+	return pkg
+}
+
+func (l *Lobby) foggy(tick int64) bool {
+	l.eventMutex.Lock()
+	defer l.eventMutex.Unlock()
+	return l.events.fogUntil != 0 && tick < l.events.fogUntil
+}
+
+// resetEvents clears pickups and fog for a new round
+func (l *Lobby) resetEvents() {
+	l.eventMutex.Lock()
+	defer l.eventMutex.Unlock()
+	l.events = mazeEvents{}
+}
+
+// announce queues an event for flushEvents, it may be called under any lock
+func (l *Lobby) announce(event EventMessage) {
+	l.eventMutex.Lock()
+	defer l.eventMutex.Unlock()
+	l.events.queue = append(l.events.queue, event)
+}
+
+// flushEvents sends queued events to every connected pod, it must be called without
+// holding l.Mutex or any octapod lock
+func (l *Lobby) flushEvents() {
+	l.eventMutex.Lock()
+	queue := l.events.queue
+	l.events.queue = nil
+	l.eventMutex.Unlock()
+	if len(queue) == 0 {
+		return
+	}
+
+	l.Mutex.RLock()
+	pods := make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		pods = append(pods, o)
+	}
+	l.Mutex.RUnlock()
+	for _, event := range queue {
+		for _, o := range pods {
+			// Pods that are not connected simply miss the event
+			_ = o.Send(event)
+		}
+	}
+}
+
+// TriggerEvent runs a walls, fog or pickup event now
+func (l *Lobby) TriggerEvent(kind EventKind) error {
+	l.Mutex.Lock()
+	err := l.startEvent(kind, l.tick.Load())
+	l.Mutex.Unlock()
+	l.flushEvents()
+	return err
+}
+
+// HandleTriggerEvent runs the event given as ?event=walls, fog or pickup
+func (l *Lobby) HandleTriggerEvent(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	kind := EventKind(strings.ToLower(c.Query("event")))
+	if err := l.TriggerEvent(kind); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorMessage{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"event": kind, "pickups": l.pickups()})
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"math"
+	"sort"
+)
+
+// ExtraExitCount opens further exits in generated mazes, reaching any of them
+// finishes the maze. They are placed as far apart as the maze allows.
+var ExtraExitCount = 0
+
+// ExitBearings answers the exits command with a heading to every exit
+var ExitBearings = false
+
+// DisabledError is the ErrorMessage code for commands the lobby has turned off
+const DisabledError = "disabled"
+
+// Exits lists Exit followed by ExtraExits
+func (m *Maze) Exits() []Point {
+	return append([]Point{m.Exit}, m.ExtraExits...)
+}
+
+func (m *Maze) IsExit(p Point) bool {
+	if p == m.Exit {
+		return true
+	}
+	for _, exit := range m.ExtraExits {
+		if p == exit {
+			return true
+		}
+	}
+	return false
+}
+
+// pickExtraExits chooses up to n open cells reachable from the entrance, each time
+// taking the one furthest from the exits so far, then furthest from the entrance.
+// It draws no randomness, so seeded mazes stay reproducible. Must hold m.mutex.
+func (m *Maze) pickExtraExits(n int) []Point {
+	if n <= 0 {
+		return nil
+	}
+	fromEntrance := m.distancesFrom(m.Entrance)
+	candidates := make([]Point, 0, len(fromEntrance))
+	for p := range fromEntrance {
+		if p != m.Entrance && p != m.Exit {
+			candidates = append(candidates, p)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+
+	exits := []Point{m.Exit}
+	var extra []Point
+	for len(extra) < n && len(candidates) > 0 {
+		best, bestSpread := -1, -1
+		for i, p := range candidates {
+			spread := math.MaxInt
+			for _, exit := range exits {
+				spread = min(spread, abs(p.X-exit.X)+abs(p.Y-exit.Y))
+			}
+			if spread > bestSpread || (spread == bestSpread && fromEntrance[p] > fromEntrance[candidates[best]]) {
+				best, bestSpread = i, spread
+			}
+		}
+		exits = append(exits, candidates[best])
+		extra = append(extra, candidates[best])
+		candidates = append(candidates[:best], candidates[best+1:]...)
+	}
+	return extra
+}
+
+// ExitBearings gives the rough heading from p to every exit, in the order of Exits
+func (m *Maze) ExitBearings(p Point) []ExitBearing {
+	exits := m.Exits()
+	bearings := make([]ExitBearing, 0, len(exits))
+	for _, exit := range exits {
+		dx, dy := exit.X-p.X, exit.Y-p.Y
+		bearings = append(bearings, ExitBearing{
+			Bearing:  compassBearing(dx, dy),
+			Distance: distanceBucket(abs(dx) + abs(dy)),
+		})
+	}
+	return bearings
+}
+
+var compassPoints = [8]string{"E", "NE", "N", "NW", "W", "SW", "S", "SE"}
+
+// compassBearing rounds the offset to one of 8 compass points, y grows downwards
+func compassBearing(dx, dy int) string {
+	if dx == 0 && dy == 0 {
+		return "here"
+	}
+	angle := math.Atan2(float64(-dy), float64(dx))
+	sector := int(math.Round(angle/(math.Pi/4))+8) % 8
+	return compassPoints[sector]
+}
+
+// distanceBucket groups straight-line (Manhattan) distances
+func distanceBucket(distance int) string {
+	switch {
+	case distance <= 5:
+		return "near"
+	case distance <= 15:
+		return "medium"
+	default:
+		return "far"
+	}
+}
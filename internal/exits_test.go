@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+// exitMaze is an open maze with the given exits, see openMaze
+func exitMaze(t *testing.T, width, height int, exit Point, extra ...Point) *Maze {
+	t.Helper()
+	msg := openMaze(width, height)
+	msg.Exit, msg.ExtraExits = exit, extra
+	m, err := MazeFromMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestExtraExitsAreSpreadAndReachable(t *testing.T) {
+	setFor(t, &ExtraExitCount, 2)
+	m, err := NewMazeWithSeed(15, 15, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exits := m.Exits()
+	if len(exits) != 3 {
+		t.Fatalf("exits %v, want 3", exits)
+	}
+	distances := m.ExitDistances()
+	seen := map[Point]bool{}
+	for _, exit := range exits {
+		if seen[exit] || exit == m.Entrance {
+			t.Errorf("exit %v repeats or sits on the entrance", exit)
+		}
+		seen[exit] = true
+		if d, reachable := distances[exit]; !reachable || d != 0 {
+			t.Errorf("exit %v is %d from the nearest exit, reachable %v", exit, d, reachable)
+		}
+	}
+
+	again, err := NewMazeWithSeed(15, 15, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.ExtraExits, m.ExtraExits) {
+		t.Errorf("extra exits %v, then %v for the same seed", m.ExtraExits, again.ExtraExits)
+	}
+}
+
+func TestCompassBearing(t *testing.T) {
+	tests := []struct {
+		dx, dy int
+		want   string
+	}{
+		{0, 0, "here"},
+		{0, -4, "N"},
+		{3, -3, "NE"},
+		{5, -1, "E"},
+		{2, 2, "SE"},
+		{0, 7, "S"},
+		{-3, 4, "SW"},
+		{-6, 0, "W"},
+		{-1, -1, "NW"},
+	}
+	for _, tt := range tests {
+		if got := compassBearing(tt.dx, tt.dy); got != tt.want {
+			t.Errorf("compassBearing(%d, %d) = %s, want %s", tt.dx, tt.dy, got, tt.want)
+		}
+	}
+}
+
+func TestExitsCommandGivesBearingToEachExit(t *testing.T) {
+	l := newTestLobby(t, 5, 5, 1)
+	l.Maze = exitMaze(t, 13, 13, Point{12, 12}, Point{0, 3})
+	conn := dialPod(t, l, AuthMessage{ID: "scout", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+
+	setFor(t, &ExitBearings, false)
+	if err := conn.WriteJSON(CommandMessage{Type: ExitsCommand}); err != nil {
+		t.Fatal(err)
+	}
+	var refusal ErrorMessage
+	readEnvelope(t, conn, ErrorMessageType, &refusal)
+	if refusal.Code != DisabledError {
+		t.Errorf("refusal code %q, want %q", refusal.Code, DisabledError)
+	}
+
+	ExitBearings = true
+	if err := conn.WriteJSON(CommandMessage{Type: ExitsCommand}); err != nil {
+		t.Fatal(err)
+	}
+	var exits ExitsMessage
+	readEnvelope(t, conn, ExitsMessageType, &exits)
+	want := []ExitBearing{{Bearing: "SE", Distance: "far"}, {Bearing: "S", Distance: "near"}}
+	if !reflect.DeepEqual(exits.Exits, want) {
+		t.Errorf("bearings %+v, want %+v", exits.Exits, want)
+	}
+}
+
+func TestReachingAnExtraExitFinishes(t *testing.T) {
+	l := newTestLobby(t, 5, 5, 1)
+	l.Maze = exitMaze(t, 6, 6, Point{5, 5}, Point{1, 0})
+	o := addPod(t, l, "runner")
+	o.move(Right)
+	l.Update()
+	if state := o.State(); !state.Finished || state.DNF {
+		t.Errorf("finished %v dnf %v on an extra exit, want a finish", state.Finished, state.DNF)
+	}
+}
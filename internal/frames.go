@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var FrameLogPath = "" // Empty disables frame recording, lobbies other than the main one add their ID
+
+type BoardFrame struct {
+	Tick     int64      `json:"tick"`
+	Time     time.Time  `json:"time"`
+	Width    int        `json:"width"`
+	Height   int        `json:"height"`
+	Walls    [][]bool   `json:"walls"` // Indexed [x][y], true: wall
+	Octapods []PodFrame `json:"octapods"`
+}
+
+type PodFrame struct {
+	Id       string `json:"id"`
+	Tag      string `json:"tag"`
+	Position Point  `json:"position"`
+}
+
+// FrameRecorder writes one BoardFrame per line as JSON for offline rendering
+type FrameRecorder struct {
+	file    *os.File
+	encoder *json.Encoder
+	mutex   sync.Mutex
+}
+
+func NewFrameRecorder(path string) (*FrameRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FrameRecorder{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+// frameLogPath is where the lobby with the given ID records its frames, e.g.
+// frames-practice.jsonl for frames.jsonl. Empty for the main lobby or when disabled.
+func frameLogPath(id string) string {
+	if FrameLogPath == "" || id == "" {
+		return FrameLogPath
+	}
+	ext := filepath.Ext(FrameLogPath)
+	return strings.TrimSuffix(FrameLogPath, ext) + "-" + safeFileName(id) + ext
+}
+
+// Record does nothing once the recorder is closed
+func (r *FrameRecorder) Record(frame BoardFrame) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	return r.encoder.Encode(frame)
+}
+
+// Close is safe to call more than once
+func (r *FrameRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (l *Lobby) Frame() BoardFrame {
+	snapshot := l.Snapshot()
+	frame := BoardFrame{
+		Tick:     snapshot.Tick,
+		Time:     time.Now(),
+		Width:    snapshot.Maze.Width,
+		Height:   snapshot.Maze.Height,
+		Walls:    snapshot.Maze.Walls,
+		Octapods: make([]PodFrame, 0, len(snapshot.Pods)),
+	}
+	for _, p := range snapshot.Pods {
+		frame.Octapods = append(frame.Octapods, PodFrame{Id: p.Id, Tag: p.Tag, Position: p.Position})
+	}
+	return frame
+}
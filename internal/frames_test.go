@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFrameLogPath(t *testing.T) {
+	setFor(t, &FrameLogPath, "logs/frames.jsonl")
+	for id, want := range map[string]string{
+		"":         "logs/frames.jsonl",
+		"practice": "logs/frames-practice.jsonl",
+		"../up":    "logs/frames-" + safeFileName("../up") + ".jsonl",
+	} {
+		if got := frameLogPath(id); got != want {
+			t.Errorf("frameLogPath(%q) = %q, want %q", id, got, want)
+		}
+	}
+	setFor(t, &FrameLogPath, "")
+	if got := frameLogPath("practice"); got != "" {
+		t.Errorf("frameLogPath with recording disabled = %q, want empty", got)
+	}
+}
+
+func TestLobbiesRecordOwnFrames(t *testing.T) {
+	dir := t.TempDir()
+	setFor(t, &FrameLogPath, filepath.Join(dir, "frames.jsonl"))
+	m := newTestManager(t)
+	one, err := m.CreateLobby("one", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	two, err := m.CreateLobby("two", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one.Frames == nil || two.Frames == nil || one.Frames == two.Frames {
+		t.Fatal("lobbies do not have frame recorders of their own")
+	}
+	for _, name := range []string{"frames-one.jsonl", "frames-two.jsonl"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	one.Shutdown()
+	if one.Frames.file != nil {
+		t.Fatal("Shutdown left the frame log open")
+	}
+	if err := one.Frames.Record(one.Frame()); err != nil {
+		t.Errorf("Record after Shutdown: %v", err)
+	}
+	one.Shutdown()
+	if err := two.Frames.Record(two.Frame()); err != nil {
+		t.Errorf("other lobby's Record: %v", err)
+	}
+}
+
+func TestFrameRecordedEveryTick(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frames.jsonl")
+	setFor(t, &MaxInactive, 1000)
+	l := newLobby(6, 6, 1, nil)
+	recorder, err := NewFrameRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Frames = recorder
+	addPod(t, l, "runner")
+	l.SetUpdateInterval(5 * time.Millisecond)
+	l.StartTimer(time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for l.tick.Load() < 5 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	l.Shutdown()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var frames []BoardFrame
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var frame BoardFrame
+		if err := decoder.Decode(&frame); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) < 5 {
+		t.Fatalf("%d frames recorded, want at least 5", len(frames))
+	}
+	// One frame per tick, none skipped or repeated
+	for i, frame := range frames {
+		if frame.Tick != int64(i+1) {
+			t.Fatalf("frame %d is for tick %d, want %d", i, frame.Tick, i+1)
+		}
+		if len(frame.Octapods) != 1 || frame.Octapods[0].Id != "runner" {
+			t.Errorf("frame %d pods %+v, want runner", i, frame.Octapods)
+		}
+	}
+}
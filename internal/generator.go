@@ -0,0 +1,75 @@
+package internal
+
+import "math/rand"
+
+// Generator builds maze layouts. Generate returns cells indexed [x][y] with true for
+// walls, Endpoints picks the entrance and exit for such a layout.
+type Generator interface {
+	Generate(width, height int, rng *rand.Rand) [][]bool
+	Endpoints(cells [][]bool) (entrance, exit Point)
+}
+
+var MazeGenerator Generator = BacktrackerGenerator{}
+
+// BacktrackerGenerator carves a perfect maze with a randomised depth-first search
+// Start is at (0,0) and end is at (width-1,height-1)
+type BacktrackerGenerator struct{}
+
+func (BacktrackerGenerator) Generate(width, height int, rng *rand.Rand) [][]bool {
+	// First, fill the entire maze with walls
+	cells := make([][]bool, width)
+	for x := range cells {
+		cells[x] = make([]bool, height)
+		for y := range cells[x] {
+			cells[x][y] = true
+		}
+	}
+
+	// Use depth-first search with backtracking to create paths
+	// Start from (1,1) in cell coordinates
+	carvePassages(cells, rng, 1, 1)
+
+	// Create entrance (top-left) and exit (bottom-right)
+	cells[0][0] = false
+	cells[1][0] = false
+	cells[width-1][height-1] = false
+	cells[width-2][height-1] = false
+	return cells
+}
+
+func (BacktrackerGenerator) Endpoints(cells [][]bool) (Point, Point) {
+	return Point{0, 0}, Point{len(cells) - 1, len(cells[0]) - 1}
+}
+
+// carvePassages uses depth-first search with backtracking to carve passages
+func carvePassages(cells [][]bool, rng *rand.Rand, x, y int) {
+	// Mark the current cell as a passage
+	cells[x][y] = false
+
+	// Define the four possible directions: N, E, S, W
+	directions := []struct{ dx, dy int }{
+		{0, -2}, // North
+		{2, 0},  // East
+		{0, 2},  // South
+		{-2, 0}, // West
+	}
+
+	// Shuffle the directions for randomness
+	rng.Shuffle(len(directions), func(i, j int) {
+		directions[i], directions[j] = directions[j], directions[i]
+	})
+
+	// Try each direction
+	for _, dir := range directions {
+		newX, newY := x+dir.dx, y+dir.dy
+
+		// Check if the new position is within bounds and unvisited (still a wall)
+		if newX >= 0 && newX < len(cells) && newY >= 0 && newY < len(cells[newX]) && cells[newX][newY] {
+			// Carve a passage by removing the wall between current cell and new cell
+			cells[x+dir.dx/2][y+dir.dy/2] = false
+
+			// Continue DFS from the new cell
+			carvePassages(cells, rng, newX, newY)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// Generators can be picked by name, e.g. from config or the !round command
+var Generators = map[string]Generator{
+	"backtracker": BacktrackerGenerator{},
+	"prim":        PrimGenerator{},
+	"kruskal":     KruskalGenerator{},
+	"braided":     BraidGenerator{Base: BacktrackerGenerator{}, Chance: 0.5},
+	// Difficulty presets
+	"easy":   WideGenerator{Base: BraidGenerator{Base: PrimGenerator{}, Chance: 0.5}, Width: 2},
+	"normal": BacktrackerGenerator{},
+	"hard":   KruskalGenerator{},
+}
+
+func GeneratorByName(name string) (Generator, error) {
+	if g, ok := Generators[strings.ToLower(name)]; ok {
+		return g, nil
+	}
+	names := make([]string, 0, len(Generators))
+	for n := range Generators {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown maze generator %q, pick one of %s", name, strings.Join(names, ", "))
+}
+
+// MinMazeSize is the smallest board every generator can carve, the entrance and exit
+// corridors are two cells deep
+const MinMazeSize = 2
+
+// sizedGenerator is implemented by generators that need more than MinMazeSize
+type sizedGenerator interface {
+	MinSize() (width, height int)
+}
+
+func minSize(g Generator) (width, height int) {
+	if s, ok := g.(sizedGenerator); ok {
+		return s.MinSize()
+	}
+	return MinMazeSize, MinMazeSize
+}
+
+// CheckSize reports an error when g cannot generate a width x height maze
+func CheckSize(g Generator, width, height int) error {
+	minWidth, minHeight := minSize(g)
+	if width < minWidth || height < minHeight {
+		return fmt.Errorf("maze must be at least %dx%d for this generator, got %dx%d", minWidth, minHeight, width, height)
+	}
+	return nil
+}
+
+// cornerEndpoints places the entrance top-left and the exit bottom-right and
+// opens both
+type cornerEndpoints struct{}
+
+func (cornerEndpoints) Endpoints(cells [][]bool) (Point, Point) {
+	return BacktrackerGenerator{}.Endpoints(cells)
+}
+
+func walledGrid(width, height int) [][]bool {
+	cells := make([][]bool, width)
+	for x := range cells {
+		cells[x] = make([]bool, height)
+		for y := range cells[x] {
+			cells[x][y] = true
+		}
+	}
+	return cells
+}
+
+func openCorners(cells [][]bool) {
+	width, height := len(cells), len(cells[0])
+	cells[0][0] = false
+	cells[1][0] = false
+	cells[width-1][height-1] = false
+	cells[width-2][height-1] = false
+}
+
+// roomCells lists the odd coordinates passages are carved between, like the backtracker
+func roomCells(width, height int) []Point {
+	var rooms []Point
+	for x := 1; x < width; x += 2 {
+		for y := 1; y < height; y += 2 {
+			rooms = append(rooms, Point{x, y})
+		}
+	}
+	return rooms
+}
+
+var roomSteps = []Point{{0, -2}, {2, 0}, {0, 2}, {-2, 0}}
+
+// PrimGenerator grows the maze from one room with randomised Prim's algorithm, giving
+// many short dead ends
+type PrimGenerator struct{ cornerEndpoints }
+
+func (PrimGenerator) Generate(width, height int, rng *rand.Rand) [][]bool {
+	cells := walledGrid(width, height)
+	inBounds := func(p Point) bool { return p.X >= 0 && p.X < width && p.Y >= 0 && p.Y < height }
+
+	cells[1][1] = false
+	inFrontier := make(map[Point]bool)
+	var frontier []Point
+	addFrontier := func(p Point) {
+		for _, step := range roomSteps {
+			next := Point{p.X + step.X, p.Y + step.Y}
+			if inBounds(next) && cells[next.X][next.Y] && !inFrontier[next] {
+				inFrontier[next] = true
+				frontier = append(frontier, next)
+			}
+		}
+	}
+	addFrontier(Point{1, 1})
+
+	for len(frontier) > 0 {
+		i := rng.Intn(len(frontier))
+		p := frontier[i]
+		frontier[i] = frontier[len(frontier)-1]
+		frontier = frontier[:len(frontier)-1]
+
+		var carved []Point
+		for _, step := range roomSteps {
+			next := Point{p.X + step.X, p.Y + step.Y}
+			if inBounds(next) && !cells[next.X][next.Y] {
+				carved = append(carved, next)
+			}
+		}
+		from := carved[rng.Intn(len(carved))]
+		cells[p.X][p.Y] = false
+		cells[(p.X+from.X)/2][(p.Y+from.Y)/2] = false
+		addFrontier(p)
+	}
+
+	openCorners(cells)
+	return cells
+}
+
+// KruskalGenerator joins rooms through randomly ordered walls with Kruskal's
+// algorithm, spreading branches evenly across the board
+type KruskalGenerator struct{ cornerEndpoints }
+
+func (KruskalGenerator) Generate(width, height int, rng *rand.Rand) [][]bool {
+	cells := walledGrid(width, height)
+	rooms := roomCells(width, height)
+	parent := make(map[Point]Point, len(rooms))
+	for _, r := range rooms {
+		parent[r] = r
+		cells[r.X][r.Y] = false
+	}
+	var find func(Point) Point
+	find = func(p Point) Point {
+		if parent[p] != p {
+			parent[p] = find(parent[p])
+		}
+		return parent[p]
+	}
+
+	type wall struct{ a, b Point }
+	var walls []wall
+	for _, r := range rooms {
+		for _, step := range roomSteps[1:3] { // East and south, so each wall is listed once
+			next := Point{r.X + step.X, r.Y + step.Y}
+			if _, ok := parent[next]; ok {
+				walls = append(walls, wall{r, next})
+			}
+		}
+	}
+	rng.Shuffle(len(walls), func(i, j int) { walls[i], walls[j] = walls[j], walls[i] })
+
+	for _, w := range walls {
+		if ra, rb := find(w.a), find(w.b); ra != rb {
+			parent[ra] = rb
+			cells[(w.a.X+w.b.X)/2][(w.a.Y+w.b.Y)/2] = false
+		}
+	}
+
+	openCorners(cells)
+	return cells
+}
+
+// BraidGenerator removes dead ends from another generator's maze, each with the given
+// chance, by opening a wall into a neighbouring passage. Loops make mazes easier.
+type BraidGenerator struct {
+	Base   Generator
+	Chance float64
+}
+
+func (g BraidGenerator) Generate(width, height int, rng *rand.Rand) [][]bool {
+	cells := g.Base.Generate(width, height, rng)
+	m := &Maze{Width: width, Height: height, cells: cells}
+	for _, r := range roomCells(width, height) {
+		if cells[r.X][r.Y] || len(m.openNeighbours(r)) != 1 || rng.Float64() >= g.Chance {
+			continue
+		}
+		var walls []Point
+		for _, step := range roomSteps {
+			next := Point{r.X + step.X, r.Y + step.Y}
+			between := Point{r.X + step.X/2, r.Y + step.Y/2}
+			if m.isOpen(next) && !m.isOpen(between) {
+				walls = append(walls, between)
+			}
+		}
+		if len(walls) > 0 {
+			w := walls[rng.Intn(len(walls))]
+			cells[w.X][w.Y] = false
+		}
+	}
+	return cells
+}
+
+func (g BraidGenerator) Endpoints(cells [][]bool) (Point, Point) {
+	return g.Base.Endpoints(cells)
+}
+
+func (g BraidGenerator) MinSize() (int, int) {
+	return minSize(g.Base)
+}
+
+// WideGenerator widens corridors by generating a smaller maze and scaling every cell
+// up to a Width x Width block. Cells left over at the edges stay walls. Boards too
+// small for the scaled down base get the base maze unscaled.
+type WideGenerator struct {
+	Base  Generator
+	Width int
+}
+
+func (g WideGenerator) scale(width, height int) int {
+	scale := max(g.Width, 1)
+	minWidth, minHeight := minSize(g.Base)
+	if width/scale < minWidth || height/scale < minHeight {
+		return 1
+	}
+	return scale
+}
+
+func (g WideGenerator) Generate(width, height int, rng *rand.Rand) [][]bool {
+	scale := g.scale(width, height)
+	base := g.Base.Generate(width/scale, height/scale, rng)
+	cells := walledGrid(width, height)
+	for x := 0; x < len(base)*scale; x++ {
+		for y := 0; y < len(base[0])*scale; y++ {
+			cells[x][y] = base[x/scale][y/scale]
+		}
+	}
+	return cells
+}
+
+func (g WideGenerator) Endpoints(cells [][]bool) (Point, Point) {
+	scale := g.scale(len(cells), len(cells[0]))
+	w, h := len(cells)/scale*scale, len(cells[0])/scale*scale
+	return Point{0, 0}, Point{w - 1, h - 1}
+}
+
+func (g WideGenerator) MinSize() (int, int) {
+	return minSize(g.Base)
+}
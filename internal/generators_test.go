@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func generatorNames() []string {
+	names := make([]string, 0, len(Generators))
+	for name := range Generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Every generator and preset must handle the smallest boards the config, CreateLobby
+// and /newround accept
+func TestGeneratorsSmallSizes(t *testing.T) {
+	for _, name := range generatorNames() {
+		g := Generators[name]
+		for width := MinMazeSize; width <= 7; width++ {
+			for height := MinMazeSize; height <= 7; height++ {
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							t.Errorf("%s %dx%d panicked: %v", name, width, height, r)
+						}
+					}()
+					m := NewMaze(width, height)
+					if err := m.GenerateSolvableWith(g, rand.New(rand.NewSource(1))); err != nil {
+						t.Errorf("%s %dx%d: %v", name, width, height, err)
+					}
+				}()
+			}
+		}
+	}
+}
+
+func TestGeneratorsDeterministic(t *testing.T) {
+	for _, name := range generatorNames() {
+		a, b := NewMaze(21, 15), NewMaze(21, 15)
+		if err := a.GenerateFromSeed(Generators[name], 42); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := b.GenerateFromSeed(Generators[name], 42); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if a.Print() != b.Print() {
+			t.Errorf("%s generated different mazes from the same seed", name)
+		}
+		if a.Entrance != b.Entrance || a.Exit != b.Exit {
+			t.Errorf("%s placed different endpoints from the same seed", name)
+		}
+	}
+}
+
+func TestWideGeneratorScales(t *testing.T) {
+	g := WideGenerator{Base: BacktrackerGenerator{}, Width: 2}
+	cells := g.Generate(10, 10, rand.New(rand.NewSource(1)))
+	for x := 0; x < 10; x += 2 {
+		for y := 0; y < 10; y += 2 {
+			if cells[x][y] != cells[x+1][y] || cells[x][y] != cells[x][y+1] || cells[x][y] != cells[x+1][y+1] {
+				t.Fatalf("block at (%d,%d) is not uniform", x, y)
+			}
+		}
+	}
+	if entrance, exit := g.Endpoints(cells); entrance != (Point{0, 0}) || exit != (Point{9, 9}) {
+		t.Errorf("endpoints %v %v, want (0,0) and (9,9)", entrance, exit)
+	}
+	if _, exit := g.Endpoints(walledGrid(3, 3)); exit != (Point{2, 2}) {
+		t.Errorf("unscaled fallback exit %v, want (2,2)", exit)
+	}
+}
+
+type bigGenerator struct{ BacktrackerGenerator }
+
+func (bigGenerator) MinSize() (int, int) { return 5, 4 }
+
+func TestCheckSize(t *testing.T) {
+	if err := CheckSize(BacktrackerGenerator{}, 2, 2); err != nil {
+		t.Errorf("2x2 rejected: %v", err)
+	}
+	if err := CheckSize(BacktrackerGenerator{}, 1, 5); err == nil {
+		t.Error("1x5 accepted")
+	}
+	wide := WideGenerator{Base: BraidGenerator{Base: bigGenerator{}}, Width: 2}
+	if err := CheckSize(wide, 4, 4); err == nil {
+		t.Error("4x4 accepted for a base needing 5x4")
+	}
+	if err := CheckSize(wide, 5, 4); err != nil {
+		t.Errorf("5x4 rejected: %v", err)
+	}
+}
+
+func TestResizeRoundSmallPreset(t *testing.T) {
+	l := newTestLobby(t, 10, 10, 1)
+	l.Generator = Generators["easy"]
+	if _, err := l.ResizeRound(2, 3, 7); err != nil {
+		t.Fatal(err)
+	}
+	if l.Maze.Width != 2 || l.Maze.Height != 3 {
+		t.Errorf("maze is %dx%d, want 2x3", l.Maze.Width, l.Maze.Height)
+	}
+	l.Generator = bigGenerator{}
+	if _, err := l.ResizeRound(3, 3, 7); err == nil {
+		t.Error("resized below the generator's minimum")
+	}
+	// The lobby lock must be free after a refused resize
+	within(t, 2*time.Second, func() { l.Snapshot() })
+}
+
+// stripeGenerator walls every other column except its top cell, a trivial zig-zag
+type stripeGenerator struct{}
+
+func (stripeGenerator) Generate(width, height int, rng *rand.Rand) [][]bool {
+	cells := make([][]bool, width)
+	for x := range cells {
+		cells[x] = make([]bool, height)
+		if x%2 == 1 {
+			for y := 1; y < height; y++ {
+				cells[x][y] = true
+			}
+		}
+	}
+	return cells
+}
+
+func (stripeGenerator) Endpoints(cells [][]bool) (Point, Point) {
+	return Point{0, len(cells[0]) - 1}, Point{len(cells) - 1, len(cells[0]) - 1}
+}
+
+func TestLobbyUsesCustomGenerator(t *testing.T) {
+	setFor[Generator](t, &MazeGenerator, stripeGenerator{})
+	l := newTestLobby(t, 7, 5, 1)
+	want := stripeGenerator{}.Generate(7, 5, nil)
+	if got := l.Maze.Walls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("lobby maze %v, want the custom generator's %v", got, want)
+	}
+	if l.Maze.Entrance != (Point{0, 4}) || l.Maze.Exit != (Point{6, 4}) {
+		t.Errorf("endpoints %v and %v, want the custom generator's", l.Maze.Entrance, l.Maze.Exit)
+	}
+
+	// A lobby's own generator wins over the default on regeneration
+	setFor(t, &MazeGenerator, Generators["prim"])
+	l.Generator = stripeGenerator{}
+	if _, err := l.ResetAll(true, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Maze.Walls(); !reflect.DeepEqual(got, want) {
+		t.Error("regenerated maze ignores the lobby's generator")
+	}
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Handicaps maps pod IDs to a difficulty factor for mixed-skill play. A pod given a
+// harder region or a stronger bot gets a factor above 1, which raises its rating
+// against the others. Pods without an entry play at 1.
+var Handicaps = map[string]float64{}
+
+// handicap is the pod's difficulty factor, 1 when it has none
+func handicap(id string) float64 {
+	if factor, ok := Handicaps[id]; ok {
+		return factor
+	}
+	return 1
+}
+
+// HandicapScorer scales the ratings of another Scorer by each pod's handicap.
+// Ratings are higher-is-better but may be negative, e.g. minus the ticks taken, so
+// a factor above 1 multiplies positive ratings and divides negative ones.
+type HandicapScorer struct {
+	Scorer Scorer
+}
+
+func (s HandicapScorer) Score(e ScoreEntry) (float64, bool) {
+	score, ranked := s.Scorer.Score(e)
+	factor := e.Handicap
+	if factor <= 0 {
+		factor = 1
+	}
+	if score < 0 {
+		return score / factor, ranked
+	}
+	return score * factor, ranked
+}
+
+// lowerHandicaps keys the factors by lowercase ID, as pods are stored
+func lowerHandicaps(handicaps map[string]float64) map[string]float64 {
+	lowered := make(map[string]float64, len(handicaps))
+	for id, factor := range handicaps {
+		lowered[strings.ToLower(id)] = factor
+	}
+	return lowered
+}
+
+// parseHandicaps reads "id=factor" pairs separated by commas, e.g. from HANDICAPS
+func parseHandicaps(value string) (map[string]float64, error) {
+	handicaps := map[string]float64{}
+	for _, pair := range strings.Split(value, ",") {
+		id, factor, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid handicap %q, expected id=factor", pair)
+		}
+		f, err := strconv.ParseFloat(factor, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid handicap for %s: %w", id, err)
+		}
+		handicaps[strings.ToLower(id)] = f
+	}
+	return handicaps, nil
+}
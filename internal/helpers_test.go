@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// newTestLobby builds a lobby with no timer and no Discord bot, driven by calling
+// Update directly
+func newTestLobby(t *testing.T, width, height int, seed int64) *Lobby {
+	t.Helper()
+	return newLobby(width, height, seed, nil)
+}
+
+// addPod registers a polling pod, so no connection is needed
+func addPod(t *testing.T, l *Lobby, id string) *Octapod {
+	t.Helper()
+	return joinPod(t, l, AuthMessage{ID: id, Password: "secret", Version: 1})
+}
+
+// joinPod registers or reconnects a polling pod with auth
+func joinPod(t *testing.T, l *Lobby, auth AuthMessage) *Octapod {
+	t.Helper()
+	o, err := l.identifyOctapod(&auth, nil, nextConnId())
+	if err != nil {
+		t.Fatalf("registering %s: %v", auth.ID, err)
+	}
+	return o
+}
+
+// takeSensor returns the sensor waiting for the pod, nil when there is none
+func takeSensor(o *Octapod) *Sensor {
+	select {
+	case s := <-o.Sensor:
+		return s
+	default:
+		return nil
+	}
+}
+
+// setFor sets a package setting for the rest of the test
+func setFor[T any](t *testing.T, setting *T, value T) {
+	t.Helper()
+	old := *setting
+	*setting = value
+	t.Cleanup(func() { *setting = old })
+}
+
+// within fails the test if f does not return in time, deadlocks show up as a failure
+// instead of a hung test binary
+func within(t *testing.T, timeout time.Duration, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatalf("did not finish within %s, likely a deadlock", timeout)
+	}
+}
+
+// dialPod joins the lobby over a real websocket and sends auth, the connection is
+// closed when the test ends
+func dialPod(t *testing.T, l *Lobby, auth AuthMessage) *websocket.Conn {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/join", l.HandleJoin)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/join", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteJSON(auth); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// dialHandler connects to a server that upgrades and hands the connection to serve
+func dialHandler(t *testing.T, serve func(conn *websocket.Conn)) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := newUpgrader()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		serve(conn)
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readEnvelope reads protocol 2 messages until one of type want arrives and decodes
+// its payload into v
+func readEnvelope(t *testing.T, conn *websocket.Conn, want MessageType, v any) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var envelope Envelope
+		if err := conn.ReadJSON(&envelope); err != nil {
+			t.Fatalf("waiting for a %s message: %v", want, err)
+		}
+		if envelope.Type == want {
+			if err := json.Unmarshal(envelope.Payload, v); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gbccsclub/octopod-challenge/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+var LeaderboardSize = 10 // Rows shown on Discord
+
+// saveResult persists the pod's current outcome, a no-op without a store
+func (l *Lobby) saveResult(o *Octapod) {
+	if l.Store == nil {
+		return
+	}
+	state := o.State()
+	result := store.Result{
+		Id:          state.Id,
+		Seed:        l.mazeSeed(),
+		Team:        state.Team,
+		Steps:       state.Steps,
+		Finished:    state.Finished,
+		DNF:         state.DNF,
+		Disconnects: state.Disconnects,
+		UpdatedAt:   time.Now(),
+	}
+	if state.Finished && !state.DNF && state.FinishedAt != nil {
+		result.CompletionMs = state.FinishedAt.Sub(state.JoinedAt).Milliseconds()
+	}
+	if err := l.Store.Save(result); err != nil {
+		o.logger().Error("Error saving result", "err", err)
+	}
+}
+
+// HandleLeaderboard serves stored results best first, ?limit= caps the rows
+func (l *Lobby) HandleLeaderboard(c *gin.Context) {
+	if l.Store == nil {
+		c.JSON(http.StatusNotFound, ErrorMessage{Error: "No leaderboard store configured."})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	results, err := l.Store.Leaderboard(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorMessage{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// LeaderboardReport formats the top stored results for Discord
+func (l *Lobby) LeaderboardReport() string {
+	if l.Store == nil {
+		return "No leaderboard store configured."
+	}
+	results, err := l.Store.Leaderboard(LeaderboardSize)
+	if err != nil {
+		l.logger().Error("Error reading leaderboard", "err", err)
+		return "Could not read the leaderboard."
+	}
+	if len(results) == 0 {
+		return "The leaderboard is empty."
+	}
+	lines := make([]string, 0, len(results))
+	for i, r := range results {
+		status := "unfinished"
+		switch {
+		case r.DNF:
+			status = "forfeited"
+		case r.Finished:
+			status = fmt.Sprintf("finished in %.1fs", float64(r.CompletionMs)/1000)
+		}
+		lines = append(lines, fmt.Sprintf("%d. [%s] seed %d, %s, %d steps, %d disconnects",
+			i+1, displayId(r.Id), r.Seed, status, r.Steps, r.Disconnects))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The server pings every connection each PingInterval. A connection that sends
+// nothing, pongs included, for PongWait is treated as half-open and closed, freeing
+// the slot for a reconnect. HTTP polling sessions that make no request for PongWait
+// end the same way. PingInterval 0 disables both.
+var PingInterval = 10 * time.Second
+var PongWait = 25 * time.Second
+
+// touch records that the current connection is alive
+func (o *Octapod) touch() {
+	o.lastSeen.Store(time.Now().UnixNano())
+}
+
+// keepAlive arms the read deadline and extends it on every pong
+func (o *Octapod) keepAlive(conn *websocket.Conn) {
+	if PingInterval <= 0 {
+		return
+	}
+	o.touch()
+	_ = conn.SetReadDeadline(time.Now().Add(PongWait))
+	conn.SetPongHandler(func(string) error {
+		o.touch()
+		if sent := o.pingSent.Swap(0); sent != 0 {
+			o.rtt.Store(time.Now().UnixNano() - sent)
+		}
+		return conn.SetReadDeadline(time.Now().Add(PongWait))
+	})
+}
+
+// extendDeadline counts any message from the client as a sign of life
+func (o *Octapod) extendDeadline(conn *websocket.Conn) {
+	if PingInterval <= 0 {
+		return
+	}
+	o.touch()
+	_ = conn.SetReadDeadline(time.Now().Add(PongWait))
+}
+
+// ping records when it was sent, so the pong can measure the round trip
+func (o *Octapod) ping(conn *websocket.Conn) error {
+	o.pingSent.Store(time.Now().UnixNano())
+	return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(PongWait))
+}
+
+// reapDeadConnections closes connections that outlived PongWait without a sign of
+// life, in case a read deadline could not be armed or a pump is stuck
+func (l *Lobby) reapDeadConnections(ctx context.Context) {
+	if PingInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		l.Mutex.RLock()
+		pods := make([]*Octapod, 0, len(l.Octapods))
+		for _, o := range l.Octapods {
+			pods = append(pods, o)
+		}
+		l.Mutex.RUnlock()
+
+		deadline := time.Now().Add(-PongWait).UnixNano()
+		for _, o := range pods {
+			o.Mutex.Lock()
+			conn, polling := o.Conn, o.polling
+			o.Mutex.Unlock()
+			if (conn == nil && !polling) || o.lastSeen.Load() >= deadline {
+				continue
+			}
+			o.logger().Info("Reaping dead connection", "silentFor", time.Since(time.Unix(0, o.lastSeen.Load())))
+			l.metrics.deadConnections.Inc()
+			if conn == nil {
+				o.endPoll()
+			} else {
+				o.disconnectConn(conn)
+			}
+		}
+	}
+}
@@ -1,29 +1,92 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math/rand"
 	"net/http"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"gbccsclub/octopod-challenge/internal/store"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/quartercastle/vector"
 )
 
 var UpdateInterval = 1 * 15 * time.Second
 var TimeoutInterval = 1 * time.Second
 var MaxInactive = 2
+var MaxTickMultiplier = 10
+
+// Websocket buffer sizes in bytes, 0 uses the gorilla default of 4096. Buffers are
+// allocated per connection, so keep them small for many pods and raise
+// WriteBufferSize when sensor payloads (e.g. revealed mazes) are large.
+var ReadBufferSize = 0
+var WriteBufferSize = 0
+
+// PreserveFogOnReconnect keeps the cells a pod discovered when it reconnects
+var PreserveFogOnReconnect = true
+
+// RevealMaze sends the full maze to pods after authentication, disabling fog of war
+var RevealMaze = false
+
+// BroadcastPresence tells connected pods when another pod joins or leaves
+var BroadcastPresence = false
+
+// GoodbyeTimeout bounds how long a closing connection waits for the client to
+// acknowledge the goodbye message
+var GoodbyeTimeout = 250 * time.Millisecond
+
+// AllowFinishedReconnect lets finished pods reconnect to view their result, they still can't move
+var AllowFinishedReconnect = false
 
 type Lobby struct {
 	DiscordBot   *DiscordBot
 	Maze         *Maze
 	Octapods     map[string]*Octapod
+	Seed         int64
+	MazeSeed     int64 // Regenerates the current maze with its generator
+	Frames       *FrameRecorder
+	Store        store.Store // Optional, persists results for the leaderboard
+	Generator    Generator   // Used for new rounds, MazeGenerator when nil
+	Scorer       Scorer      // Ranks the scoreboard, DefaultScorer when nil
+	Sensors      SensorPackage
+	stats        LobbyStats
+	metrics      *lobbyMetrics
+	metricsOnce  sync.Once
+	metricsPage  http.Handler // Built by HandleMetrics on first use
+	spectators   spectatorHub
+	mazeRand     *rand.Rand
+	chaosRand    *rand.Rand
+	sensorRand   *rand.Rand // Only used by Update
 	Mutex        sync.RWMutex
 	timerRunning bool
+	stopTimer    context.CancelFunc
+	ctx          context.Context // Cancelled by Shutdown, ending the timer and every pod's pumps
+	paused       atomic.Bool
+	round        round
+	match        atomic.Pointer[MatchRecorder]
+	slogger      atomic.Pointer[slog.Logger]
+	roundMutex   sync.Mutex
+	interval     atomic.Int64       // Update interval in nanoseconds, 0 uses UpdateInterval
+	occupied     map[Point]*Octapod // Cells held by connected pods, when stacking is off
+	occupyMutex  sync.Mutex
+	tick         atomic.Int64
+	tokens       map[string]*sessionToken // Reconnect tokens by pod ID
+	tokenMutex   sync.Mutex
+	moveQuotas   map[string]*moveQuota // Move rate limits by pod ID
+	quotaMutex   sync.Mutex
+	teams        map[string]*team // By lowercased name
+	events       mazeEvents
+	eventMutex   sync.Mutex
 }
 
 type Point struct {
@@ -31,22 +94,68 @@ type Point struct {
 	Y int `json:"y"`
 }
 
-func NewLobby(width, height int) *Lobby {
-	maze := NewMaze(width, height)
-	maze.Generate()
+func pointOf(v vector.Vector) Point {
+	return Point{int(v.X()), int(v.Y())}
+}
+
+// NewLobby opens the Discord bot and creates the main lobby from config, call
+// config.Apply first. A seed of 0 picks a random one, the seed is logged so a board can be reproduced.
+func NewLobby(config Config) *Lobby {
+	bot := NewDiscordBot(config.Discord)
+	lobby := NewLobbyWithBot(config.Width, config.Height, config.Seed, bot, frameLogPath(""))
+	bot.SetLobby(lobby)
+	return lobby
+}
+
+// NewLobbyWithBot creates a lobby that posts through an existing bot, so several
+// lobbies can share one Discord session. It records board frames to frameLog unless empty.
+func NewLobbyWithBot(width, height int, seed int64, bot *DiscordBot, frameLog string) *Lobby {
+	lobby := newLobby(width, height, seed, bot)
+	if frameLog != "" {
+		if frames, err := NewFrameRecorder(frameLog); err != nil {
+			lobby.logger().Error("Failed to open frame log, frames are not recorded", "path", frameLog, "err", err)
+		} else {
+			lobby.Frames = frames
+			lobby.logger().Info("Recording board frames", "path", frameLog)
+		}
+	}
 
-	bot := NewDiscordBot()
+	if logger := lobby.logger(); logger.Enabled(context.Background(), slog.LevelDebug) {
+		logger.Debug("Maze generated", "maze", lobby.Maze.Print())
+	}
+	lobby.openMatch()
+	lobby.StartTimer(TimeoutInterval)
+	return lobby
+}
+
+// newLobby generates the maze and sets up the lobby without starting its timer
+func newLobby(width, height int, seed int64, bot *DiscordBot) *Lobby {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	// Each maze has its own seed so a round can be replayed on the same board
+	mazeSeed := deriveSeed(seed, "maze")
+	slog.Info("Lobby seed", "seed", seed, "mazeSeed", mazeSeed)
+
+	mazeRand := rand.New(rand.NewSource(mazeSeed))
+	maze := NewMaze(width, height)
+	if err := maze.GenerateSolvable(mazeRand); err != nil {
+		panic(err)
+	}
 
 	lobby := &Lobby{
 		DiscordBot: bot,
 		Maze:       maze,
 		Octapods:   make(map[string]*Octapod),
+		Seed:       seed,
+		MazeSeed:   mazeSeed,
+		occupied:   make(map[Point]*Octapod),
+		metrics:    newLobbyMetrics(),
+		mazeRand:   mazeRand,
+		chaosRand:  newRand(seed, "chaos"),
+		sensorRand: newRand(seed, "sensor"),
+		Sensors:    SensorPackages[DefaultSensorPackage],
 	}
-
-	bot.SetLobby(lobby)
-
-	fmt.Println(maze.Print())
-	lobby.StartTimer(TimeoutInterval)
 	return lobby
 }
 
@@ -54,28 +163,63 @@ func (l *Lobby) StartTimer(timeout time.Duration) {
 	l.Mutex.Lock()
 	if l.timerRunning {
 		l.Mutex.Unlock()
-		log.Println("Timer already running.")
+		l.logger().Warn("Timer already running")
 		return
 	}
 	l.timerRunning = true
+	ctx, cancel := context.WithCancel(context.Background())
+	l.ctx, l.stopTimer = ctx, cancel
 	l.Mutex.Unlock()
-
-	duration := UpdateInterval
+	go l.reapDeadConnections(ctx)
 
 	go func() {
-		t := duration
+		t := l.UpdateInterval()
 		isTimeout := false
+		idle := false
 		for {
+			duration := l.UpdateInterval()
 			timer := time.NewTimer(t)
-			<-timer.C
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				l.logger().Info("Timer stopped")
+				return
+			}
+			// Paused lobbies keep their state, no pod is ticked or counted inactive
+			if l.paused.Load() || l.Phase() != PhaseRunning {
+				t = duration
+				isTimeout = false
+				continue
+			}
+			// Nothing to update or report without connected pods
+			if l.ConnectedCount() == 0 {
+				if !idle {
+					l.logger().Info("Lobby idle, skipping updates until an octapod connects")
+					idle = true
+				}
+				t = duration
+				isTimeout = false
+				continue
+			}
+			idle = false
 			if !isTimeout {
+				start := time.Now()
 				l.Update()
-				log.Println("Sensor data pinged (Update)")
+				l.stats.recordUpdate(time.Since(start))
+				l.metrics.updateDuration.Observe(time.Since(start).Seconds())
+				l.logger().Debug("Sensor data pinged (Update)", "tick", l.tick.Load())
+				if l.Frames != nil {
+					if err := l.Frames.Record(l.Frame()); err != nil {
+						l.logger().Error("Error recording frame", "err", err)
+					}
+				}
+				l.publishSnapshot()
 				t = timeout
 			} else {
 				l.TimeoutUpdate()
-				log.Println("Timeout update")
-				l.DiscordBot.SendMessage("Board updated:\n" + l.DisplayMaze(""))
+				l.logger().Debug("Timeout update", "tick", l.tick.Load())
+				l.DiscordBot.SendBoard(fmt.Sprintf("Board updated (maze seed %d):\n", l.mazeSeed()) + l.DisplayMaze(""))
 				t = duration
 			}
 			isTimeout = !isTimeout
@@ -83,75 +227,206 @@ func (l *Lobby) StartTimer(timeout time.Duration) {
 	}()
 }
 
-func (l *Lobby) DisplayMaze(id string) string {
+func (l *Lobby) Pause() {
+	if !l.paused.Swap(true) {
+		l.logger().Info("Lobby paused")
+	}
+}
+
+func (l *Lobby) Resume() {
+	if l.paused.Swap(false) {
+		l.logger().Info("Lobby resumed")
+	}
+}
+
+func (l *Lobby) Paused() bool {
+	return l.paused.Load()
+}
+
+// UpdateInterval is the time between updates, set at runtime with SetUpdateInterval
+func (l *Lobby) UpdateInterval() time.Duration {
+	if d := l.interval.Load(); d > 0 {
+		return time.Duration(d)
+	}
+	return UpdateInterval
+}
+
+// SetUpdateInterval takes effect from the next update
+func (l *Lobby) SetUpdateInterval(d time.Duration) {
+	l.interval.Store(int64(d))
+	l.logger().Info("Update interval set", "interval", d)
+}
+
+// Shutdown stops the timer, disconnects every pod and closes the frame log. It is
+// safe to call more than once, and StartTimer can run the lobby again afterwards,
+// without recording frames.
+func (l *Lobby) Shutdown() {
 	l.Mutex.Lock()
-	defer l.Mutex.Unlock()
+	if l.timerRunning {
+		l.stopTimer()
+		l.timerRunning = false
+	}
+	l.roundMutex.Lock()
+	if l.round.timer != nil {
+		l.round.timer.Stop()
+	}
+	l.roundMutex.Unlock()
+	pods := make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		pods = append(pods, o)
+	}
+	l.Mutex.Unlock()
 
-	octapodPositions := make(map[Point]*Octapod)
+	for _, o := range pods {
+		o.Disconnect()
+	}
+	if l.Frames != nil {
+		if err := l.Frames.Close(); err != nil {
+			l.logger().Error("Error closing frame log", "err", err)
+		}
+	}
+	l.logger().Info("Lobby shut down")
+}
 
+// context is cancelled once the lobby shuts down
+func (l *Lobby) context() context.Context {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+func (l *Lobby) ConnectedCount() int {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+
+	count := 0
 	for _, o := range l.Octapods {
-		if id == "" {
-			octapodPositions[Point{
-				int(o.Position.X()),
-				int(o.Position.Y()),
-			}] = o
-		} else if o.Id == strings.ToLower(id) {
-			octapodPositions[Point{
-				int(o.Position.X()),
-				int(o.Position.Y()),
-			}] = o
-		}
-	}
-
-	if len(octapodPositions) == 0 {
-		if id == "" {
-			return "No octapods in the lobby."
-		} else {
-			return "No octapods [" + id + "] in the lobby."
+		o.Mutex.Lock()
+		if o.connected() && !o.Finished {
+			count++
 		}
+		o.Mutex.Unlock()
 	}
+	return count
+}
 
-	var result string
-	for y := 0; y < l.Maze.Height; y++ {
-		for x := 0; x < l.Maze.Width; x++ {
-			if l.Maze.cells[x][y] {
-				result += "# " // Wall
-			} else if octapod, exists := octapodPositions[Point{x, y}]; exists {
-				result += octapod.Id[0:1] + " "
-			} else {
-				result += "  "
-			}
+func (l *Lobby) mazeSeed() int64 {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	return l.MazeSeed
+}
+
+func (l *Lobby) DisplayMaze(id string) string {
+	return l.Snapshot().Render(id)
+}
+
+// StatusReport describes one pod for the Discord !status command
+func (l *Lobby) StatusReport(id string) string {
+	id = strings.ToLower(id)
+	l.Mutex.RLock()
+	o, exists := l.Octapods[id]
+	maze := l.Maze
+	l.Mutex.RUnlock()
+	if !exists {
+		return "No such octapod [" + displayId(id) + "]."
+	}
+
+	state := o.State()
+	connected := "disconnected"
+	if state.Connected {
+		connected = "connected"
+	}
+	report := fmt.Sprintf("Octapod [%s] at (%d,%d), %s, inactive for %d ticks",
+		displayId(id), state.Position.X, state.Position.Y, connected, state.InactiveCount)
+	switch {
+	case state.DNF:
+		report += ", forfeited"
+	case state.Finished:
+		report += fmt.Sprintf(", reached the exit in %d ticks", state.FinishTicks)
+	default:
+		if distance, reachable := maze.ExitDistances()[state.Position]; reachable {
+			report += fmt.Sprintf(", %d steps from the exit", distance)
 		}
-		result += "# \n"
 	}
-	for x := 0; x < l.Maze.Width; x++ {
-		result += "# "
+	return report
+}
+
+// LobbyReport summarises the round for Discord: phase, tick, maze and connected octapods
+func (l *Lobby) LobbyReport() string {
+	snapshot := l.Snapshot()
+	connected := 0
+	for _, p := range snapshot.Pods {
+		if p.Connected {
+			connected++
+		}
+	}
+	phase := string(snapshot.Phase)
+	if l.Paused() {
+		phase += " (paused)"
 	}
-	result += "# \n"
-	return "```\n" + result + "```"
+	return fmt.Sprintf("Round %s at tick %d, %dx%d maze (seed %d), %d/%d octapods connected",
+		phase, snapshot.Tick, snapshot.Maze.Width, snapshot.Maze.Height, snapshot.MazeSeed, connected, len(snapshot.Pods))
 }
 
 func (l *Lobby) HandleJoin(c *gin.Context) {
-	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	upgrader := newUpgrader()
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Println(err)
+		l.metrics.websocketErrors.WithLabelValues("upgrade").Inc()
+		l.logger().Warn("WebSocket upgrade failed", "err", err)
 		return
 	}
-	log.Println("New connection established")
+	connId := nextConnId()
+	logger := l.logger().With("conn", connId)
+	logger.Info("New connection established", "remote", c.ClientIP())
 
 	err, auth := l.getAuthenticationMessage(conn)
 	if err != nil {
-		log.Println(err)
+		l.metrics.authFailures.WithLabelValues("invalid_message").Inc()
+		logger.Warn("Reading the authentication message failed", "err", err)
+		return
+	}
+	logger.Debug("Received an octapod authentication message", "octapod", strings.ToLower(auth.ID), "version", auth.Version)
+	version, err := negotiateVersion(auth.Version)
+	if err != nil {
+		l.metrics.authFailures.WithLabelValues("unsupported_version").Inc()
+		// Clients asking for a newer protocol most likely read envelopes
+		sendErrorAndCloseAs(conn, min(auth.Version, ProtocolVersion), err.Error())
 		return
 	}
-	log.Println("Received an octapod authentication message")
+	auth.Version = version // The negotiated version from here on
 
-	o := l.identifyOctapod(strings.ToLower(auth.ID), auth.Password, conn)
-	if o == nil {
+	// identifyOctapod only registers, the pumps are started here for new and returning pods alike
+	o, err := l.identifyOctapod(auth, conn, connId)
+	if err != nil {
+		sendErrorAndCloseAs(conn, auth.Version, err.Error())
+		return
+	}
+	o.Run(l.context())
+}
+
+func (l *Lobby) HandlePod(c *gin.Context) {
+	id := strings.ToLower(c.Param("id"))
+
+	l.Mutex.RLock()
+	o, exists := l.Octapods[id]
+	l.Mutex.RUnlock()
+	if !exists {
+		c.JSON(http.StatusNotFound, ErrorMessage{Error: "No octapod [" + id + "] in the lobby."})
 		return
 	}
-	o.Run()
+	c.JSON(http.StatusOK, o.State())
+}
+
+func newUpgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  ReadBufferSize,
+		WriteBufferSize: WriteBufferSize,
+		CheckOrigin:     func(r *http.Request) bool { return true },
+	}
 }
 
 func (l *Lobby) getAuthenticationMessage(conn *websocket.Conn) (error, *AuthMessage) {
@@ -172,61 +447,366 @@ func (l *Lobby) getAuthenticationMessage(conn *websocket.Conn) (error, *AuthMess
 	return nil, &auth
 }
 
-func (l *Lobby) identifyOctapod(id, password string, conn *websocket.Conn) *Octapod {
+// identifyOctapod registers a new pod or resumes an existing one. A nil conn opens
+// an HTTP polling session instead of a WebSocket one. Refusals are returned as a
+// *joinError for the caller to deliver.
+func (l *Lobby) identifyOctapod(auth *AuthMessage, conn *websocket.Conn, connId uint64) (*Octapod, error) {
+	id := strings.ToLower(auth.ID)
+	password := auth.Password
+	multiplier := clampTickMultiplier(auth.TickMultiplier)
+
 	l.Mutex.Lock()
 	oct, exists := l.Octapods[id]
+	if !exists && auth.Token != "" {
+		l.Mutex.Unlock()
+		l.metrics.authFailures.WithLabelValues("invalid_token").Inc()
+		return nil, &joinError{http.StatusUnauthorized, "Invalid or expired reconnect token"}
+	}
 	if !exists {
-		oct = NewOctapod(id, password, conn, l.Maze)
+		if phase := l.Phase(); RoundMode && phase != PhaseWaiting && phase != PhaseCountdown {
+			l.Mutex.Unlock()
+			return nil, &joinError{http.StatusConflict, "The round is " + string(phase) + ", new octapods can join before the next one"}
+		}
+		tag := l.freeTag(id)
+		if auth.Tag != "" {
+			if !validTag(auth.Tag) {
+				l.Mutex.Unlock()
+				return nil, &joinError{http.StatusBadRequest, "Tag must be 1 or 2 visible characters"}
+			}
+			if l.tagInUse(auth.Tag) {
+				l.Mutex.Unlock()
+				return nil, &joinError{http.StatusConflict, "Tag [" + auth.Tag + "] is already in use"}
+			}
+			tag = auth.Tag
+		}
+		if auth.Team != "" && !validTeam(auth.Team) {
+			l.Mutex.Unlock()
+			return nil, &joinError{http.StatusBadRequest, fmt.Sprintf("Team must be 1 to %d visible characters", MaxTeamNameLength)}
+		}
+		oct = NewOctapod(id, password, conn, l)
+		oct.polling = conn == nil
+		oct.Tag = tag
+		oct.Team = auth.Team
+		l.joinTeam(oct)
+		oct.TickMultiplier = multiplier
+		oct.joinedTick = l.tick.Load()
+		oct.connId.Store(connId)
+		oct.protocol.Store(int32(auth.Version))
+		oct.touch()
+		oct.openCommandLog()
+		oct.recordMatch(MatchJoin, nil)
 		l.Octapods[id] = oct
 		l.Mutex.Unlock()
-		log.Println("New octapod [", id, "] registered")
-		l.DiscordBot.SendMessage("New octapod [" + id + "] registered")
-		oct.Run()
-		return oct
+		oct.logger().Info("New octapod registered")
+		l.stats.joins.Add(1)
+		l.metrics.registrations.Inc()
+		if conn != nil {
+			l.greet(oct, conn)
+		}
+		if oct.Team != "" {
+			l.DiscordBot.SendMessage("New octapod [" + displayId(id) + "] registered for team [" + displayId(oct.Team) + "]")
+		} else {
+			l.DiscordBot.SendMessage("New octapod [" + displayId(id) + "] registered")
+		}
+		l.broadcastPresence(PlayerJoined, oct)
+		return oct, nil
 	}
 	// existing
 	l.Mutex.Unlock()
 
+	// greet the new connection, then announce and close a replaced one, only after
+	// the octapod's lock is released. Update takes pod locks while holding l.Mutex,
+	// so a slow client must never be written to under it.
+	reconnected := false
+	var stale *websocket.Conn
+	var result *ResultMessage
+	defer func() {
+		if !reconnected {
+			return
+		}
+		if conn != nil {
+			if result != nil {
+				if err := oct.write(conn, *result); err != nil {
+					oct.logger().Error("Error sending result message", "err", err)
+				}
+			}
+			l.greet(oct, conn)
+		}
+		if stale != nil {
+			closeWithReason(stale, websocket.CloseNormalClosure, "Session taken over")
+		} else {
+			l.broadcastPresence(PlayerJoined, oct)
+		}
+	}()
+
 	// verify and reconnect under octapod's lock
 	oct.Mutex.Lock()
 	defer oct.Mutex.Unlock()
-	if !oct.VerifyPassword(password) {
-		sendErrorAndClose(conn, "Invalid password for octapod")
-		conn.Close()
-		return oct
+	if auth.Token != "" {
+		if !l.redeemToken(id, auth.Token) {
+			l.metrics.authFailures.WithLabelValues("invalid_token").Inc()
+			return nil, &joinError{http.StatusUnauthorized, "Invalid or expired reconnect token"}
+		}
+	} else if !oct.VerifyPassword(password) {
+		l.metrics.authFailures.WithLabelValues("wrong_password").Inc()
+		return nil, &joinError{http.StatusUnauthorized, "Invalid password for octapod"}
 	}
-	if oct.Conn != nil {
-		sendErrorAndClose(conn, "Octapod already connected")
-		conn.Close()
-		return oct
+	// A token proves the session is the caller's, so it replaces a connection the
+	// server has not noticed is dead yet
+	if oct.connected() && !auth.Takeover && auth.Token == "" {
+		return nil, &joinError{http.StatusConflict, "Octapod already connected, set takeover or send your reconnect token to replace it"}
+	}
+	oct.protocol.Store(int32(auth.Version))
+	if oct.Finished {
+		if !AllowFinishedReconnect {
+			return nil, &joinError{http.StatusConflict, "Octapod already finished" + resultSuffix(oct)}
+		}
+		result = &ResultMessage{Finished: oct.Finished, DNF: oct.DNF}
 	}
+	stale = oct.Conn
 	oct.Conn = conn
-	log.Println("Octapod [", id, "] reconnected")
-	l.DiscordBot.SendMessage("Octapod [" + id + "] reconnected")
-	return oct
+	oct.polling = conn == nil
+	oct.connId.Store(connId)
+	oct.touch()
+	oct.TickMultiplier = multiplier
+	oct.reclaimCell()
+	oct.InactiveCount = 0
+	oct.awaitingMove = false
+	oct.graceTicks = JoinGraceTicks
+	oct.teamCursor = 0 // The new connection gets the team's whole map again
+	oct.openCommandLog()
+	if !PreserveFogOnReconnect {
+		oct.discovered = make(map[Point]bool)
+	}
+	l.stats.joins.Add(1)
+	l.metrics.reconnections.Inc()
+	if stale != nil {
+		oct.logger().Info("Octapod took over its session")
+		l.DiscordBot.SendMessage("Octapod [" + displayId(id) + "] took over its session")
+	} else {
+		oct.logger().Info("Octapod reconnected")
+		l.DiscordBot.SendMessage("Octapod [" + displayId(id) + "] reconnected")
+	}
+	reconnected = true
+	return oct, nil
 }
 
-func (l *Lobby) Update() {
+// joinError refuses a join with a message for the client and the matching HTTP status
+type joinError struct {
+	status  int
+	message string
+}
+
+func (e *joinError) Error() string {
+	return e.message
+}
+
+func (l *Lobby) removeOctapod(o *Octapod) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	if l.Octapods[o.Id] == o {
+		delete(l.Octapods, o.Id)
+	}
+}
+
+// tagInUse reports whether another pod chose the tag, must hold l.Mutex
+func (l *Lobby) tagInUse(tag string) bool {
+	for _, o := range l.Octapods {
+		if o.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// freeTag picks a default tag no other pod holds: the ID's initial, then its
+// first two characters, then the initial and a digit. Must hold l.Mutex
+func (l *Lobby) freeTag(id string) string {
+	initial := defaultTag(id)
+	candidates := []string{initial}
+	if runes := []rune(id); len(runes) >= 2 {
+		candidates = append(candidates, string(runes[:2]))
+	}
+	for digit := '1'; digit <= '9'; digit++ {
+		candidates = append(candidates, initial+string(digit))
+	}
+	for _, tag := range candidates {
+		if validTag(tag) && !l.tagInUse(tag) {
+			return tag
+		}
+	}
+	return initial
+}
+
+// broadcastPresence must be called without holding l.Mutex or any octapod lock
+func (l *Lobby) broadcastPresence(presence PresenceType, subject *Octapod) {
+	if !BroadcastPresence {
+		return
+	}
+	l.notifyOthers(presence, subject)
+}
+
+// notifyOthers sends a presence notice about subject to every other pod, must be
+// called without holding l.Mutex or any octapod lock
+func (l *Lobby) notifyOthers(presence PresenceType, subject *Octapod) {
 	l.Mutex.RLock()
 	pods := make([]*Octapod, 0, len(l.Octapods))
 	for _, o := range l.Octapods {
-		pods = append(pods, o)
+		if o != subject {
+			pods = append(pods, o)
+		}
 	}
 	l.Mutex.RUnlock()
 
+	msg := PresenceMessage{Type: presence, Id: subject.Id}
+	for _, o := range pods {
+		// Pods that are not connected simply miss the notice
+		_ = o.Send(msg)
+	}
+}
+
+func (l *Lobby) revealMaze(o *Octapod, conn *websocket.Conn) {
+	if !RevealMaze {
+		return
+	}
+	if err := o.write(conn, o.Maze.Message()); err != nil {
+		o.logger().Error("Error sending maze", "err", err)
+	}
+}
+
+// Update advances the lobby by one tick. Inactivity follows a single lifecycle:
+//
+//  1. A sensor is pushed to the pod on each of its ticks and the pod is marked as awaiting a move.
+//  2. The pod has until its next tick to answer; any move clears the mark and resets InactiveCount.
+//  3. If the mark is still set on the next tick the response was missed and InactiveCount grows,
+//     unless the pod still has join grace left (see JoinGraceTicks).
+//  4. A pod reaching MaxInactive is disconnected instead of being sent another sensor.
+//
+// TimeoutUpdate only signals the end of the response window and never counts inactivity.
+func (l *Lobby) Update() {
+	l.Mutex.Lock()
+	tick := l.tick.Add(1)
+	l.perturbMaze()
+	l.runEvents(tick)
+	foggy := l.foggy(tick)
+	sensors := l.Sensors
+	field := sensorField{positions: make(map[string]Point), mates: make(map[string][]TeamMate)}
+	pods := make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		pods = append(pods, o)
+		if sensors.SmellRadius > 0 || len(l.teams) > 0 {
+			o.Mutex.Lock()
+			if o.connected() && !o.Finished {
+				field.positions[o.Id] = pointOf(o.Position)
+				if o.team != nil {
+					field.mates[o.team.name] = append(field.mates[o.team.name], TeamMate{Id: o.Id, Position: pointOf(o.Position)})
+				}
+			}
+			o.Mutex.Unlock()
+		}
+	}
+	if sensors.Beacon {
+		field.exitDistances = l.Maze.ExitDistances()
+	}
+	l.Mutex.Unlock()
+	for _, mates := range field.mates {
+		sort.Slice(mates, func(i, j int) bool { return mates[i].Id < mates[j].Id })
+	}
+
+	// A fixed order keeps move resolution reproducible when pods compete for a cell
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Id < pods[j].Id
+	})
+	var finished []*Octapod
+	var collisions [][2]*Octapod // Mover and holder, resolved once no pod lock is held
+
 	for _, o := range pods {
 		o.Mutex.Lock()
-		if o.Conn == nil {
+		if !o.connected() || o.Finished {
+			o.Mutex.Unlock()
+			continue
+		}
+		tooManyIllegal, collided := o.applyPendingMove()
+		if collided != nil {
+			collisions = append(collisions, [2]*Octapod{o, collided})
+		}
+		if tooManyIllegal {
+			o.Mutex.Unlock()
+			o.kickForIllegalMoves()
+			continue
+		}
+		if o.Maze.IsExit(pointOf(o.Position)) {
+			o.finish(tick, false)
+			o.Mutex.Unlock()
+			finished = append(finished, o)
+			continue
+		}
+		// Pods with a multiplier only receive sensor data every n-th tick
+		if tick%int64(o.TickMultiplier) != 0 {
 			o.Mutex.Unlock()
 			continue
 		}
-		o.InactiveCount++
-		s := l.Maze.GetSensor(o.Position)
+		if o.settleResponse() {
+			o.Mutex.Unlock()
+			o.Kick(websocket.ClosePolicyViolation, "Inactive for too long")
+			l.metrics.inactiveDisconnects.Inc()
+			o.logger().Info("Octapod disconnected due to inactivity")
+			continue
+		}
+		o.awaitingMove = true
+		s := o.sensorsFor(sensors, tick, foggy).sense(o.Maze, o.Id, pointOf(o.Position), field, l.sensorRand)
+		o.discover()
+		s.Team = o.teamSensor(field)
+		if SensorTrail {
+			s.Trail = o.trailSensor()
+		}
+		o.recordMatch(MatchSensor, s)
 		o.Mutex.Unlock()
 
-		o.Sensor <- s
-		log.Println("Sensor data sent to octapod [", o.Id, "]")
+		if o.deliver(s) {
+			l.metrics.sensorFramesSent.Inc()
+			o.logger().Debug("Sensor data sent", "tick", tick)
+		}
+	}
+	for _, c := range collisions {
+		l.resolveTag(c[0], c[1])
 	}
+	l.flushEvents()
+	l.announceFinished(finished, tick)
+	l.finishRoundIfDone()
+}
+
+// announceFinished reports pods that reached the exit this tick, in ID order
+func (l *Lobby) announceFinished(pods []*Octapod, tick int64) {
+	if len(pods) == 0 {
+		return
+	}
+	lines := make([]string, 0, len(pods))
+	for _, o := range pods {
+		o.Mutex.Lock()
+		ticks, steps := o.FinishTicks, o.Steps
+		o.Mutex.Unlock()
+
+		o.logger().Info("Octapod reached the exit", "ticks", ticks, "steps", steps)
+		l.saveResult(o)
+		lines = append(lines, fmt.Sprintf("Octapod [%s] reached the exit in %d ticks (%d steps)!", displayId(o.Id), ticks, steps))
+		if err := o.Send(ResultMessage{Finished: true}); err != nil {
+			o.logger().Error("Error sending result", "err", err)
+		}
+	}
+	// Announce the winners once one of them is among this tick's finishers
+	winners := l.Scoreboard().Winners()
+	announce := slices.ContainsFunc(winners, func(w ScoreEntry) bool {
+		return slices.ContainsFunc(pods, func(o *Octapod) bool { return o.Id == w.Id })
+	})
+	if announce {
+		ids := make([]string, 0, len(winners))
+		for _, w := range winners {
+			ids = append(ids, "["+displayId(w.Id)+"]")
+		}
+		lines = append(lines, "Winner: "+strings.Join(ids, ", "))
+	}
+	l.DiscordBot.SendMessage(strings.Join(lines, "\n"))
 }
 
 func (l *Lobby) TimeoutUpdate() {
@@ -239,37 +819,117 @@ func (l *Lobby) TimeoutUpdate() {
 
 	for _, o := range pods {
 		o.Mutex.Lock()
-		if o.Conn == nil {
+		if o.Conn == nil || o.Finished {
 			o.Mutex.Unlock()
 			continue
 		}
 		o.Mutex.Unlock()
 
-		o.Sensor <- nil
-		log.Println("Timeout signal sent to octapod", o.Id)
-
-		o.Mutex.Lock()
-		if o.InactiveCount >= MaxInactive {
-			o.Mutex.Unlock()
-			o.Disconnect()
-			log.Println("Octapod [", o.Id, "] disconnected due to inactivity")
-		} else {
-			o.Mutex.Unlock()
+		if o.deliver(nil) {
+			l.metrics.timeoutSignalsSent.Inc()
+			o.logger().Debug("Timeout signal sent")
 		}
 	}
 }
 
+func resultSuffix(o *Octapod) string {
+	if o.DNF {
+		return " (DNF)"
+	}
+	return ""
+}
+
+func clampTickMultiplier(multiplier int) int {
+	if multiplier < 1 {
+		return 1
+	}
+	if multiplier > MaxTickMultiplier {
+		return MaxTickMultiplier
+	}
+	return multiplier
+}
+
 func sendErrorAndClose(conn *websocket.Conn, msg string) {
-	errMsg := ErrorMessage{Error: msg}
-	b, _ := json.Marshal(errMsg)
-	err := conn.WriteMessage(websocket.TextMessage, b)
+	sendErrorAndCloseAs(conn, 1, msg)
+}
+
+// sendErrorAndCloseAs sends the error in the shape of the given protocol version
+func sendErrorAndCloseAs(conn *websocket.Conn, version int, msg string) {
+	sendAndClose(conn, version, ErrorMessage{Error: msg}, msg)
+}
+
+// sendAndClose sends message and says goodbye with reason. A message that can't be
+// encoded is dropped and the connection closed with a generic reason instead.
+func sendAndClose(conn *websocket.Conn, version int, message any, reason string) {
+	send := func(v any) error {
+		b, err := encodeMessage(version, v)
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.TextMessage, b)
+	}
+	b, err := encodeMessage(version, message)
 	if err != nil {
-		log.Println("Error sending error message:", err)
+		slog.Error("Error encoding message before closing", "err", err)
+		closeWithReason(conn, websocket.CloseInternalServerErr, "Internal server error")
 		return
 	}
-	err = conn.Close()
+	err = conn.WriteMessage(websocket.TextMessage, b)
 	if err != nil {
-		log.Println("Error closing connection:", err)
+		slog.Warn("Error sending error message", "err", err)
+		conn.Close()
 		return
 	}
+	// Nothing else reads the socket before registration, so wait for the ack here
+	ack := make(chan struct{})
+	go func() {
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if isGoodbye(msg) {
+				close(ack)
+				return
+			}
+		}
+	}()
+	goodbye(conn, websocket.ClosePolicyViolation, reason, send, ack)
+}
+
+// goodbye sends a GoodbyeMessage, waits up to GoodbyeTimeout for the client to
+// acknowledge it and then closes the connection
+func goodbye(conn *websocket.Conn, code int, reason string, send func(any) error, ack <-chan struct{}) {
+	if err := send(GoodbyeMessage{Type: GoodbyeCommand, Code: code, Reason: reason}); err != nil {
+		slog.Warn("Error sending goodbye message", "err", err)
+	} else {
+		timer := time.NewTimer(GoodbyeTimeout)
+		select {
+		case <-ack:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+	closeWithReason(conn, code, reason)
+}
+
+func isGoodbye(msg []byte) bool {
+	var cmd CommandMessage
+	return json.Unmarshal(msg, &cmd) == nil && cmd.Type == GoodbyeCommand
+}
+
+func closeWithReason(conn *websocket.Conn, code int, reason string) {
+	// Close frames carry at most 123 bytes of reason
+	if len(reason) > 123 {
+		reason = strings.ToValidUTF8(reason[:123], "")
+	}
+	deadline := time.Now().Add(TimeoutInterval)
+	err := conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	if err != nil {
+		slog.Warn("Error sending close message", "err", err)
+	}
+	err = conn.Close()
+	if err != nil {
+		slog.Warn("Error closing connection", "err", err)
+	}
 }
@@ -0,0 +1,545 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/quartercastle/vector"
+)
+
+// tracingStrategy walks randomly and notes every position and beacon reading it got
+func tracingStrategy(seed int64, trace *[]string) Strategy {
+	walk := RandomWalk(seed)
+	return func(tick int64, position Point, sensor *Sensor) Move {
+		beacon := -1
+		if sensor.Beacon != nil {
+			beacon = *sensor.Beacon
+		}
+		*trace = append(*trace, fmt.Sprintf("%d %v %d", tick, position, beacon))
+		return walk(tick, position, sensor)
+	}
+}
+
+func seededRun(t *testing.T, seed int64) (SimulationResult, MazeMessage, []string) {
+	t.Helper()
+	var trace []string
+	sim := Simulation{Width: 11, Height: 11, Seed: seed, Ticks: 200, Pods: []SimPod{
+		{Id: "a", Strategy: tracingStrategy(1, &trace)},
+		{Id: "b", Strategy: tracingStrategy(2, &trace)},
+	}}
+	result, err := sim.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, newLobby(11, 11, seed, nil).Maze.Message(), trace
+}
+
+func TestSameSeedSameGame(t *testing.T) {
+	// Noisy beacons and chaos walls draw from the seeded generators every tick
+	setFor(t, &DefaultSensorPackage, "beacon")
+	setFor(t, &ChaosToggles, 2)
+	first, firstMaze, firstTrace := seededRun(t, 42)
+	second, secondMaze, secondTrace := seededRun(t, 42)
+
+	if first.MazeSeed != second.MazeSeed || !reflect.DeepEqual(firstMaze, secondMaze) {
+		t.Fatal("the same seed generated different mazes")
+	}
+	if len(firstTrace) == 0 || !reflect.DeepEqual(firstTrace, secondTrace) {
+		t.Errorf("the same seed gave different spawns or sensor readings:\n%v\n%v", firstTrace, secondTrace)
+	}
+	if first.Board != second.Board {
+		t.Errorf("the same seed ended on different boards:\n%s\n%s", first.Board, second.Board)
+	}
+
+	other, otherMaze, _ := seededRun(t, 43)
+	if other.MazeSeed == first.MazeSeed || reflect.DeepEqual(otherMaze, firstMaze) {
+		t.Error("different seeds generated the same maze")
+	}
+}
+
+var errUnencodable = errors.New("cannot encode")
+
+// unencodable fails to marshal, like a message carrying a broken value would
+type unencodable struct{}
+
+func (unencodable) MarshalJSON() ([]byte, error) {
+	return nil, errUnencodable
+}
+
+func TestSendAndCloseSurvivesMarshalFailure(t *testing.T) {
+	conn := dialHandler(t, func(conn *websocket.Conn) {
+		sendAndClose(conn, 1, unencodable{}, "Some reason")
+	})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, msg, err := conn.ReadMessage()
+	var closed *websocket.CloseError
+	if !errors.As(err, &closed) {
+		t.Fatalf("got message %q and error %v, want a close frame", msg, err)
+	}
+	if closed.Code != websocket.CloseInternalServerErr || closed.Text != "Internal server error" {
+		t.Errorf("closed with %d %q, want %d and a generic reason", closed.Code, closed.Text, websocket.CloseInternalServerErr)
+	}
+}
+
+// finishPod takes a pod to the exit and disconnects it
+func finishPod(t *testing.T, l *Lobby, id string) PodState {
+	t.Helper()
+	o := addPod(t, l, id)
+	l.Update()
+	o.Mutex.Lock()
+	o.Position = vector.Vector{float64(l.Maze.Exit.X), float64(l.Maze.Exit.Y)}
+	o.finish(l.tick.Load()+3, false)
+	o.Mutex.Unlock()
+	o.Disconnect()
+	return o.State()
+}
+
+func TestFinishedPodRefusedOnReconnect(t *testing.T) {
+	setFor(t, &AllowFinishedReconnect, false)
+	l := newTestLobby(t, 9, 9, 1)
+	finishPod(t, l, "done")
+
+	auth := AuthMessage{ID: "done", Password: "secret", Version: 1}
+	_, err := l.identifyOctapod(&auth, nil, nextConnId())
+	var refused *joinError
+	if !errors.As(err, &refused) || refused.status != http.StatusConflict || !strings.Contains(refused.message, "already finished") {
+		t.Fatalf("reconnecting a finished pod = %v, want an already finished conflict", err)
+	}
+}
+
+func TestFinishedPodCannotAlterResult(t *testing.T) {
+	setFor(t, &AllowFinishedReconnect, true)
+	l := newTestLobby(t, 9, 9, 1)
+	before := finishPod(t, l, "done")
+
+	conn := dialPod(t, l, AuthMessage{ID: "done", Password: "secret", Version: 2})
+	var result ResultMessage
+	readEnvelope(t, conn, ResultMessageType, &result)
+	if !result.Finished || result.DNF {
+		t.Errorf("result on reconnect %+v, want finished", result)
+	}
+	conn.Close()
+
+	o := joinPod(t, l, AuthMessage{ID: "done", Password: "secret", Version: 1, Takeover: true})
+	for range 3 {
+		l.Update()
+		o.move(openMove(t, o))
+	}
+	after := o.State()
+	if after.Position != before.Position || after.Steps != before.Steps ||
+		after.FinishTicks != before.FinishTicks || after.FinishedAt == nil || !after.FinishedAt.Equal(*before.FinishedAt) || after.DNF {
+		t.Errorf("finished pod changed from %+v to %+v", before, after)
+	}
+}
+
+func TestRevealMazeOnJoin(t *testing.T) {
+	setFor(t, &RevealMaze, true)
+	l := newTestLobby(t, 9, 7, 1)
+	conn := dialPod(t, l, AuthMessage{ID: "seer", Password: "secret", Version: 2})
+	var maze MazeMessage
+	readEnvelope(t, conn, MazeMessageType, &maze)
+	if want := l.Maze.Message(); !reflect.DeepEqual(maze, want) {
+		t.Errorf("revealed maze %+v, want %+v", maze, want)
+	}
+}
+
+func TestFogKeepsMazeHidden(t *testing.T) {
+	setFor(t, &RevealMaze, false)
+	l := newTestLobby(t, 9, 7, 1)
+	conn := dialPod(t, l, AuthMessage{ID: "blind", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	l.Update()
+	// The first sensor follows the welcome directly
+	var envelope Envelope
+	if err := conn.ReadJSON(&envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != SensorMessageType {
+		t.Errorf("got a %s message after the welcome, want the first sensor", envelope.Type)
+	}
+}
+
+func TestUpgraderBufferSizes(t *testing.T) {
+	setFor(t, &ReadBufferSize, 1024)
+	setFor(t, &WriteBufferSize, 64*1024)
+	upgrader := newUpgrader()
+	if upgrader.ReadBufferSize != 1024 || upgrader.WriteBufferSize != 64*1024 {
+		t.Errorf("upgrader buffers %d/%d, want 1024/65536", upgrader.ReadBufferSize, upgrader.WriteBufferSize)
+	}
+
+	// Pods still connect and get their welcome through the custom buffers
+	l := newTestLobby(t, 9, 9, 1)
+	conn := dialPod(t, l, AuthMessage{ID: "buffered", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+}
+
+func TestEmptyLobbyStaysQuiet(t *testing.T) {
+	logs := captureLogs(t)
+	setFor(t, &MaxInactive, 1000)
+	bot := NewDiscordBot(DiscordConfig{Offline: true})
+	t.Cleanup(func() { bot.flush(time.Second) })
+	l := newLobby(6, 6, 1, bot)
+	l.SetUpdateInterval(2 * time.Millisecond)
+	l.StartTimer(time.Millisecond)
+	t.Cleanup(l.Shutdown)
+
+	time.Sleep(50 * time.Millisecond)
+	if tick := l.tick.Load(); tick != 0 {
+		t.Errorf("empty lobby ran %d updates", tick)
+	}
+	idle := 0
+	for _, entry := range logs() {
+		switch entry["msg"] {
+		case "Discord (offline)":
+			t.Errorf("empty lobby posted to Discord: %v", entry["message"])
+		case "Lobby idle, skipping updates until an octapod connects":
+			idle++
+		}
+	}
+	if idle != 1 {
+		t.Errorf("idle logged %d times, want once", idle)
+	}
+
+	// A pod connecting wakes the lobby up
+	o := addPod(t, l, "waker")
+	within(t, 5*time.Second, func() {
+		for takeSensor(o) == nil {
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestHandlePod(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := newTestLobby(t, 9, 9, 1)
+	o := addPod(t, l, "inspected")
+	l.Update()
+	o.move(blockedMove(t, o))
+	router := gin.New()
+	router.GET("/pods/:id", l.HandlePod)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pods/Inspected", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET known pod: status %d", w.Code)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["id"] != "inspected" || fields["connected"] != true || fields["illegalMoves"] != 1.0 {
+		t.Errorf("pod state %s", w.Body)
+	}
+	if _, exists := fields["finishedAt"]; exists {
+		t.Errorf("unfinished pod reports finishedAt: %s", w.Body)
+	}
+	for key := range fields {
+		if strings.Contains(strings.ToLower(key), "password") || strings.Contains(strings.ToLower(key), "hash") {
+			t.Errorf("pod state exposes %q", key)
+		}
+	}
+	if strings.Contains(w.Body.String(), o.HashedPassword) {
+		t.Error("pod state contains the password hash")
+	}
+
+	if fields["rttMs"] != 0.0 {
+		t.Errorf("polling pod reports a ping round trip: %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pods/nobody", nil))
+	var missing ErrorMessage
+	if w.Code != http.StatusNotFound || json.Unmarshal(w.Body.Bytes(), &missing) != nil || missing.Error == "" {
+		t.Errorf("GET missing pod: status %d body %s, want a 404 error", w.Code, w.Body)
+	}
+
+	// A connected pod answers pings while it reads, and its state reports the round trip
+	setFor(t, &PingInterval, 10*time.Millisecond)
+	conn := dialPod(t, l, AuthMessage{ID: "pinged", Password: "secret", Version: 1})
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	var pinged PodState
+	deadline := time.Now().Add(5 * time.Second)
+	for pinged.RTTMs <= 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pods/pinged", nil))
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &pinged); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if pinged.RTTMs <= 0 {
+		t.Errorf("connected pod state %s, want a positive rttMs", w.Body)
+	}
+}
+
+func TestFogOnReconnect(t *testing.T) {
+	for _, preserve := range []bool{true, false} {
+		t.Run(fmt.Sprintf("preserve=%v", preserve), func(t *testing.T) {
+			setFor(t, &PreserveFogOnReconnect, preserve)
+			setFor(t, &MaxInactive, 1000)
+			l := openRoomLobby(t)
+			o := addPod(t, l, "explorer")
+			l.Update()
+			o.move(Right)
+			l.Update()
+			discovered := o.State().Discovered
+			if discovered == 0 {
+				t.Fatal("nothing discovered before reconnecting")
+			}
+
+			o.Disconnect()
+			o = joinPod(t, l, AuthMessage{ID: "explorer", Password: "secret", Version: 1})
+			want := 0
+			if preserve {
+				want = discovered
+			}
+			if got := o.State().Discovered; got != want {
+				t.Errorf("%d cells discovered after reconnecting, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestStuckPodDoesNotStallBroadcast(t *testing.T) {
+	setFor(t, &MaxInactive, 1000)
+	l := newTestLobby(t, 9, 9, 1)
+	// Pods are updated in ID order, the stuck one goes first
+	stuck := addPod(t, l, "a-stuck")
+	stuck.Sensor = make(chan *Sensor) // Nobody ever receives
+	healthy := []*Octapod{addPod(t, l, "b"), addPod(t, l, "c")}
+
+	for tick := 1; tick <= 3; tick++ {
+		within(t, 5*time.Second, l.Update)
+		for _, o := range healthy {
+			if takeSensor(o) == nil {
+				t.Errorf("tick %d: %s got no sensor", tick, o.Id)
+			}
+		}
+	}
+}
+
+func TestBroadcastPresence(t *testing.T) {
+	setFor(t, &BroadcastPresence, true)
+	l := newTestLobby(t, 9, 9, 1)
+	watcher := dialPod(t, l, AuthMessage{ID: "watcher", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, watcher, WelcomeMessageType, &welcome)
+
+	rival := addPod(t, l, "rival")
+	var presence PresenceMessage
+	readEnvelope(t, watcher, PresenceMessageType, &presence)
+	if presence != (PresenceMessage{Type: PlayerJoined, Id: "rival"}) {
+		t.Errorf("join notice %+v", presence)
+	}
+	rival.Disconnect()
+	readEnvelope(t, watcher, PresenceMessageType, &presence)
+	if presence != (PresenceMessage{Type: PlayerLeft, Id: "rival"}) {
+		t.Errorf("leave notice %+v", presence)
+	}
+
+	// The watcher's own leave notice reads BroadcastPresence, let it finish first.
+	// Taking the lobby lock after its broadcast orders that read before the cleanup.
+	watcher.Close()
+	within(t, 5*time.Second, func() {
+		for pumpCount("(*Octapod).readPump") > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	l.Mutex.Lock()
+	l.Mutex.Unlock()
+}
+
+func TestOnlyJoinedPodsReachGameState(t *testing.T) {
+	setFor(t, &MaxInactive, 1000)
+	l := newTestLobby(t, 9, 9, 1)
+	player := addPod(t, l, "player")
+	// A refused registration must not leave the ID behind
+	auth := AuthMessage{ID: "ghost", Password: "secret", Version: 1, Tag: "toolong"}
+	if _, err := l.identifyOctapod(&auth, nil, nextConnId()); err == nil {
+		t.Fatal("registration with an invalid tag accepted")
+	}
+	away := addPod(t, l, "away")
+	away.Disconnect()
+	l.Update()
+
+	if board := l.DisplayMaze("ghost"); !strings.HasPrefix(board, "No octapods") {
+		t.Errorf("ghost is on the board:\n%s", board)
+	}
+	for _, p := range l.Snapshot().Pods {
+		if p.Id == "ghost" {
+			t.Error("ghost is in the lobby snapshot")
+		}
+	}
+	if takeSensor(player) == nil {
+		t.Error("joined pod got no sensor")
+	}
+	if takeSensor(away) != nil {
+		t.Error("disconnected pod got a sensor")
+	}
+}
+
+func TestInactivityLifecycle(t *testing.T) {
+	setFor(t, &MaxInactive, 3)
+	setFor(t, &JoinGraceTicks, 2)
+	setFor(t, &Collisions, CollisionStack)
+	const ticks = 12
+	for _, tc := range []struct {
+		name       string
+		joinAfter  int              // Updates run before the pod joins
+		moves      func(int64) bool // Whether the pod answers the sensor of a tick
+		kickedTick int64            // 0: still connected at the end
+	}{
+		{"active", 0, func(int64) bool { return true }, 0},
+		{"intermittent", 0, func(tick int64) bool { return tick%2 == 1 }, 0},
+		{"answers late once", 0, func(tick int64) bool { return tick != 3 && tick != 4 }, 0},
+		// Two misses are forgiven by the join grace, the next three reach MaxInactive
+		{"silent", 0, func(int64) bool { return false }, 6},
+		{"just joined", 4, func(int64) bool { return false }, 10},
+		// The grace covers the first misses, however late they come
+		{"silent after playing", 0, func(tick int64) bool { return tick <= 4 }, 10},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := openRoomLobby(t)
+			var o *Octapod
+			var kicked int64
+			for tick := int64(1); tick <= ticks; tick++ {
+				if int(tick) == tc.joinAfter+1 {
+					o = addPod(t, l, "pod")
+				}
+				l.Update()
+				if o == nil {
+					continue
+				}
+				if kicked == 0 && !o.State().Connected {
+					kicked = tick
+				}
+				if kicked == 0 && tc.moves(tick) {
+					// Back and forth, every move is legal
+					if tick%2 == 1 {
+						o.move(Right)
+					} else {
+						o.move(Left)
+					}
+				}
+			}
+			if kicked != tc.kickedTick {
+				t.Errorf("disconnected on tick %d, want %d", kicked, tc.kickedTick)
+			}
+			if state := o.State(); kicked == 0 && state.InactiveCount >= MaxInactive {
+				t.Errorf("connected pod at InactiveCount %d", state.InactiveCount)
+			}
+		})
+	}
+}
+
+func TestPauseKeepsSilentPod(t *testing.T) {
+	setFor(t, &MaxInactive, 2)
+	setFor(t, &JoinGraceTicks, 0)
+	l := newLobby(7, 7, 1, nil)
+	t.Cleanup(l.Shutdown)
+	l.Pause()
+	conn := dialPod(t, l, AuthMessage{ID: "quiet", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	o := podNamed(t, l, "quiet")
+	l.SetUpdateInterval(2 * time.Millisecond)
+	l.StartTimer(time.Millisecond)
+
+	// Long enough for dozens of updates, a silent pod would be kicked after two
+	time.Sleep(100 * time.Millisecond)
+	state := o.State()
+	if !state.Connected || state.InactiveCount != 0 || l.tick.Load() != 0 {
+		t.Fatalf("after a pause: connected %v, inactive %d, tick %d", state.Connected, state.InactiveCount, l.tick.Load())
+	}
+
+	l.Resume()
+	within(t, 5*time.Second, func() {
+		for o.State().Connected {
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestSendErrorAndCloseSaysGoodbyeFirst(t *testing.T) {
+	conn := dialHandler(t, func(conn *websocket.Conn) {
+		sendErrorAndClose(conn, "No lobby [x]")
+	})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var errorMessage ErrorMessage
+	if err := conn.ReadJSON(&errorMessage); err != nil || errorMessage.Error != "No lobby [x]" {
+		t.Fatalf("got %+v, %v, want the error message", errorMessage, err)
+	}
+	var goodbye GoodbyeMessage
+	if err := conn.ReadJSON(&goodbye); err != nil || goodbye.Type != GoodbyeCommand {
+		t.Fatalf("got %+v, %v, want the goodbye before the close", goodbye, err)
+	}
+	if err := conn.WriteJSON(CommandMessage{Type: GoodbyeCommand}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := conn.ReadMessage()
+	var closed *websocket.CloseError
+	if !errors.As(err, &closed) || closed.Code != websocket.ClosePolicyViolation || closed.Text != "No lobby [x]" {
+		t.Errorf("closed with %v, want a policy violation with the reason", err)
+	}
+}
+
+func TestShutdownStopsGoroutines(t *testing.T) {
+	timers := func() int {
+		return pumpCount("(*Lobby).StartTimer.func1") + pumpCount("(*Lobby).reapDeadConnections")
+	}
+	before := timers()
+	l := newLobby(7, 7, 1, nil)
+	l.StartTimer(time.Millisecond)
+	conn := dialPod(t, l, AuthMessage{ID: "leaving", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	within(t, 5*time.Second, func() {
+		for timers() != before+2 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	l.Shutdown()
+	l.Shutdown()
+	within(t, 5*time.Second, func() {
+		for timers() != before || pumpCount("(*Octapod).readPump") > 0 || pumpCount("(*Octapod).writePump") > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	if l.ConnectedCount() != 0 {
+		t.Error("pods still connected after Shutdown")
+	}
+
+	// The lobby can run again
+	l.StartTimer(time.Millisecond)
+	within(t, 5*time.Second, func() {
+		for timers() != before+2 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	l.Shutdown()
+	within(t, 5*time.Second, func() {
+		for timers() != before {
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var connIds atomic.Uint64
+
+// nextConnId numbers WebSocket connections so the lines of one connection can be
+// told apart from a reconnect of the same octapod
+func nextConnId() uint64 {
+	return connIds.Add(1)
+}
+
+// ConfigureLogging installs the default slog logger, which the standard log package
+// also writes through. level is debug, info, warn or error, format is json or text,
+// and path appends to a file instead of stderr when set.
+func ConfigureLogging(level, format, path string) error {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return fmt.Errorf("invalid log level %q", level)
+		}
+	}
+	var out io.Writer = os.Stderr
+	if path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+
+	options := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, options)))
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(out, options)))
+	default:
+		return fmt.Errorf("invalid log format %q, expected json or text", format)
+	}
+	return nil
+}
+
+// logger tags lines with the lobby's ID once it is registered with a manager
+func (l *Lobby) logger() *slog.Logger {
+	if logger := l.slogger.Load(); logger != nil {
+		return logger
+	}
+	return slog.Default()
+}
+
+func (l *Lobby) setLogId(id string) {
+	l.slogger.Store(slog.Default().With("lobby", id))
+}
+
+// logger tags lines with the lobby, the octapod and its current connection
+func (o *Octapod) logger() *slog.Logger {
+	return o.lobby.logger().With("octapod", o.Id, "conn", o.connId.Load())
+}
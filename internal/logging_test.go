@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer collects log lines written from several goroutines
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+// captureLogs sends the default logger's JSON lines to the returned function for
+// the rest of the test
+func captureLogs(t *testing.T) func() []map[string]any {
+	t.Helper()
+	out := &lockedBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return func() []map[string]any {
+		out.mutex.Lock()
+		defer out.mutex.Unlock()
+		var lines []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(out.buf.String()), "\n") {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err == nil {
+				lines = append(lines, entry)
+			}
+		}
+		return lines
+	}
+}
+
+func TestLobbyLogsCarryLobbyId(t *testing.T) {
+	logs := captureLogs(t)
+	m := newTestManager(t)
+	lobby, err := m.CreateLobby("practice", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lobby.setPhase(PhaseFinished, 0, nil)
+
+	want := map[string]bool{"Lobby created": false, "Lobby phase changed": false}
+	for _, entry := range logs() {
+		msg, _ := entry["msg"].(string)
+		if _, tracked := want[msg]; tracked {
+			want[msg] = true
+			if entry["lobby"] != "practice" {
+				t.Errorf("%q logged without the lobby: %v", msg, entry)
+			}
+		}
+	}
+	for msg, seen := range want {
+		if !seen {
+			t.Errorf("%q was not logged", msg)
+		}
+	}
+}
+
+func TestNewLobbyLogsMazeAtDebugOnly(t *testing.T) {
+	logs := captureLogs(t)
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	lobby := NewLobbyWithBot(6, 6, 1, nil, "")
+	os.Stdout = stdout
+	write.Close()
+	t.Cleanup(lobby.Shutdown)
+
+	printed, _ := io.ReadAll(read)
+	if len(printed) > 0 {
+		t.Errorf("lobby creation printed to stdout:\n%s", printed)
+	}
+	for _, entry := range logs() {
+		if entry["msg"] == "Maze generated" {
+			if entry["level"] != "DEBUG" || entry["maze"] != lobby.Maze.Print() {
+				t.Errorf("maze logged as %v", entry)
+			}
+			return
+		}
+	}
+	t.Error("the generated maze was not logged at debug level")
+}
@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrLobbyExists = errors.New("lobby already exists")
+var ErrNoLobby = errors.New("no such lobby")
+
+var MaxLobbies = 16
+var DefaultLobbyWidth = 10
+var DefaultLobbyHeight = 10
+
+// CreateLobbyOnJoin creates unknown lobbies with the default size when a pod joins them
+var CreateLobbyOnJoin = false
+
+// LobbyTTL removes lobbies that stayed finished or without a connected pod for this
+// long, the default lobby excepted. 0 keeps every lobby.
+var LobbyTTL time.Duration = 0
+var LobbySweepInterval = time.Minute
+
+// LobbyManager hosts several independent lobbies in one process. Each lobby keeps
+// its own maze, pods and timer, and all of them share one Discord bot.
+type LobbyManager struct {
+	DiscordBot *DiscordBot
+	lobbies    map[string]*Lobby
+	idleSince  map[*Lobby]time.Time // Lobbies the sweeper found finished or empty
+	mutex      sync.RWMutex
+}
+
+func NewLobbyManager(bot *DiscordBot) *LobbyManager {
+	m := &LobbyManager{
+		DiscordBot: bot,
+		lobbies:    make(map[string]*Lobby),
+		idleSince:  make(map[*Lobby]time.Time),
+	}
+	bot.Manager = m
+	return m
+}
+
+// CreateLobby starts a lobby under the given ID, IDs are case-insensitive like pod IDs
+func (m *LobbyManager) CreateLobby(id string, width, height int) (*Lobby, error) {
+	id = strings.ToLower(id)
+	if id == "" {
+		return nil, errors.New("lobby ID must not be empty")
+	}
+	if err := CheckSize(MazeGenerator, width, height); err != nil {
+		return nil, err
+	}
+
+	m.mutex.RLock()
+	err := m.checkRoom(id)
+	m.mutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	// Generating the maze can take a while, so other lobbies stay reachable meanwhile
+	lobby := NewLobbyWithBot(width, height, 0, m.DiscordBot, frameLogPath(id))
+
+	m.mutex.Lock()
+	if err := m.checkRoom(id); err != nil {
+		// Another request took the ID or the last slot while the maze was generated
+		m.mutex.Unlock()
+		lobby.Shutdown()
+		return nil, err
+	}
+	m.add(id, lobby)
+	m.mutex.Unlock()
+	lobby.logger().Info("Lobby created")
+	return lobby, nil
+}
+
+// checkRoom reports whether a lobby can be created under id, must hold m.mutex
+func (m *LobbyManager) checkRoom(id string) error {
+	if _, exists := m.lobbies[id]; exists {
+		return fmt.Errorf("%w: %s", ErrLobbyExists, id)
+	}
+	if len(m.lobbies) >= MaxLobbies {
+		return fmt.Errorf("at most %d lobbies can run at once", MaxLobbies)
+	}
+	return nil
+}
+
+// AddLobby registers an already running lobby, such as the default one
+func (m *LobbyManager) AddLobby(id string, lobby *Lobby) error {
+	id = strings.ToLower(id)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, exists := m.lobbies[id]; exists {
+		return fmt.Errorf("%w: %s", ErrLobbyExists, id)
+	}
+	m.add(id, lobby)
+	return nil
+}
+
+// add must hold m.mutex
+func (m *LobbyManager) add(id string, lobby *Lobby) {
+	lobby.setLogId(id)
+	m.lobbies[id] = lobby
+	if m.DiscordBot.Lobby == nil {
+		m.DiscordBot.SetLobby(lobby)
+	}
+}
+
+func (m *LobbyManager) GetLobby(id string) (*Lobby, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	lobby, exists := m.lobbies[strings.ToLower(id)]
+	return lobby, exists
+}
+
+// RemoveLobby shuts a lobby down without touching the others. The lobby the bot
+// reports on can't be removed.
+func (m *LobbyManager) RemoveLobby(id string) error {
+	id = strings.ToLower(id)
+	m.mutex.Lock()
+	lobby, exists := m.lobbies[id]
+	if !exists {
+		m.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrNoLobby, id)
+	}
+	if lobby == m.DiscordBot.Lobby {
+		m.mutex.Unlock()
+		return errors.New("the default lobby can't be closed")
+	}
+	delete(m.lobbies, id)
+	delete(m.idleSince, lobby)
+	m.mutex.Unlock()
+
+	lobby.Shutdown()
+	lobby.logger().Info("Lobby removed")
+	return nil
+}
+
+// Shutdown stops every lobby, the default one included, before the server exits.
+// The lobbies stay registered.
+func (m *LobbyManager) Shutdown() {
+	m.mutex.RLock()
+	lobbies := make([]*Lobby, 0, len(m.lobbies))
+	for _, lobby := range m.lobbies {
+		lobbies = append(lobbies, lobby)
+	}
+	m.mutex.RUnlock()
+	for _, lobby := range lobbies {
+		lobby.Shutdown()
+	}
+}
+
+// StartSweeper removes lobbies idle for LobbyTTL until ctx is done, it does nothing
+// while LobbyTTL is 0
+func (m *LobbyManager) StartSweeper(ctx context.Context) {
+	if LobbyTTL <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(min(LobbySweepInterval, LobbyTTL))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				m.Sweep(now)
+			}
+		}
+	}()
+}
+
+// Sweep removes the lobbies that have been finished or empty for LobbyTTL as of now,
+// stopping their timers, and returns their IDs
+func (m *LobbyManager) Sweep(now time.Time) []string {
+	if LobbyTTL <= 0 {
+		return nil
+	}
+	m.mutex.RLock()
+	lobbies := make(map[string]*Lobby, len(m.lobbies))
+	for id, lobby := range m.lobbies {
+		if lobby != m.DiscordBot.Lobby {
+			lobbies[id] = lobby
+		}
+	}
+	m.mutex.RUnlock()
+
+	var expired []string
+	for id, lobby := range lobbies {
+		idle := lobby.Phase() == PhaseFinished || lobby.ConnectedCount() == 0
+		m.mutex.Lock()
+		since, tracked := m.idleSince[lobby]
+		switch {
+		case !idle:
+			delete(m.idleSince, lobby)
+		case !tracked:
+			m.idleSince[lobby] = now
+		case now.Sub(since) >= LobbyTTL:
+			expired = append(expired, id)
+		}
+		m.mutex.Unlock()
+	}
+	sort.Strings(expired)
+	for _, id := range expired {
+		if err := m.RemoveLobby(id); err != nil {
+			slog.Error("Error sweeping lobby", "lobby", id, "err", err)
+		}
+	}
+	return expired
+}
+
+type LobbyInfo struct {
+	Id        string `json:"id"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Pods      int    `json:"pods"`
+	Connected int    `json:"connected"`
+	Phase     Phase  `json:"phase"`
+}
+
+// Lobbies lists the running lobbies in ID order
+func (m *LobbyManager) Lobbies() []LobbyInfo {
+	m.mutex.RLock()
+	infos := make([]LobbyInfo, 0, len(m.lobbies))
+	lobbies := make([]*Lobby, 0, len(m.lobbies))
+	for id, lobby := range m.lobbies {
+		infos = append(infos, LobbyInfo{Id: id})
+		lobbies = append(lobbies, lobby)
+	}
+	m.mutex.RUnlock()
+
+	for i, lobby := range lobbies {
+		lobby.Mutex.RLock()
+		infos[i].Width, infos[i].Height = lobby.Maze.Width, lobby.Maze.Height
+		infos[i].Pods = len(lobby.Octapods)
+		lobby.Mutex.RUnlock()
+		infos[i].Connected = lobby.ConnectedCount()
+		infos[i].Phase = lobby.Phase()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
+
+func (m *LobbyManager) HandleListLobbies(c *gin.Context) {
+	c.JSON(http.StatusOK, m.Lobbies())
+}
+
+type CreateLobbyRequest struct {
+	Id     string `json:"id"`
+	Width  int    `json:"width"`  // Optional, DefaultLobbyWidth when 0
+	Height int    `json:"height"` // Optional, DefaultLobbyHeight when 0
+}
+
+func (m *LobbyManager) HandleCreateLobby(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	var req CreateLobbyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorMessage{Error: "Invalid lobby request: " + err.Error()})
+		return
+	}
+	if req.Width == 0 {
+		req.Width = DefaultLobbyWidth
+	}
+	if req.Height == 0 {
+		req.Height = DefaultLobbyHeight
+	}
+	if _, err := m.CreateLobby(req.Id, req.Width, req.Height); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrLobbyExists) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, ErrorMessage{Error: err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"id": strings.ToLower(req.Id)})
+}
+
+func (m *LobbyManager) HandleCloseLobby(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	if err := m.RemoveLobby(c.Param("lobby")); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrNoLobby) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, ErrorMessage{Error: err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// Route adapts a lobby handler, such as (*Lobby).HandleState, to serve the lobby
+// named by the :lobby path parameter
+func (m *LobbyManager) Route(handler func(*Lobby, *gin.Context)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("lobby")
+		lobby, exists := m.GetLobby(id)
+		if !exists {
+			c.JSON(http.StatusNotFound, ErrorMessage{Error: "No lobby [" + displayId(id) + "]"})
+			return
+		}
+		handler(lobby, c)
+	}
+}
+
+// HandleJoin routes /join/:lobby to the named lobby, unknown lobbies get an error
+// over the socket
+func (m *LobbyManager) HandleJoin(c *gin.Context) {
+	id := c.Param("lobby")
+	lobby, exists := m.GetLobby(id)
+	if !exists && CreateLobbyOnJoin {
+		var err error
+		if lobby, err = m.CreateLobby(id, DefaultLobbyWidth, DefaultLobbyHeight); err == nil {
+			exists = true
+		} else if lobby, exists = m.GetLobby(id); !exists {
+			// Another join may have created it first
+			slog.Error("Error creating lobby on join", "lobby", id, "err", err)
+		}
+	}
+	if !exists {
+		upgrader := newUpgrader()
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			slog.Warn("WebSocket upgrade failed", "lobby", id, "err", err)
+			return
+		}
+		sendErrorAndClose(conn, "No lobby ["+displayId(id)+"]")
+		return
+	}
+	lobby.HandleJoin(c)
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestManager(t *testing.T) *LobbyManager {
+	t.Helper()
+	m := NewLobbyManager(NewDiscordBot(DiscordConfig{Offline: true}))
+	t.Cleanup(m.Shutdown)
+	return m
+}
+
+func TestListLobbies(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setFor(t, &RoundMode, true)
+	m := newTestManager(t)
+	if _, err := m.CreateLobby("Small", 6, 8); err != nil {
+		t.Fatal(err)
+	}
+	big, err := m.CreateLobby("big", 12, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPod(t, big, "pod")
+	setFor(t, &CountdownDuration, 0)
+	if err := big.StartRound(); err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.GET("/lobbies", m.HandleListLobbies)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/lobbies", nil))
+	var infos []LobbyInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &infos); err != nil {
+		t.Fatal(err)
+	}
+	want := []LobbyInfo{
+		{Id: "big", Width: 12, Height: 10, Pods: 1, Connected: 1, Phase: PhaseRunning},
+		{Id: "small", Width: 6, Height: 8, Phase: PhaseWaiting},
+	}
+	if len(infos) != len(want) {
+		t.Fatalf("got %+v, want %+v", infos, want)
+	}
+	for i := range want {
+		if infos[i] != want[i] {
+			t.Errorf("lobby %d is %+v, want %+v", i, infos[i], want[i])
+		}
+	}
+}
+
+func TestSweepRemovesIdleLobbies(t *testing.T) {
+	setFor(t, &LobbyTTL, time.Hour)
+	m := newTestManager(t)
+	mainLobby, err := m.CreateLobby("main", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty, err := m.CreateLobby("empty", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := m.CreateLobby("busy", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPod(t, busy, "pod")
+	if m.DiscordBot.Lobby != mainLobby {
+		t.Fatal("the first lobby should be the bot's")
+	}
+
+	now := time.Now()
+	if removed := m.Sweep(now); len(removed) != 0 {
+		t.Fatalf("removed %v on the first sweep", removed)
+	}
+	if removed := m.Sweep(now.Add(59 * time.Minute)); len(removed) != 0 {
+		t.Fatalf("removed %v before the TTL", removed)
+	}
+	removed := m.Sweep(now.Add(time.Hour))
+	if len(removed) != 1 || removed[0] != "empty" {
+		t.Fatalf("removed %v, want only the empty lobby", removed)
+	}
+	if _, exists := m.GetLobby("empty"); exists {
+		t.Error("the empty lobby is still listed")
+	}
+	empty.Mutex.RLock()
+	running := empty.timerRunning
+	empty.Mutex.RUnlock()
+	if running {
+		t.Error("the removed lobby's timer is still running")
+	}
+	if _, exists := m.GetLobby("main"); !exists {
+		t.Error("the default lobby was removed")
+	}
+}
+
+func TestSweepDisabled(t *testing.T) {
+	setFor(t, &LobbyTTL, 0)
+	m := newTestManager(t)
+	m.DiscordBot.Lobby = newTestLobby(t, 6, 6, 1)
+	if _, err := m.CreateLobby("empty", 6, 6); err != nil {
+		t.Fatal(err)
+	}
+	m.Sweep(time.Now())
+	if removed := m.Sweep(time.Now().Add(1000 * time.Hour)); len(removed) != 0 {
+		t.Errorf("removed %v with the TTL off", removed)
+	}
+}
+
+func TestCreateLobbyConcurrently(t *testing.T) {
+	setFor(t, &MaxLobbies, 3)
+	m := newTestManager(t)
+	const creators = 8
+	errs := make([]error, creators)
+	within(t, 10*time.Second, func() {
+		var wg sync.WaitGroup
+		for i := range creators {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = m.CreateLobby("Race", 6, 6)
+			}()
+		}
+		wg.Wait()
+	})
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrLobbyExists):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("%d creations of the same lobby succeeded, want 1", created)
+	}
+
+	within(t, 10*time.Second, func() {
+		var wg sync.WaitGroup
+		for i := range creators {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = m.CreateLobby("lobby"+strconv.Itoa(i), 6, 6)
+			}()
+		}
+		wg.Wait()
+	})
+	if n := len(m.Lobbies()); n != MaxLobbies {
+		t.Errorf("%d lobbies running, want at most %d", n, MaxLobbies)
+	}
+}
+
+func TestRouteResolvesLobby(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := newTestManager(t)
+	for _, id := range []string{"one", "two"} {
+		if _, err := m.CreateLobby(id, 6, 6); err != nil {
+			t.Fatal(err)
+		}
+	}
+	one, _ := m.GetLobby("one")
+	addPod(t, one, "pod")
+
+	router := gin.New()
+	router.GET("/lobbies/:lobby/pods/:id", m.Route((*Lobby).HandlePod))
+	for path, want := range map[string]int{
+		"/lobbies/one/pods/pod":   http.StatusOK,
+		"/lobbies/ONE/pods/pod":   http.StatusOK,
+		"/lobbies/two/pods/pod":   http.StatusNotFound,
+		"/lobbies/three/pods/pod": http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("GET %s: status %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestSweepRemovesFinishedLobbies(t *testing.T) {
+	setFor(t, &RoundMode, true)
+	setFor(t, &LobbyTTL, time.Minute)
+	m := newTestManager(t)
+	m.DiscordBot.Lobby = newTestLobby(t, 6, 6, 1)
+	finished, err := m.CreateLobby("finished", 6, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Still connected, but the round is over
+	addPod(t, finished, "pod")
+	finished.setPhase(PhaseFinished, 0, nil)
+
+	now := time.Now()
+	m.Sweep(now)
+	if removed := m.Sweep(now.Add(time.Minute)); len(removed) != 1 || removed[0] != "finished" {
+		t.Fatalf("removed %v, want the finished lobby", removed)
+	}
+	finished.Mutex.RLock()
+	running := finished.timerRunning
+	finished.Mutex.RUnlock()
+	if running {
+		t.Error("the removed lobby's timer is still running")
+	}
+}
+
+func TestManagerShutdownStopsEveryLobby(t *testing.T) {
+	m := newTestManager(t)
+	var lobbies []*Lobby
+	for _, id := range []string{"main", "practice"} {
+		lobby, err := m.CreateLobby(id, 6, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lobbies = append(lobbies, lobby)
+	}
+	conn := dialPod(t, lobbies[1], AuthMessage{ID: "player", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+
+	m.Shutdown()
+	for i, lobby := range lobbies {
+		lobby.Mutex.RLock()
+		running := lobby.timerRunning
+		lobby.Mutex.RUnlock()
+		if running {
+			t.Errorf("lobby %d timer still running", i)
+		}
+	}
+	if lobbies[1].ConnectedCount() != 0 {
+		t.Error("a pod in the second lobby is still connected")
+	}
+}
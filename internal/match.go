@@ -0,0 +1,298 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ReplayDir = "" // Empty disables match recording
+
+type MatchEventType string
+
+const (
+	MatchStart   MatchEventType = "start"   // First line of a match, carries the maze
+	MatchJoin    MatchEventType = "join"    // A pod registered at its spawn position
+	MatchSensor  MatchEventType = "sensor"  // A sensor reading was handed to a pod
+	MatchMove    MatchEventType = "move"    // A pod moved to a new position
+	MatchFinish  MatchEventType = "finish"  // A pod reached the exit
+	MatchForfeit MatchEventType = "forfeit" // A pod gave up, it finished as a DNF
+)
+
+// MatchEvent is one line of a match log
+type MatchEvent struct {
+	Type     MatchEventType `json:"type"`
+	Tick     int64          `json:"tick"`
+	Time     time.Time      `json:"time"`
+	MazeSeed int64          `json:"mazeSeed,omitempty"`
+	Maze     *MazeMessage   `json:"maze,omitempty"`
+	Id       string         `json:"id,omitempty"`
+	Tag      string         `json:"tag,omitempty"`
+	Position *Point         `json:"position,omitempty"`
+	Sensor   *Sensor        `json:"sensor,omitempty"`
+}
+
+// MatchRecorder writes the events of one match as JSON lines
+type MatchRecorder struct {
+	Path string
+
+	file    *os.File
+	encoder *json.Encoder
+	mutex   sync.Mutex
+}
+
+// openMatch starts a new match log for the lobby's current maze, closing the previous one
+func (l *Lobby) openMatch() {
+	var match *MatchRecorder
+	if ReplayDir != "" {
+		match = l.newMatchRecorder()
+	}
+	if previous := l.match.Swap(match); previous != nil {
+		previous.Close()
+	}
+}
+
+func (l *Lobby) newMatchRecorder() *MatchRecorder {
+	if err := os.MkdirAll(ReplayDir, 0755); err != nil {
+		l.logger().Error("Error creating replay directory", "err", err)
+		return nil
+	}
+	l.Mutex.RLock()
+	seed := l.MazeSeed
+	maze := l.Maze.Message()
+	l.Mutex.RUnlock()
+
+	name := fmt.Sprintf("match-%s-%d.jsonl", time.Now().Format("20060102-150405.000"), seed)
+	path := filepath.Join(ReplayDir, name)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		l.logger().Error("Error opening match log", "err", err)
+		return nil
+	}
+	match := &MatchRecorder{Path: path, file: file, encoder: json.NewEncoder(file)}
+	match.write(MatchEvent{Type: MatchStart, Tick: l.tick.Load(), MazeSeed: seed, Maze: &maze})
+	l.logger().Info("Recording match", "path", path)
+	return match
+}
+
+func (m *MatchRecorder) write(event MatchEvent) {
+	if m == nil {
+		return
+	}
+	event.Time = time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.file == nil {
+		return
+	}
+	if err := m.encoder.Encode(event); err != nil {
+		slog.Error("Error recording match event", "path", m.Path, "err", err)
+	}
+}
+
+func (m *MatchRecorder) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.file != nil {
+		m.file.Close()
+		m.file = nil
+	}
+}
+
+// recordMatch logs an event for the pod at its current position, must hold o.Mutex
+func (o *Octapod) recordMatch(kind MatchEventType, sensor *Sensor) {
+	match := o.lobby.match.Load()
+	if match == nil {
+		return
+	}
+	position := pointOf(o.Position)
+	event := MatchEvent{Type: kind, Tick: o.lobby.tick.Load(), Id: o.Id, Position: &position, Sensor: sensor}
+	if kind == MatchJoin {
+		event.Tag = o.Tag
+	}
+	match.write(event)
+}
+
+// LoadMatch reads a match log written by a MatchRecorder
+func LoadMatch(r io.Reader) ([]MatchEvent, error) {
+	var events []MatchEvent
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024) // Start events carry whole mazes
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var event MatchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", len(events)+1, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 || events[0].Type != MatchStart || events[0].Maze == nil {
+		return nil, errors.New("match log does not begin with a start event")
+	}
+	return events, nil
+}
+
+// Event is one entry of a recorded replay
+type Event = MatchEvent
+
+// DiffReplays compares two replays event by event, ignoring wall-clock times, and
+// returns the index of the first event that differs. When one replay ends early the
+// index is the shorter length. Replays of the same seed and inputs must never diverge.
+func DiffReplays(a, b []Event) (int, bool) {
+	for i := range min(len(a), len(b)) {
+		x, y := a[i], b[i]
+		x.Time, y.Time = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(x, y) {
+			return i, true
+		}
+	}
+	if len(a) != len(b) {
+		return min(len(a), len(b)), true
+	}
+	return -1, false
+}
+
+// MatchFrames replays the events into one board per tick in which something happened
+func MatchFrames(events []MatchEvent) []LobbySnapshot {
+	if len(events) == 0 || events[0].Maze == nil {
+		return nil
+	}
+	start := events[0]
+	pods := make(map[string]*PodState)
+	var order []string
+	var frames []LobbySnapshot
+
+	flush := func(tick int64) {
+		frame := LobbySnapshot{Tick: tick, MazeSeed: start.MazeSeed, Maze: *start.Maze, Pods: make([]PodState, 0, len(order))}
+		for _, id := range order {
+			frame.Pods = append(frame.Pods, *pods[id])
+		}
+		frames = append(frames, frame)
+	}
+
+	tick := start.Tick
+	for _, event := range events[1:] {
+		if event.Tick != tick {
+			flush(tick)
+			tick = event.Tick
+		}
+		pod, exists := pods[event.Id]
+		if !exists {
+			if event.Id == "" {
+				continue
+			}
+			pod = &PodState{Id: event.Id, Tag: defaultTag(event.Id), JoinedAt: event.Time}
+			pods[event.Id] = pod
+			order = insertSorted(order, event.Id)
+		}
+		if event.Tag != "" {
+			pod.Tag = event.Tag
+		}
+		if event.Position != nil {
+			pod.Position = *event.Position
+		}
+		switch event.Type {
+		case MatchMove:
+			pod.Steps++
+		case MatchFinish:
+			pod.Finished = true
+			pod.FinishedAt = finishedAt(event.Time)
+		case MatchForfeit:
+			pod.Finished = true
+			pod.DNF = true
+			pod.FinishedAt = finishedAt(event.Time)
+		}
+	}
+	flush(tick)
+	return frames
+}
+
+func insertSorted(ids []string, id string) []string {
+	i := 0
+	for i < len(ids) && ids[i] < id {
+		i++
+	}
+	return append(ids[:i], append([]string{id}, ids[i:]...)...)
+}
+
+// PlayMatch prints every frame of a match log, waiting delay between frames
+func PlayMatch(w io.Writer, path string, delay time.Duration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	events, err := LoadMatch(file)
+	if err != nil {
+		return err
+	}
+	for i, frame := range MatchFrames(events) {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		fmt.Fprintf(w, "Tick %d:\n%s\n", frame.Tick, frame.Render(""))
+	}
+	return nil
+}
+
+// HandleReplay serves the frames of the current match, or of ?match=<file> from
+// ReplayDir. ?format=text renders them like the Discord board.
+func (l *Lobby) HandleReplay(c *gin.Context) {
+	if ReplayDir == "" {
+		c.JSON(http.StatusNotFound, ErrorMessage{Error: "Match recording is disabled."})
+		return
+	}
+	path := ""
+	if name := c.Query("match"); name != "" {
+		if filepath.Base(name) != name || !strings.HasSuffix(name, ".jsonl") {
+			c.JSON(http.StatusBadRequest, ErrorMessage{Error: "Invalid match name."})
+			return
+		}
+		path = filepath.Join(ReplayDir, name)
+	} else if match := l.match.Load(); match != nil {
+		path = match.Path
+	}
+	if path == "" {
+		c.JSON(http.StatusNotFound, ErrorMessage{Error: "No match is being recorded."})
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		c.JSON(http.StatusNotFound, ErrorMessage{Error: "No such match."})
+		return
+	}
+	defer file.Close()
+	events, err := LoadMatch(file)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, ErrorMessage{Error: err.Error()})
+		return
+	}
+	frames := MatchFrames(events)
+	if c.Query("format") == "text" {
+		var b strings.Builder
+		for _, frame := range frames {
+			fmt.Fprintf(&b, "Tick %d:\n%s\n", frame.Tick, frame.Render(""))
+		}
+		c.String(http.StatusOK, b.String())
+		return
+	}
+	c.JSON(http.StatusOK, frames)
+}
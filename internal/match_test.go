@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func testReplay(at time.Time) []Event {
+	maze := MazeMessage{Width: 2, Height: 2, Walls: [][]bool{{false, false}, {true, false}}, Exit: Point{1, 1}}
+	return []Event{
+		{Type: MatchStart, Tick: 0, Time: at, MazeSeed: 7, Maze: &maze},
+		{Type: MatchJoin, Tick: 0, Time: at, Id: "a", Tag: "A", Position: &Point{0, 0}},
+		{Type: MatchSensor, Tick: 1, Time: at, Id: "a", Position: &Point{0, 0}, Sensor: &Sensor{Down: true}},
+		{Type: MatchMove, Tick: 1, Time: at, Id: "a", Position: &Point{0, 1}},
+		{Type: MatchMove, Tick: 2, Time: at, Id: "a", Position: &Point{1, 1}},
+		{Type: MatchFinish, Tick: 2, Time: at, Id: "a", Position: &Point{1, 1}},
+	}
+}
+
+func TestDiffReplaysIdentical(t *testing.T) {
+	// Recorded at different times, the events still match
+	a := testReplay(time.Unix(0, 0))
+	b := testReplay(time.Unix(3600, 0))
+	if index, diverged := DiffReplays(a, b); diverged {
+		t.Fatalf("identical replays diverge at %d", index)
+	}
+	if _, diverged := DiffReplays(nil, nil); diverged {
+		t.Fatal("empty replays diverge")
+	}
+}
+
+func TestDiffReplaysDiverging(t *testing.T) {
+	at := time.Unix(0, 0)
+	tests := []struct {
+		name   string
+		change func([]Event) []Event
+		want   int
+	}{
+		{"position", func(e []Event) []Event { e[3].Position = &Point{1, 0}; return e }, 3},
+		{"sensor", func(e []Event) []Event { e[2].Sensor = &Sensor{Right: true}; return e }, 2},
+		{"maze", func(e []Event) []Event { e[0].Maze.Walls[1][0] = false; return e }, 0},
+		{"tick", func(e []Event) []Event { e[5].Tick = 3; return e }, 5},
+		{"truncated", func(e []Event) []Event { return e[:4] }, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := testReplay(at), test.change(testReplay(at))
+			index, diverged := DiffReplays(a, b)
+			if !diverged || index != test.want {
+				t.Errorf("DiffReplays = %d, %v, want %d, true", index, diverged, test.want)
+			}
+			if index, diverged := DiffReplays(b, a); !diverged || index != test.want {
+				t.Errorf("swapped DiffReplays = %d, %v, want %d, true", index, diverged, test.want)
+			}
+		})
+	}
+}
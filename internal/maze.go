@@ -2,21 +2,38 @@
 package internal
 
 import (
+	"errors"
+	"fmt"
 	"github.com/quartercastle/vector"
+	"log/slog"
+	"math"
 	"math/rand"
+	"sync"
 )
 
+var RequireConnected = true
+var MaxGenerateAttempts = 10
+var ResizeFillWalls = true // Cells added by Resize are walls, otherwise open
+
+var ErrUnsolvable = errors.New("maze exit is not reachable from the entrance")
+
 type Maze struct {
-	Width   int
-	Height  int
-	cells   [][]bool // true: wall, false: path
-	visited [][]bool
+	Width      int
+	Height     int
+	Entrance   Point
+	Exit       Point
+	ExtraExits []Point  // Finish cells besides Exit, see ExtraExitCount
+	Theme      string   // Names the palette in Themes, see MazeTheme
+	cells      [][]bool // true: wall, false: path
+	visited    [][]bool
+	mutex      sync.RWMutex // Guards cells once the maze is shared, e.g. in chaos mode
 }
 
 func NewMaze(width, height int) *Maze {
 	m := &Maze{
 		Width:   width,
 		Height:  height,
+		Theme:   MazeTheme,
 		cells:   make([][]bool, width),
 		visited: make([][]bool, width),
 	}
@@ -27,59 +44,45 @@ func NewMaze(width, height int) *Maze {
 	return m
 }
 
-// Generate creates a maze with walls (true) and passages (false)
-// Start is at (0,0) and end is at (width-1,height-1)
-func (m *Maze) Generate() {
-	// First, fill the entire maze with walls
-	for x := 0; x < m.Width; x++ {
-		for y := 0; y < m.Height; y++ {
-			m.cells[x][y] = true
-			m.visited[x][y] = false
-		}
+// NewMazeWithSeed generates a solvable maze, the same seed always yields the same cells
+func NewMazeWithSeed(width, height int, seed int64) (*Maze, error) {
+	if err := CheckSize(MazeGenerator, width, height); err != nil {
+		return nil, err
 	}
-
-	// Use depth-first search with backtracking to create paths
-	// Start from (1,1) in cell coordinates
-	m.carvePassages(1, 1)
-
-	// Create entrance (top-left) and exit (bottom-right)
-	m.cells[0][0] = false
-	m.cells[1][0] = false
-	m.cells[m.Width-1][m.Height-1] = false
-	m.cells[m.Width-2][m.Height-1] = false
-}
-
-// carvePassages uses depth-first search with backtracking to carve passages
-func (m *Maze) carvePassages(x, y int) {
-	// Mark the current cell as a passage
-	m.cells[x][y] = false
-
-	// Define the four possible directions: N, E, S, W
-	directions := []struct{ dx, dy int }{
-		{0, -2}, // North
-		{2, 0},  // East
-		{0, 2},  // South
-		{-2, 0}, // West
+	m := NewMaze(width, height)
+	if err := m.GenerateFromSeed(MazeGenerator, seed); err != nil {
+		return nil, err
 	}
+	return m, nil
+}
 
-	// Shuffle the directions for randomness
-	rand.Shuffle(len(directions), func(i, j int) {
-		directions[i], directions[j] = directions[j], directions[i]
-	})
+// GenerateFromSeed generates a solvable maze, the same generator and seed always
+// yield the same cells
+func (m *Maze) GenerateFromSeed(g Generator, seed int64) error {
+	return m.GenerateSolvableWith(g, rand.New(rand.NewSource(seed)))
+}
 
-	// Try each direction
-	for _, dir := range directions {
-		newX, newY := x+dir.dx, y+dir.dy
+// Generate creates a maze with walls (true) and passages (false) using MazeGenerator
+// All randomness is drawn from rng so the same seed yields the same maze
+func (m *Maze) Generate(rng *rand.Rand) {
+	m.GenerateWith(MazeGenerator, rng)
+}
 
-		// Check if the new position is within bounds and unvisited (still a wall)
-		if newX >= 0 && newX < m.Width && newY >= 0 && newY < m.Height && m.cells[newX][newY] {
-			// Carve a passage by removing the wall between current cell and new cell
-			m.cells[x+dir.dx/2][y+dir.dy/2] = false
+// GenerateWith replaces the maze's cells with a layout from the given generator
+func (m *Maze) GenerateWith(g Generator, rng *rand.Rand) {
+	cells := g.Generate(m.Width, m.Height, rng)
+	entrance, exit := g.Endpoints(cells)
 
-			// Continue DFS from the new cell
-			m.carvePassages(newX, newY)
-		}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.cells = cells
+	m.visited = make([][]bool, len(cells))
+	for x := range cells {
+		m.visited[x] = make([]bool, len(cells[x]))
 	}
+	m.Entrance = entrance
+	m.Exit = exit
+	m.ExtraExits = m.pickExtraExits(ExtraExitCount)
 }
 
 // Print returns a string representation of the maze
@@ -104,18 +107,103 @@ func (m *Maze) Print() string {
 // And there's no comments. No comments = Human.
 
 func (m *Maze) IsAvailable(point vector.Vector) bool {
-	x := int(point.X())
-	y := int(point.Y())
-	return x >= 0 && x < m.Width && y >= 0 && y < m.Height && !m.cells[x][y]
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.isOpen(pointOf(point))
 }
 
 func (m *Maze) GetSensor(point vector.Vector) *Sensor {
-	return &Sensor{
-		Up:    m.IsAvailable(point.Add(vector.Vector{0, -1})),
-		Right: m.IsAvailable(point.Add(vector.Vector{1, 0})),
-		Down:  m.IsAvailable(point.Add(vector.Vector{0, 1})),
-		Left:  m.IsAvailable(point.Add(vector.Vector{-1, 0})),
+	return m.GetSensorWithRange(pointOf(point), SensorRange)
+}
+
+// GetSensorWithRange reads the neighbours of p, with rays up to rays cells long
+func (m *Maze) GetSensorWithRange(p Point, rays int) *Sensor {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	s := &Sensor{
+		Up:    m.isOpen(Point{p.X, p.Y - 1}),
+		Right: m.isOpen(Point{p.X + 1, p.Y}),
+		Down:  m.isOpen(Point{p.X, p.Y + 1}),
+		Left:  m.isOpen(Point{p.X - 1, p.Y}),
+		North: m.openRun(p, 0, -1, rays),
+		South: m.openRun(p, 0, 1, rays),
+		East:  m.openRun(p, 1, 0, rays),
+		West:  m.openRun(p, -1, 0, rays),
+	}
+	if !EdgeAsWall {
+		s.Boundary = &Directions{
+			Up:    !m.inBounds(Point{p.X, p.Y - 1}),
+			Right: !m.inBounds(Point{p.X + 1, p.Y}),
+			Down:  !m.inBounds(Point{p.X, p.Y + 1}),
+			Left:  !m.inBounds(Point{p.X - 1, p.Y}),
+		}
 	}
+	return s
+}
+
+// window copies the cells within radius of p, cells off the grid read as walls. Under
+// LineOfSight it also marks the cells hidden behind walls, which read as walls too.
+func (m *Maze) window(p Point, radius int) (cells, hidden [][]bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	cells = make([][]bool, 2*radius+1)
+	if LineOfSight {
+		hidden = make([][]bool, 2*radius+1)
+	}
+	for dx := range cells {
+		cells[dx] = make([]bool, 2*radius+1)
+		if hidden != nil {
+			hidden[dx] = make([]bool, 2*radius+1)
+		}
+		for dy := range cells[dx] {
+			q := Point{p.X + dx - radius, p.Y + dy - radius}
+			cells[dx][dy] = !m.isOpen(q)
+			if hidden != nil && !m.visible(p, q) {
+				cells[dx][dy] = true
+				hidden[dx][dy] = true
+			}
+		}
+	}
+	return cells, hidden
+}
+
+// visible walks the grid line from p to q and reports whether every cell strictly
+// between them is open, must hold m.mutex
+func (m *Maze) visible(p, q Point) bool {
+	dx, dy := abs(q.X-p.X), -abs(q.Y-p.Y)
+	sx, sy := 1, 1
+	if q.X < p.X {
+		sx = -1
+	}
+	if q.Y < p.Y {
+		sy = -1
+	}
+	err := dx + dy
+	for c := p; c != q; {
+		e := 2 * err
+		if e >= dy {
+			err += dy
+			c.X += sx
+		}
+		if e <= dx {
+			err += dx
+			c.Y += sy
+		}
+		if c != q && !m.isOpen(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// openRun counts open cells from p in direction (dx, dy) up to max, stopping at walls
+// and the border
+func (m *Maze) openRun(p Point, dx, dy, max int) int {
+	n := 0
+	for n < max && m.isOpen(Point{p.X + (n+1)*dx, p.Y + (n+1)*dy}) {
+		n++
+	}
+	return n
 }
 
 func (m *Maze) Visit(position vector.Vector) {
@@ -136,3 +224,288 @@ func (m *Maze) PrintVisited() string {
 	}
 	return result
 }
+
+func (m *Maze) Walls() [][]bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	walls := make([][]bool, m.Width)
+	for x := range m.cells {
+		walls[x] = append([]bool(nil), m.cells[x]...)
+	}
+	return walls
+}
+
+func (m *Maze) Message() MazeMessage {
+	return MazeMessage{
+		Width:      m.Width,
+		Height:     m.Height,
+		Walls:      m.Walls(),
+		Entrance:   m.Entrance,
+		Exit:       m.Exit,
+		ExtraExits: append([]Point(nil), m.ExtraExits...),
+		Theme:      m.Theme,
+		Palette:    themePalette(m.Theme),
+	}
+}
+
+// MazeFromMessage rebuilds a maze sent or saved with Message
+func MazeFromMessage(msg MazeMessage) (*Maze, error) {
+	if msg.Width <= 0 || msg.Height <= 0 || len(msg.Walls) != msg.Width {
+		return nil, fmt.Errorf("maze message has invalid dimensions %dx%d", msg.Width, msg.Height)
+	}
+	m := NewMaze(msg.Width, msg.Height)
+	for x, column := range msg.Walls {
+		if len(column) != msg.Height {
+			return nil, fmt.Errorf("maze message column %d has %d cells, expected %d", x, len(column), msg.Height)
+		}
+		copy(m.cells[x], column)
+	}
+	m.Entrance = msg.Entrance
+	m.Exit = msg.Exit
+	m.ExtraExits = append([]Point(nil), msg.ExtraExits...)
+	m.Theme = msg.Theme
+	if m.Theme == "" {
+		m.Theme = NeutralTheme
+	}
+	return m, nil
+}
+
+func (m *Maze) Validate() error {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.validate()
+}
+
+func (m *Maze) validate() error {
+	if m.Width <= 0 || m.Height <= 0 {
+		return fmt.Errorf("maze has invalid dimensions %dx%d", m.Width, m.Height)
+	}
+	if len(m.cells) != m.Width {
+		return fmt.Errorf("maze has %d columns, expected %d", len(m.cells), m.Width)
+	}
+	for x, column := range m.cells {
+		if len(column) != m.Height {
+			return fmt.Errorf("maze column %d has %d cells, expected %d", x, len(column), m.Height)
+		}
+	}
+	if !m.inBounds(m.Entrance) {
+		return fmt.Errorf("maze entrance (%d,%d) is out of bounds", m.Entrance.X, m.Entrance.Y)
+	}
+	if !m.inBounds(m.Exit) {
+		return fmt.Errorf("maze exit (%d,%d) is out of bounds", m.Exit.X, m.Exit.Y)
+	}
+	if m.cells[m.Entrance.X][m.Entrance.Y] {
+		return fmt.Errorf("maze entrance (%d,%d) is a wall", m.Entrance.X, m.Entrance.Y)
+	}
+	if m.cells[m.Exit.X][m.Exit.Y] {
+		return fmt.Errorf("maze exit (%d,%d) is a wall", m.Exit.X, m.Exit.Y)
+	}
+	for _, exit := range m.ExtraExits {
+		if !m.isOpen(exit) {
+			return fmt.Errorf("maze exit (%d,%d) is out of bounds or a wall", exit.X, exit.Y)
+		}
+	}
+	if !m.solvable() {
+		return ErrUnsolvable
+	}
+	if RequireConnected {
+		distances := m.distancesFrom(m.Entrance)
+		for x := 0; x < m.Width; x++ {
+			for y := 0; y < m.Height; y++ {
+				if _, reachable := distances[Point{x, y}]; !m.cells[x][y] && !reachable {
+					return fmt.Errorf("maze cell (%d,%d) is not reachable from the entrance", x, y)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Maze) Solvable() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.solvable()
+}
+
+func (m *Maze) solvable() bool {
+	_, solvable := m.distancesFrom(m.Entrance)[m.Exit]
+	return solvable
+}
+
+func (m *Maze) GenerateSolvable(rng *rand.Rand) error {
+	return m.GenerateSolvableWith(MazeGenerator, rng)
+}
+
+func (m *Maze) GenerateSolvableWith(g Generator, rng *rand.Rand) error {
+	var err error
+	for attempt := 1; attempt <= MaxGenerateAttempts; attempt++ {
+		m.GenerateWith(g, rng)
+		if err = m.Validate(); !errors.Is(err, ErrUnsolvable) {
+			return err
+		}
+		slog.Warn("Generated maze is unsolvable, regenerating", "attempt", attempt, "maxAttempts", MaxGenerateAttempts)
+	}
+	return err
+}
+
+// Perturb toggles up to n random cells, undoing any toggle that would leave the maze
+// invalid. Cells in keepOpen, such as occupied ones, are never walled.
+func (m *Maze) Perturb(rng *rand.Rand, n int, keepOpen map[Point]bool) []Point {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var toggled []Point
+	for attempt := 0; len(toggled) < n && attempt < n*10; attempt++ {
+		p := Point{rng.Intn(m.Width), rng.Intn(m.Height)}
+		if p == m.Entrance || m.IsExit(p) || (!m.cells[p.X][p.Y] && keepOpen[p]) {
+			continue
+		}
+		m.cells[p.X][p.Y] = !m.cells[p.X][p.Y]
+		if m.validate() != nil {
+			m.cells[p.X][p.Y] = !m.cells[p.X][p.Y]
+			continue
+		}
+		toggled = append(toggled, p)
+	}
+	return toggled
+}
+
+// Resize changes the maze dimensions, keeping the cells of the overlapping top-left
+// region. The entrance and exit must survive the resize and the result must validate,
+// otherwise the maze is left untouched.
+func (m *Maze) Resize(width, height int) error {
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("maze has invalid dimensions %dx%d", width, height)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	resized := &Maze{Width: width, Height: height, Entrance: m.Entrance, Exit: m.Exit, ExtraExits: m.ExtraExits}
+	if !resized.inBounds(m.Entrance) {
+		return fmt.Errorf("resize to %dx%d removes the entrance (%d,%d)", width, height, m.Entrance.X, m.Entrance.Y)
+	}
+	for _, exit := range m.Exits() {
+		if !resized.inBounds(exit) {
+			return fmt.Errorf("resize to %dx%d removes the exit (%d,%d)", width, height, exit.X, exit.Y)
+		}
+	}
+
+	resized.cells = make([][]bool, width)
+	resized.visited = make([][]bool, width)
+	for x := 0; x < width; x++ {
+		resized.cells[x] = make([]bool, height)
+		resized.visited[x] = make([]bool, height)
+		for y := 0; y < height; y++ {
+			if m.inBounds(Point{x, y}) {
+				resized.cells[x][y] = m.cells[x][y]
+				resized.visited[x][y] = m.visited[x][y]
+			} else {
+				resized.cells[x][y] = ResizeFillWalls
+			}
+		}
+	}
+	if err := resized.validate(); err != nil {
+		return err
+	}
+
+	m.Width, m.Height = width, height
+	m.cells, m.visited = resized.cells, resized.visited
+	return nil
+}
+
+func (m *Maze) inBounds(p Point) bool {
+	return p.X >= 0 && p.X < m.Width && p.Y >= 0 && p.Y < m.Height
+}
+
+func (m *Maze) isOpen(p Point) bool {
+	return m.inBounds(p) && !m.cells[p.X][p.Y]
+}
+
+// ExitDistances maps every cell that can reach an exit to its path length to the nearest one
+func (m *Maze) ExitDistances() map[Point]int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.distancesFrom(m.Exits()...)
+}
+
+func (m *Maze) openNeighbours(p Point) []Point {
+	var result []Point
+	for _, next := range []Point{{p.X, p.Y - 1}, {p.X + 1, p.Y}, {p.X, p.Y + 1}, {p.X - 1, p.Y}} {
+		if m.isOpen(next) {
+			result = append(result, next)
+		}
+	}
+	return result
+}
+
+func (m *Maze) distancesFrom(starts ...Point) map[Point]int {
+	distances := make(map[Point]int, len(starts))
+	for _, start := range starts {
+		distances[start] = 0
+	}
+	queue := append([]Point(nil), starts...)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, next := range m.openNeighbours(p) {
+			if _, seen := distances[next]; !seen {
+				distances[next] = distances[p] + 1
+				queue = append(queue, next)
+			}
+		}
+	}
+	return distances
+}
+
+// DifficultyScore rates the maze from 0 (trivial) to 1 (hard):
+//
+//	0.5 * tortuosity + 0.3 * deadEnds + 0.2 * junctions * deadEnds
+//
+// tortuosity is 1 - manhattan(entrance, exit) / solution length, deadEnds and
+// junctions are the share of open cells with one or with three or more open
+// neighbours, scaled by 10 and capped at 1. Junctions only count alongside dead
+// ends as a choice is only hard when a branch can be wrong. An unsolvable maze
+// scores 1.
+func (m *Maze) DifficultyScore() float64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	solution, solvable := m.distancesFrom(m.Entrance)[m.Exit]
+	if !solvable {
+		return 1
+	}
+
+	open, deadEnds, junctions := 0, 0, 0
+	for x := 0; x < m.Width; x++ {
+		for y := 0; y < m.Height; y++ {
+			if m.cells[x][y] {
+				continue
+			}
+			open++
+			switch n := len(m.openNeighbours(Point{x, y})); {
+			case n == 1:
+				deadEnds++
+			case n >= 3:
+				junctions++
+			}
+		}
+	}
+
+	tortuosity := 0.0
+	if solution > 0 {
+		manhattan := abs(m.Exit.X-m.Entrance.X) + abs(m.Exit.Y-m.Entrance.Y)
+		tortuosity = 1 - float64(manhattan)/float64(solution)
+	}
+	deadEndFactor := math.Min(1, 10*float64(deadEnds)/float64(open))
+	junctionFactor := math.Min(1, 10*float64(junctions)/float64(open))
+
+	return 0.5*tortuosity + 0.3*deadEndFactor + 0.2*junctionFactor*deadEndFactor
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
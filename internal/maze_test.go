@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// openMaze is a width x height maze without walls, entrance top left and exit
+// bottom right
+func openMaze(width, height int) MazeMessage {
+	walls := make([][]bool, width)
+	for x := range walls {
+		walls[x] = make([]bool, height)
+	}
+	return MazeMessage{Width: width, Height: height, Walls: walls, Exit: Point{width - 1, height - 1}}
+}
+
+func TestValidateRejectsOutOfBoundsExit(t *testing.T) {
+	msg := openMaze(4, 4)
+	msg.Exit = Point{4, 1}
+	m, err := MazeFromMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Validate()
+	if err == nil || !strings.Contains(err.Error(), "exit (4,1) is out of bounds") {
+		t.Errorf("Validate() = %v, want the out of bounds exit", err)
+	}
+}
+
+func TestValidateRejectsDisconnectedGrid(t *testing.T) {
+	setFor(t, &RequireConnected, true)
+	// A wall column at x=2 cuts the room in two, the exit is on the far side
+	msg := openMaze(5, 3)
+	for y := range msg.Walls[2] {
+		msg.Walls[2][y] = true
+	}
+	m, err := MazeFromMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); !errors.Is(err, ErrUnsolvable) {
+		t.Errorf("Validate() = %v, want %v", err, ErrUnsolvable)
+	}
+
+	// With the exit moved to the entrance's side only the far cells are stranded
+	msg.Exit = Point{1, 2}
+	if m, err = MazeFromMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	err = m.Validate()
+	if err == nil || !strings.Contains(err.Error(), "(3,0) is not reachable") {
+		t.Errorf("Validate() = %v, want cell (3,0) reported unreachable", err)
+	}
+
+	setFor(t, &RequireConnected, false)
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate() without RequireConnected = %v, want nil", err)
+	}
+}
+
+func TestValidateAcceptsGeneratedMaze(t *testing.T) {
+	m, err := NewMazeWithSeed(11, 7, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("generated maze fails Validate: %v", err)
+	}
+}
+
+func TestNewMazeWithSeedRejectsTinySizes(t *testing.T) {
+	for _, size := range [][2]int{{1, 1}, {0, 5}, {5, -1}} {
+		if m, err := NewMazeWithSeed(size[0], size[1], 1); err == nil {
+			t.Errorf("NewMazeWithSeed(%d, %d) = %dx%d maze, want an error", size[0], size[1], m.Width, m.Height)
+		}
+	}
+}
+
+func TestNewMazeWithSeedRepeats(t *testing.T) {
+	seeded := func(seed int64) *Maze {
+		m, err := NewMazeWithSeed(21, 15, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	first, second := seeded(7), seeded(7)
+	if !reflect.DeepEqual(first.cells, second.cells) || first.Exit != second.Exit {
+		t.Error("the same seed generated different mazes")
+	}
+	if other := seeded(8); reflect.DeepEqual(other.cells, first.cells) {
+		t.Error("different seeds generated the same maze")
+	}
+}
+
+func TestDifficultyScore(t *testing.T) {
+	field, err := MazeFromMessage(openMaze(15, 15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	easy := field.DifficultyScore()
+	if easy > 0.05 {
+		t.Errorf("open field scores %.3f, want near 0", easy)
+	}
+
+	twisty, err := NewMazeWithSeed(31, 31, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hard := twisty.DifficultyScore()
+	if hard <= easy+0.3 || hard > 1 {
+		t.Errorf("generated maze scores %.3f against %.3f for an open field, want clearly higher and at most 1", hard, easy)
+	}
+
+	msg := openMaze(5, 3)
+	for y := range msg.Walls[2] {
+		msg.Walls[2][y] = true
+	}
+	blocked, err := MazeFromMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := blocked.DifficultyScore(); got != 1 {
+		t.Errorf("unsolvable maze scores %.3f, want 1", got)
+	}
+}
+
+// templateGenerator hands out fixed layouts in turn, the last one repeating
+type templateGenerator struct {
+	layouts []MazeMessage
+	calls   *int
+}
+
+func (g templateGenerator) Generate(width, height int, rng *rand.Rand) [][]bool {
+	layout := g.layouts[min(*g.calls, len(g.layouts)-1)]
+	*g.calls++
+	cells := make([][]bool, len(layout.Walls))
+	for x := range cells {
+		cells[x] = append([]bool(nil), layout.Walls[x]...)
+	}
+	return cells
+}
+
+func (g templateGenerator) Endpoints(cells [][]bool) (Point, Point) {
+	return Point{0, 0}, Point{len(cells) - 1, len(cells[0]) - 1}
+}
+
+// walledExit is an open 4x4 room whose bottom right corner is boxed in
+func walledExit() MazeMessage {
+	msg := openMaze(4, 4)
+	msg.Walls[2][3], msg.Walls[3][2], msg.Walls[2][2] = true, true, true
+	return msg
+}
+
+func TestGenerateSolvableReportsWalledOffExit(t *testing.T) {
+	setFor(t, &MaxGenerateAttempts, 3)
+	calls := 0
+	m := NewMaze(4, 4)
+	err := m.GenerateSolvableWith(templateGenerator{layouts: []MazeMessage{walledExit()}, calls: &calls}, rand.New(rand.NewSource(1)))
+	if !errors.Is(err, ErrUnsolvable) {
+		t.Errorf("GenerateSolvableWith() = %v, want %v", err, ErrUnsolvable)
+	}
+	if calls != MaxGenerateAttempts {
+		t.Errorf("generated %d times, want %d attempts", calls, MaxGenerateAttempts)
+	}
+
+	loaded, err := MazeFromMessage(walledExit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Validate(); !errors.Is(err, ErrUnsolvable) {
+		t.Errorf("loaded template Validate() = %v, want %v", err, ErrUnsolvable)
+	}
+}
+
+func TestGenerateSolvableRegenerates(t *testing.T) {
+	calls := 0
+	m := NewMaze(4, 4)
+	g := templateGenerator{layouts: []MazeMessage{walledExit(), openMaze(4, 4)}, calls: &calls}
+	if err := m.GenerateSolvableWith(g, rand.New(rand.NewSource(1))); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || !m.Solvable() {
+		t.Errorf("after %d attempts solvable %v, want a solvable maze on the second", calls, m.Solvable())
+	}
+}
+
+// sameRegion reports whether a and b agree on the top-left width x height cells
+func sameRegion(a, b [][]bool, width, height int) bool {
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			if a[x][y] != b[x][y] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestResizeGrowKeepsCells(t *testing.T) {
+	for _, fill := range []bool{true, false} {
+		setFor(t, &ResizeFillWalls, fill)
+		m, err := NewMazeWithSeed(7, 5, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := m.Walls()
+		if err := m.Resize(10, 8); err != nil {
+			t.Fatalf("fill walls %v: %v", fill, err)
+		}
+		after := m.Walls()
+		if m.Width != 10 || m.Height != 8 || !sameRegion(before, after, 7, 5) {
+			t.Errorf("fill walls %v: grown maze %dx%d lost its original cells", fill, m.Width, m.Height)
+		}
+		for x := 0; x < 10; x++ {
+			for y := 0; y < 8; y++ {
+				if (x >= 7 || y >= 5) && after[x][y] != fill {
+					t.Fatalf("fill walls %v: new cell (%d,%d) is wall %v", fill, x, y, after[x][y])
+				}
+			}
+		}
+	}
+}
+
+func TestResizeShrink(t *testing.T) {
+	msg := openMaze(8, 8)
+	msg.Exit = Point{3, 2}
+	msg.Walls[5][5] = true
+	m, err := MazeFromMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := m.Walls()
+	if err := m.Resize(4, 3); err != nil {
+		t.Fatal(err)
+	}
+	if m.Width != 4 || m.Height != 3 || !sameRegion(before, m.Walls(), 4, 3) {
+		t.Errorf("shrunk maze %dx%d does not match the original's corner", m.Width, m.Height)
+	}
+
+	// Cutting off the exit is refused and leaves the maze alone
+	err = m.Resize(3, 3)
+	if err == nil || !strings.Contains(err.Error(), "removes the exit") {
+		t.Errorf("Resize(3, 3) = %v, want the exit reported", err)
+	}
+	if m.Width != 4 || m.Height != 3 {
+		t.Errorf("refused resize changed the maze to %dx%d", m.Width, m.Height)
+	}
+	if err := m.Resize(0, 3); err == nil {
+		t.Error("Resize(0, 3) accepted")
+	}
+}
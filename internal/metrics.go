@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// lobbyMetrics are always counted, RegisterMetrics only exposes them
+type lobbyMetrics struct {
+	registrations       prometheus.Counter
+	reconnections       prometheus.Counter
+	inactiveDisconnects prometheus.Counter
+	sensorFramesSent    prometheus.Counter
+	timeoutSignalsSent  prometheus.Counter
+	movesRejected       *prometheus.CounterVec
+	websocketErrors     *prometheus.CounterVec
+	authFailures        *prometheus.CounterVec
+	updateDuration      prometheus.Histogram
+	deadConnections     prometheus.Counter
+	sensorFramesDropped prometheus.Counter
+}
+
+// discordFailures is shared by every lobby since they share the bot
+var discordFailures = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "octapod",
+	Name:      "discord_failures_total",
+	Help:      "Discord messages that failed to send.",
+})
+
+var discordDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: "octapod",
+	Name:      "discord_dropped_total",
+	Help:      "Discord messages dropped because the queue was full or every retry failed.",
+})
+
+func newLobbyMetrics() *lobbyMetrics {
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Namespace: "octapod", Name: name, Help: help})
+	}
+	counterVec := func(name, help, label string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{Namespace: "octapod", Name: name, Help: help}, []string{label})
+	}
+	return &lobbyMetrics{
+		registrations:       counter("registrations_total", "Octapods registered for the first time."),
+		reconnections:       counter("reconnections_total", "Octapods that reconnected or took over their session."),
+		inactiveDisconnects: counter("inactivity_disconnects_total", "Octapods disconnected for inactivity."),
+		sensorFramesSent:    counter("sensor_frames_sent_total", "Sensor frames handed to octapods."),
+		timeoutSignalsSent:  counter("timeout_signals_sent_total", "Timeout signals handed to octapods."),
+		sensorFramesDropped: counter("sensor_frames_dropped_total", "Queued sensor frames dropped unsent for a newer one."),
+		deadConnections:     counter("dead_connections_reaped_total", "Connections closed after PongWait without a message or pong."),
+		movesRejected:       counterVec("moves_rejected_total", "Moves refused: into a wall or an occupied cell, or over the rate limit.", "reason"),
+		websocketErrors:     counterVec("websocket_errors_total", "Failed upgrades, reads and writes on octapod connections.", "op"),
+		authFailures:        counterVec("auth_failures_total", "Joins refused during authentication.", "reason"),
+		updateDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "octapod",
+			Name:      "update_duration_seconds",
+			Help:      "Time taken by each update tick.",
+			Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}),
+	}
+}
+
+// RegisterMetrics returns a handler serving the lobby's metrics in the Prometheus
+// text format. Each lobby uses its own registry so several can be served side by side.
+func RegisterMetrics(l *Lobby) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "octapod",
+			Name:      "connected",
+			Help:      "Octapods with an open connection.",
+		}, func() float64 { return float64(l.ConnectedCount()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "octapod",
+			Name:      "update_interval_seconds",
+			Help:      "Configured time between updates, compare with update_duration_seconds.",
+		}, func() float64 { return l.UpdateInterval().Seconds() }),
+		l.metrics.registrations,
+		l.metrics.reconnections,
+		l.metrics.inactiveDisconnects,
+		l.metrics.sensorFramesSent,
+		l.metrics.timeoutSignalsSent,
+		l.metrics.movesRejected,
+		l.metrics.websocketErrors,
+		l.metrics.authFailures,
+		l.metrics.updateDuration,
+		l.metrics.deadConnections,
+		l.metrics.sensorFramesDropped,
+		discordFailures,
+		discordDropped,
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// HandleMetrics serves the lobby's metrics, registering them on first use
+func (l *Lobby) HandleMetrics(c *gin.Context) {
+	l.metricsOnce.Do(func() { l.metricsPage = RegisterMetrics(l) })
+	l.metricsPage.ServeHTTP(c.Writer, c.Request)
+}
+
+// countReadError counts read failures other than the peer closing cleanly
+func (m *lobbyMetrics) countReadError(err error) {
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return
+	}
+	m.websocketErrors.WithLabelValues("read").Inc()
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape reads the unlabelled samples from a metrics page
+func scrape(t *testing.T, page http.Handler) map[string]float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	page.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	samples := map[string]float64{}
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || strings.HasPrefix(name, "#") || strings.Contains(name, "{") {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("sample %q: %v", scanner.Text(), err)
+		}
+		samples[name] = v
+	}
+	return samples
+}
+
+func assertMetrics(t *testing.T, page http.Handler, want map[string]float64) {
+	t.Helper()
+	samples := scrape(t, page)
+	for name, v := range want {
+		if samples["octapod_"+name] != v {
+			t.Errorf("octapod_%s = %v, want %v", name, samples["octapod_"+name], v)
+		}
+	}
+}
+
+func TestMetricsFollowPodLifecycle(t *testing.T) {
+	setFor(t, &MaxInactive, 1)
+	setFor(t, &JoinGraceTicks, 0)
+	l := newLobby(7, 7, 1, nil)
+	t.Cleanup(l.Shutdown)
+	page := RegisterMetrics(l)
+	auth := AuthMessage{ID: "counted", Password: "secret", Version: 2}
+	assertMetrics(t, page, map[string]float64{"connected": 0, "registrations_total": 0})
+
+	conn := dialPod(t, l, auth)
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	l.Update()
+	var ping PingMessage
+	readEnvelope(t, conn, SensorMessageType, &ping)
+	l.TimeoutUpdate()
+	assertMetrics(t, page, map[string]float64{
+		"connected":                  1,
+		"registrations_total":        1,
+		"reconnections_total":        0,
+		"sensor_frames_sent_total":   1,
+		"timeout_signals_sent_total": 1,
+	})
+
+	conn.Close()
+	within(t, 5*time.Second, func() {
+		for l.ConnectedCount() != 0 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	assertMetrics(t, page, map[string]float64{"connected": 0})
+
+	conn = dialPod(t, l, auth)
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	assertMetrics(t, page, map[string]float64{"connected": 1, "registrations_total": 1, "reconnections_total": 1})
+
+	// The pod never answers, so it is dropped for inactivity
+	within(t, 5*time.Second, func() {
+		for l.ConnectedCount() != 0 {
+			l.Update()
+		}
+	})
+	assertMetrics(t, page, map[string]float64{"connected": 0, "inactivity_disconnects_total": 1})
+}
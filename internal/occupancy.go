@@ -0,0 +1,129 @@
+package internal
+
+import "github.com/quartercastle/vector"
+
+// The occupancy map has its own mutex instead of l.Mutex: moves are applied while
+// holding the pod lock, and l.Mutex must never be taken after a pod lock.
+
+// sharedCell reports cells every pod may stand on, pods spawn on the entrance
+func sharedCell(m *Maze, p Point) bool {
+	return p == m.Entrance || (ExitIgnoresCollision && m.IsExit(p))
+}
+
+// occupy moves the pod's claim from one cell to another. It reports false, leaving
+// the map unchanged, when another pod holds the target cell.
+func (l *Lobby) occupy(o *Octapod, from, to Point) bool {
+	if Collisions == CollisionStack {
+		return true
+	}
+	l.occupyMutex.Lock()
+	defer l.occupyMutex.Unlock()
+	if !sharedCell(o.Maze, to) {
+		if holder, taken := l.occupied[to]; taken && holder != o {
+			return false
+		}
+		l.occupied[to] = o
+	}
+	if from != to && l.occupied[from] == o {
+		delete(l.occupied, from)
+	}
+	return true
+}
+
+// vacate frees the pod's cell, e.g. when it disconnects or finishes
+func (l *Lobby) vacate(o *Octapod, p Point) {
+	l.occupyMutex.Lock()
+	defer l.occupyMutex.Unlock()
+	if l.occupied[p] == o {
+		delete(l.occupied, p)
+	}
+}
+
+// takeCell moves the pod's claim like occupy, but takes the target from whichever
+// pod holds it and returns that pod
+func (l *Lobby) takeCell(o *Octapod, from, to Point) (holder *Octapod) {
+	l.occupyMutex.Lock()
+	defer l.occupyMutex.Unlock()
+	if !sharedCell(o.Maze, to) {
+		if previous, taken := l.occupied[to]; taken && previous != o {
+			holder = previous
+		}
+		l.occupied[to] = o
+	}
+	if from != to && l.occupied[from] == o {
+		delete(l.occupied, from)
+	}
+	return holder
+}
+
+// resolveTag settles a tag-mode collision: of the two pods sharing a cell, the one
+// that took more steps to get there goes back to the entrance, the holder on a tie.
+// Pod locks are taken one at a time, either pod may have moved on in between.
+func (l *Lobby) resolveTag(mover, holder *Octapod) {
+	cell, moverSteps, ok := mover.standing()
+	if !ok {
+		return
+	}
+	holderCell, holderSteps, ok := holder.standing()
+	if !ok || holderCell != cell {
+		return
+	}
+	winner, loser := mover, holder
+	if moverSteps > holderSteps {
+		winner, loser = holder, mover
+	}
+	if !loser.sendToEntrance(cell) {
+		return
+	}
+	winner.Mutex.Lock()
+	if pointOf(winner.Position) == cell {
+		l.occupy(winner, cell, cell)
+	}
+	winner.Mutex.Unlock()
+
+	loser.logger().Info("Octapod tagged back to the entrance", "by", winner.Id)
+	l.DiscordBot.SendMessage("Octapod [" + displayId(winner.Id) + "] tagged [" + displayId(loser.Id) + "] back to the entrance!")
+}
+
+// standing reports where a connected, unfinished pod is and how many steps it took
+func (o *Octapod) standing() (cell Point, steps int, ok bool) {
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	return pointOf(o.Position), o.Steps, o.connected() && !o.Finished
+}
+
+// sendToEntrance moves the pod from cell back to the entrance, unless it has left the cell
+func (o *Octapod) sendToEntrance(cell Point) bool {
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	if o.Finished || pointOf(o.Position) != cell {
+		return false
+	}
+	o.moveToEntrance(cell)
+	return true
+}
+
+// moveToEntrance puts the pod from cell back on the entrance, must hold o.Mutex
+func (o *Octapod) moveToEntrance(cell Point) {
+	entrance := o.Maze.Entrance
+	o.lobby.occupy(o, cell, entrance)
+	o.Position = vector.Vector{float64(entrance.X), float64(entrance.Y)}
+	o.visit(o.Position)
+	o.record()
+	o.recordMatch(MatchMove, nil)
+	o.lobby.publishMove(o)
+}
+
+// reclaimCell claims the pod's cell again when it reconnects. Another pod may have
+// moved in while it was away, then it restarts from the entrance. Must hold o.Mutex.
+func (o *Octapod) reclaimCell() {
+	if o.Finished {
+		return
+	}
+	cell := pointOf(o.Position)
+	if o.lobby.occupy(o, cell, cell) {
+		return
+	}
+	o.moveToEntrance(cell)
+	o.logger().Info("Octapod's cell was taken while it was away, back to the entrance", "cell", cell)
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/quartercastle/vector"
+)
+
+// placeAt moves a pod straight onto cell, claiming it like a move would
+func placeAt(t *testing.T, l *Lobby, o *Octapod, cell Point) {
+	t.Helper()
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	if !l.occupy(o, pointOf(o.Position), cell) {
+		t.Fatalf("%s could not take %v", o.Id, cell)
+	}
+	o.Position = vector.Vector{float64(cell.X), float64(cell.Y)}
+}
+
+func TestBlockCollisions(t *testing.T) {
+	setFor(t, &Collisions, CollisionBlock)
+	l := newTestLobby(t, 9, 9, 1)
+	a, b := addPod(t, l, "a"), addPod(t, l, "b")
+	cell := Point{1, 1}
+	placeAt(t, l, a, cell)
+	if l.occupy(b, l.Maze.Entrance, cell) {
+		t.Fatal("b moved into a's cell")
+	}
+	if !l.occupy(b, l.Maze.Entrance, l.Maze.Entrance) {
+		t.Error("the entrance must stay shared")
+	}
+}
+
+func TestStackCollisions(t *testing.T) {
+	setFor(t, &Collisions, CollisionStack)
+	l := newTestLobby(t, 9, 9, 1)
+	a, b := addPod(t, l, "a"), addPod(t, l, "b")
+	placeAt(t, l, a, Point{1, 1})
+	if !l.occupy(b, l.Maze.Entrance, Point{1, 1}) {
+		t.Error("stacking refused")
+	}
+}
+
+func TestForfeitFreesCell(t *testing.T) {
+	setFor(t, &Collisions, CollisionBlock)
+	l := newTestLobby(t, 9, 9, 1)
+	a, b := addPod(t, l, "a"), addPod(t, l, "b")
+	cell := Point{1, 1}
+	placeAt(t, l, a, cell)
+	a.forfeit()
+	if !l.occupy(b, l.Maze.Entrance, cell) {
+		t.Error("a forfeited pod still blocks its cell")
+	}
+}
+
+func TestReconnectIntoTakenCell(t *testing.T) {
+	setFor(t, &Collisions, CollisionBlock)
+	l := newTestLobby(t, 9, 9, 1)
+	a, b := addPod(t, l, "a"), addPod(t, l, "b")
+	cell := Point{1, 1}
+	placeAt(t, l, a, cell)
+	a.Disconnect()
+	placeAt(t, l, b, cell)
+
+	addPod(t, l, "a")
+	a.Mutex.Lock()
+	position := pointOf(a.Position)
+	a.Mutex.Unlock()
+	if position != l.Maze.Entrance {
+		t.Errorf("a reconnected at %v, want the entrance %v", position, l.Maze.Entrance)
+	}
+	if holder := l.occupied[cell]; holder != b {
+		t.Errorf("%v is held by %v, want b", cell, holder)
+	}
+
+	// A pod whose cell stayed free keeps it
+	b.Disconnect()
+	addPod(t, l, "b")
+	if holder := l.occupied[cell]; holder != b {
+		t.Errorf("b lost its free cell on reconnect, held by %v", holder)
+	}
+}
+
+func TestSimultaneousMovesIntoOneCell(t *testing.T) {
+	for _, tc := range []struct {
+		policy CollisionPolicy
+		wantB  Point
+	}{
+		{CollisionBlock, Point{2, 2}}, // a goes first in ID order and takes the cell
+		{CollisionStack, Point{2, 1}},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			setFor(t, &Collisions, tc.policy)
+			setFor(t, &MoveResolution, ResolveLastWins)
+			setFor(t, &MaxInactive, 1000)
+			l := openRoomLobby(t)
+			b, a := addPod(t, l, "b"), addPod(t, l, "a")
+			placeAt(t, l, a, Point{2, 0})
+			placeAt(t, l, b, Point{2, 2})
+			b.move(Up)
+			a.move(Down)
+			l.Update()
+
+			if got := a.State().Position; got != (Point{2, 1}) {
+				t.Errorf("a at %v, want %v", got, Point{2, 1})
+			}
+			state := b.State()
+			if state.Position != tc.wantB {
+				t.Errorf("b at %v, want %v", state.Position, tc.wantB)
+			}
+			if state.IllegalMoves != 0 {
+				t.Errorf("blocked move counted as illegal")
+			}
+		})
+	}
+}
+
+func TestExitIgnoresCollision(t *testing.T) {
+	for _, ignore := range []bool{true, false} {
+		setFor(t, &Collisions, CollisionBlock)
+		setFor(t, &ExitIgnoresCollision, ignore)
+		setFor(t, &MaxInactive, 1000)
+		l := openRoomLobby(t)
+		sitter, runner := addPod(t, l, "sitter"), addPod(t, l, "runner")
+		placeAt(t, l, sitter, l.Maze.Exit)
+		placeAt(t, l, runner, Point{l.Maze.Exit.X - 1, l.Maze.Exit.Y})
+		runner.move(Right)
+		l.Update()
+
+		if finished := runner.State().Finished; finished != ignore {
+			t.Errorf("ExitIgnoresCollision %v: runner finished %v", ignore, finished)
+		}
+	}
+}
@@ -1,36 +1,199 @@
 package internal
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 	"github.com/quartercastle/vector"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var SensorTrail = false
+var TrailLength = 8
+var MaxIllegalMoves = 0 // 0 disables the limit
+var ResetIllegalMovesOnLegal = true
+
+type Resolution string
+
+// How moves received between ticks are applied
+const (
+	ResolveImmediately Resolution = "immediate"  // Every move is applied on receipt
+	ResolveLastWins    Resolution = "last-wins"  // Only the last move before a tick is applied
+	ResolveFirstWins   Resolution = "first-wins" // Only the first move before a tick is applied
+	ResolveQueue       Resolution = "queue"      // One queued move is applied per tick
+)
+
+var MoveResolution = ResolveImmediately
+
+type CollisionPolicy string
+
+const (
+	CollisionStack CollisionPolicy = "stack" // Pods share cells freely
+	CollisionBlock CollisionPolicy = "block" // A move into a held cell is refused and the pod stays put
+	CollisionTag   CollisionPolicy = "tag"   // The pod that took more steps to reach the cell goes back to the entrance
+)
+
+// Collisions decides what happens when a pod moves onto another connected pod.
+// Tick-resolved moves are applied in ID order.
+var Collisions = CollisionBlock
+
+// ExitIgnoresCollision keeps the exit enterable even when another pod sits on it
+var ExitIgnoresCollision = true
+
+// SensorQueueSize bounds the sensors waiting for a pod's write pump. When it is
+// full the oldest is dropped, so a stuck pod only ever misses its own ticks.
+var SensorQueueSize = 1
+var MaxQueuedMoves = 16
+
+// JoinGraceTicks forgives a pod's first missed responses after it joins or reconnects
+var JoinGraceTicks = 1
+
 type Octapod struct {
-	Id             string
-	Conn           *websocket.Conn
-	Position       vector.Vector
-	InactiveCount  int
-	HashedPassword string
-	Sensor         chan *Sensor
-	Mutex          sync.Mutex
-	Maze           *Maze
-}
-
-func NewOctapod(id, password string, conn *websocket.Conn, maze *Maze) *Octapod {
-	h := hashPassword(password)
-	return &Octapod{
+	Id              string
+	Tag             string // 1-2 characters marking the pod on rendered boards
+	Team            string // Optional, teammates share what they see
+	Conn            *websocket.Conn
+	Position        vector.Vector
+	InactiveCount   int
+	IllegalMoves    int
+	Steps           int
+	Score           int // Points from pickups
+	JoinedAt        time.Time
+	FinishTicks     int64 // Ticks from joining to reaching the exit
+	FinishedAt      time.Time
+	Disconnects     int
+	TickMultiplier  int
+	Finished        bool
+	DNF             bool // Finished by forfeiting instead of reaching the exit
+	HashedPassword  string
+	Sensor          chan *Sensor
+	Mutex           sync.Mutex
+	writeMutex      sync.Mutex
+	Maze            *Maze
+	lobby           *Lobby
+	trail           []Point
+	pendingMoves    []Move
+	discovered      map[Point]bool // Cells revealed to the pod by its sensors
+	awaitingMove    bool           // A sensor was pushed and no move has arrived since
+	polling         bool           // The session is held over the HTTP API instead of a WebSocket
+	team            *team
+	teamCursor      int // Team cells already sent to the pod
+	rangeBoostUntil int64
+	joinedTick      int64
+	history         []ReplayStep
+	graceTicks      int
+	commandLog      atomic.Pointer[podLog]
+	goodbyeAck      chan struct{}
+	connId          atomic.Uint64 // Numbers the current connection in log lines
+	protocol        atomic.Int32  // Negotiated protocol version of the current connection
+	lastSeen        atomic.Int64  // Unix nanoseconds of the last message or pong, see PongWait
+	droppedTicks    atomic.Int64  // Sensors dropped unsent because the queue was full
+	pingSent        atomic.Int64  // Unix nanoseconds of the unanswered ping, 0 once answered
+	rtt             atomic.Int64  // Nanoseconds between the last answered ping and its pong
+}
+
+func NewOctapod(id, password string, conn *websocket.Conn, lobby *Lobby) *Octapod {
+	return newOctapod(id, hashPassword(password), conn, lobby)
+}
+
+func newOctapod(id, hashedPassword string, conn *websocket.Conn, lobby *Lobby) *Octapod {
+	o := &Octapod{
 		Id:             id,
-		HashedPassword: h,
+		HashedPassword: hashedPassword,
 		Conn:           conn,
 		Position:       vector.Vector{0, 0},
-		Sensor:         make(chan *Sensor),
-		Maze:           maze,
+		TickMultiplier: 1,
+		JoinedAt:       time.Now(),
+		graceTicks:     JoinGraceTicks,
+		Sensor:         make(chan *Sensor, max(SensorQueueSize, 1)),
+		goodbyeAck:     make(chan struct{}, 1),
+		Maze:           lobby.Maze,
+		lobby:          lobby,
+		discovered:     make(map[Point]bool),
+	}
+	o.visit(o.Position)
+	o.record()
+	return o
+}
+
+func (o *Octapod) State() PodState {
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	return o.state()
+}
+
+// state must hold o.Mutex
+func (o *Octapod) state() PodState {
+	return PodState{
+		Id:             o.Id,
+		Tag:            o.Tag,
+		Team:           o.Team,
+		Position:       pointOf(o.Position),
+		Connected:      o.connected(),
+		Steps:          o.Steps,
+		Score:          o.Score,
+		IllegalMoves:   o.IllegalMoves,
+		InactiveCount:  o.InactiveCount,
+		TickMultiplier: o.TickMultiplier,
+		Discovered:     len(o.discovered),
+		Finished:       o.Finished,
+		DNF:            o.DNF,
+		FinishTicks:    o.FinishTicks,
+		JoinedAt:       o.JoinedAt,
+		FinishedAt:     finishedAt(o.FinishedAt),
+		Disconnects:    o.Disconnects,
+		DroppedTicks:   o.droppedTicks.Load(),
+		RTTMs:          float64(o.rtt.Load()) / float64(time.Millisecond),
+	}
+}
+
+func finishedAt(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// renderTag pads a tag to the two-character cells used by text boards
+func renderTag(tag string) string {
+	if utf8.RuneCountInString(tag) == 1 {
+		return tag + " "
+	}
+	return tag
+}
+
+func defaultTag(id string) string {
+	r, _ := utf8.DecodeRuneInString(id)
+	if r == utf8.RuneError {
+		return "?"
 	}
+	return string(r)
+}
+
+func validTag(tag string) bool {
+	if n := utf8.RuneCountInString(tag); n < 1 || n > 2 {
+		return false
+	}
+	for _, r := range tag {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// connected reports whether a client holds the session, over a WebSocket or the
+// HTTP API. Must hold o.Mutex.
+func (o *Octapod) connected() bool {
+	return o.Conn != nil || o.polling
 }
 
 func (o *Octapod) VerifyPassword(pw string) bool {
@@ -38,84 +201,527 @@ func (o *Octapod) VerifyPassword(pw string) bool {
 }
 
 func (o *Octapod) Disconnect() {
-	o.Mutex.Lock()
-	defer o.Mutex.Unlock()
-	if o.Conn != nil {
-		o.Conn.Close()
-		o.Conn = nil
-		log.Println("Octapod", o.Id, "disconnected")
+	if o.dropConn(nil, func(conn *websocket.Conn) { conn.Close() }) {
+		o.logger().Info("Octapod disconnected")
 	}
 }
 
-func (o *Octapod) Run() {
-	go o.readPump()
-	go o.writePump()
+// Kick says goodbye and closes the connection with a close code and reason the
+// client can inspect. The pod is disconnected at once, the goodbye runs in the
+// background so callers such as Update never wait up to GoodbyeTimeout on a client.
+func (o *Octapod) Kick(code int, reason string) {
+	// Drop any ack left over from an earlier connection
+	select {
+	case <-o.goodbyeAck:
+	default:
+	}
+	send := func(conn *websocket.Conn) func(any) error {
+		return func(v any) error { return o.write(conn, v) }
+	}
+	if o.dropConn(nil, func(conn *websocket.Conn) { go goodbye(conn, code, reason, send(conn), o.goodbyeAck) }) {
+		o.logger().Info("Octapod kicked", "code", code, "reason", reason)
+	}
 }
 
-func (o *Octapod) readPump() {
-	for {
-		o.Mutex.Lock()
-		conn := o.Conn
+// disconnectConn disconnects the pod only if conn is still its connection, so pumps
+// of a replaced connection can't drop the new one
+func (o *Octapod) disconnectConn(conn *websocket.Conn) {
+	if o.dropConn(conn, func(conn *websocket.Conn) { conn.Close() }) {
+		o.logger().Info("Octapod disconnected")
+	}
+}
+
+// dropConn closes and clears the connection, or ends a polling session, limited to
+// only when it is set. It reports false when already disconnected.
+func (o *Octapod) dropConn(only *websocket.Conn, closeConn func(*websocket.Conn)) bool {
+	o.Mutex.Lock()
+	if !o.connected() || (only != nil && o.Conn != only) {
 		o.Mutex.Unlock()
-		if conn == nil {
-			return
+		return false
+	}
+	conn := o.Conn
+	o.release()
+	o.Mutex.Unlock()
+	// Closing may wait on the client, so it happens outside the lock
+	if conn != nil {
+		closeConn(conn)
+	}
+	o.released()
+	return true
+}
+
+// endPoll ends an HTTP polling session, leaving a WebSocket that took it over alone
+func (o *Octapod) endPoll() bool {
+	o.Mutex.Lock()
+	if !o.polling {
+		o.Mutex.Unlock()
+		return false
+	}
+	o.release()
+	o.Mutex.Unlock()
+	o.released()
+	return true
+}
+
+// release clears the session and frees the pod's cell, must hold o.Mutex
+func (o *Octapod) release() {
+	o.Conn = nil
+	o.polling = false
+	o.Disconnects++
+	o.lobby.vacate(o, pointOf(o.Position))
+}
+
+// released finishes a disconnect once o.Mutex is no longer held
+func (o *Octapod) released() {
+	o.closeCommandLog()
+	o.lobby.expireToken(o.Id)
+	o.lobby.stats.disconnects.Add(1)
+	o.lobby.saveResult(o)
+	o.lobby.broadcastPresence(PlayerLeft, o)
+}
+
+// Send writes a JSON message to the pod if it is connected. Polling clients have
+// nothing to push to, they read their state from the HTTP API instead.
+func (o *Octapod) Send(v any) error {
+	o.Mutex.Lock()
+	conn, polling := o.Conn, o.polling
+	o.Mutex.Unlock()
+	if conn == nil && polling {
+		return nil
+	}
+	if conn == nil {
+		return errors.New("octapod " + o.Id + " is not connected")
+	}
+	return o.write(conn, v)
+}
+
+// Run starts the pumps for the pod's connection, they stop when ctx is cancelled.
+// The write pump also stops once the read pump saw the connection end.
+func (o *Octapod) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	go o.guard(func() {
+		defer cancel()
+		o.readPump(ctx)
+	})
+	go o.guard(func() { o.writePump(ctx) })
+}
+
+// guard keeps a panic in one pod's pump from taking down the server, the pod is
+// disconnected and removed from the lobby instead
+func (o *Octapod) guard(pump func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			o.logger().Error("Octapod panicked", "panic", r)
+			o.Kick(websocket.CloseInternalServerErr, "Internal server error")
+			o.lobby.removeOctapod(o)
 		}
+	}()
+	pump()
+}
 
+// readPump serves the connection the pod had when it started and ends with it
+func (o *Octapod) readPump(ctx context.Context) {
+	o.Mutex.Lock()
+	conn := o.Conn
+	o.Mutex.Unlock()
+	if conn == nil {
+		return
+	}
+	// Cancelling closes the connection, which unblocks the read below
+	stop := context.AfterFunc(ctx, func() { o.disconnectConn(conn) })
+	defer stop()
+	o.keepAlive(conn)
+
+	for {
 		typ, msg, err := conn.ReadMessage()
 		if err != nil {
-			o.Disconnect()
+			o.lobby.metrics.countReadError(err)
+			o.disconnectConn(conn)
 			return
 		}
+		o.extendDeadline(conn)
 		if typ != websocket.TextMessage {
 			continue
 		}
+		o.logCommand("recv", msg)
 
-		var move MoveMessage
-		if err := json.Unmarshal(msg, &move); err != nil {
-			log.Println("Invalid move from", o.Id, err)
+		cmd, err := decodeCommand(int(o.protocol.Load()), msg)
+		if err != nil {
+			o.logger().Warn("Invalid command", "err", err)
 			continue
 		}
 
-		log.Println("Move from [", o.Id, "] to", move.Move)
+		switch cmd.Type {
+		case MoveCommand, "":
+			if refusal, notify := o.checkMoveQuota(); refusal == nil {
+				o.move(cmd.Move)
+			} else if notify {
+				if err := o.Send(*refusal); err != nil {
+					o.logger().Warn("Error sending rate limit error", "err", err)
+				}
+			}
+		case ForfeitCommand:
+			o.forfeit()
+		case TickCommand:
+			o.sendTick()
+		case ExitsCommand:
+			o.sendExits()
+		case GoodbyeCommand:
+			select {
+			case o.goodbyeAck <- struct{}{}:
+			default:
+			}
+		default:
+			o.logger().Warn("Unknown command", "type", cmd.Type)
+		}
+	}
+}
+
+// checkMoveQuota applies the lobby's move rate limit. A refused move comes with the
+// error for the pod, notify is false when the pod was already told.
+func (o *Octapod) checkMoveQuota() (refusal *ErrorMessage, notify bool) {
+	o.Mutex.Lock()
+	multiplier := o.TickMultiplier
+	o.Mutex.Unlock()
+
+	code, notify := o.lobby.allowMove(o.Id, multiplier)
+	if code == "" {
+		return nil, false
+	}
+	o.lobby.metrics.movesRejected.WithLabelValues(code).Inc()
+	message := "Too many moves, at most " + strconv.Itoa(MovesPerTick) + " per sensor tick"
+	if code == SuspendedError {
+		message = "Moves ignored for " + SuspensionDuration.String() + " after repeatedly exceeding the move limit"
+		if notify {
+			o.logger().Warn("Octapod suspended for exceeding the move limit")
+			o.lobby.DiscordBot.SendMessage("Octapod [" + displayId(o.Id) + "] suspended for " + SuspensionDuration.String() + " for spamming moves")
+		}
+	}
+	return &ErrorMessage{Error: message, Code: code}, notify
+}
+
+func (o *Octapod) sendTick() {
+	err := o.Send(TickMessage{
+		Type:           TickCommand,
+		Tick:           o.lobby.tick.Load(),
+		UpdateInterval: o.lobby.UpdateInterval().Milliseconds(),
+	})
+	if err != nil {
+		o.logger().Error("Error sending tick", "err", err)
+	}
+}
 
+func (o *Octapod) sendExits() {
+	var err error
+	if !ExitBearings {
+		err = o.Send(ErrorMessage{Error: "Exit bearings are disabled in this lobby", Code: DisabledError})
+	} else {
 		o.Mutex.Lock()
-		newPos := o.Position.Add(move.Move.ToVector())
-		if o.Maze.IsAvailable(newPos) {
-			o.Position = newPos
+		maze, position := o.Maze, pointOf(o.Position)
+		o.Mutex.Unlock()
+		err = o.Send(ExitsMessage{Type: ExitsCommand, Exits: maze.ExitBearings(position)})
+	}
+	if err != nil {
+		o.logger().Error("Error sending exits", "err", err)
+	}
+}
+
+func (o *Octapod) move(move Move) {
+	o.logger().Debug("Move received", "move", move)
+
+	// Outside a running round the board is frozen
+	if o.lobby.Phase() != PhaseRunning {
+		return
+	}
+	tooManyIllegal, collided := o.takeMove(move)
+	if collided != nil {
+		o.lobby.resolveTag(o, collided)
+	}
+	o.lobby.flushEvents()
+	if tooManyIllegal {
+		o.kickForIllegalMoves()
+	}
+}
+
+// takeMove applies the move or buffers it for the next tick. The deferred unlock lets
+// guard kick the pod if applying the move panics.
+func (o *Octapod) takeMove(move Move) (tooManyIllegal bool, collided *Octapod) {
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	if o.Finished {
+		return false, nil
+	}
+	o.InactiveCount = 0
+	o.awaitingMove = false
+	if MoveResolution != ResolveImmediately {
+		if len(o.pendingMoves) < MaxQueuedMoves {
+			o.pendingMoves = append(o.pendingMoves, move)
 		}
-		o.InactiveCount = 0
+		return false, nil
+	}
+	return o.applyMove(move)
+}
+
+// settleResponse counts the previous sensor as missed if no move answered it, must
+// hold o.Mutex. Returns true once the pod has reached MaxInactive.
+func (o *Octapod) settleResponse() (inactive bool) {
+	if o.awaitingMove {
+		if o.graceTicks > 0 {
+			o.graceTicks--
+		} else {
+			o.InactiveCount++
+		}
+	}
+	return o.InactiveCount >= MaxInactive
+}
+
+// applyPendingMove applies moves buffered since the last tick, must hold o.Mutex
+func (o *Octapod) applyPendingMove() (tooManyIllegal bool, collided *Octapod) {
+	if len(o.pendingMoves) == 0 {
+		return false, nil
+	}
+	var move Move
+	switch MoveResolution {
+	case ResolveFirstWins:
+		move = o.pendingMoves[0]
+		o.pendingMoves = o.pendingMoves[:0]
+	case ResolveQueue:
+		move = o.pendingMoves[0]
+		o.pendingMoves = o.pendingMoves[1:]
+	default:
+		move = o.pendingMoves[len(o.pendingMoves)-1]
+		o.pendingMoves = o.pendingMoves[:0]
+	}
+	return o.applyMove(move)
+}
+
+// applyMove moves the pod if the target cell is open and, when blocking collisions,
+// not held by another pod. In tag mode the pod it landed on is returned, to be
+// passed to resolveTag once o.Mutex is released. Must hold o.Mutex.
+func (o *Octapod) applyMove(move Move) (tooManyIllegal bool, collided *Octapod) {
+	newPos := o.Position.Add(move.ToVector())
+	if o.Maze.IsAvailable(newPos) {
+		from, to := pointOf(o.Position), pointOf(newPos)
+		if Collisions == CollisionTag {
+			collided = o.lobby.takeCell(o, from, to)
+		} else if !o.lobby.occupy(o, from, to) {
+			o.lobby.metrics.movesRejected.WithLabelValues("occupied").Inc()
+			return false, nil
+		}
+		o.Position = newPos
+		o.Steps++
+		o.visit(newPos)
+		o.collectPickup(to)
+		o.record()
+		o.recordMatch(MatchMove, nil)
+		o.lobby.publishMove(o)
+		if ResetIllegalMovesOnLegal {
+			o.IllegalMoves = 0
+		}
+	} else {
+		o.IllegalMoves++
+		o.lobby.metrics.movesRejected.WithLabelValues("wall").Inc()
+	}
+	return MaxIllegalMoves > 0 && o.IllegalMoves > MaxIllegalMoves, collided
+}
+
+func (o *Octapod) kickForIllegalMoves() {
+	o.Kick(websocket.ClosePolicyViolation, "Too many illegal moves")
+	o.logger().Info("Octapod disconnected due to illegal moves")
+}
+
+// visit records a position in the bounded recent-visit trail, must hold o.Mutex
+func (o *Octapod) visit(position vector.Vector) {
+	o.trail = append(o.trail, pointOf(position))
+	if len(o.trail) > TrailLength {
+		o.trail = o.trail[len(o.trail)-TrailLength:]
+	}
+}
+
+// discover marks the pod's cell and its sensed neighbours as discovered, sharing
+// them with the pod's team. Must hold o.Mutex.
+func (o *Octapod) discover() {
+	p := pointOf(o.Position)
+	cells := make([]Point, 0, 5)
+	for _, cell := range []Point{p, {p.X, p.Y - 1}, {p.X + 1, p.Y}, {p.X, p.Y + 1}, {p.X - 1, p.Y}} {
+		if o.Maze.inBounds(cell) {
+			o.discovered[cell] = true
+			cells = append(cells, cell)
+		}
+	}
+	if o.team != nil {
+		o.team.share(o.Maze, cells)
+	}
+}
+
+// trailSensor reports which neighbours are in the recent-visit trail, must hold o.Mutex
+func (o *Octapod) trailSensor() *Directions {
+	p := pointOf(o.Position)
+	d := &Directions{}
+	for _, t := range o.trail {
+		switch t {
+		case Point{p.X - 1, p.Y}:
+			d.Left = true
+		case Point{p.X + 1, p.Y}:
+			d.Right = true
+		case Point{p.X, p.Y - 1}:
+			d.Up = true
+		case Point{p.X, p.Y + 1}:
+			d.Down = true
+		}
+	}
+	return d
+}
+
+// finish takes the pod off the board once it reached the exit, or as a DNF when it
+// forfeits. Must hold o.Mutex.
+func (o *Octapod) finish(tick int64, dnf bool) {
+	o.Finished = true
+	o.DNF = dnf
+	o.FinishedAt = time.Now()
+	kind := MatchForfeit
+	if !dnf {
+		o.FinishTicks = tick - o.joinedTick
+		kind = MatchFinish
+	}
+	// A forfeited pod stays connected but must not block its cell for the others
+	o.lobby.vacate(o, pointOf(o.Position))
+	o.recordMatch(kind, nil)
+}
+
+func (o *Octapod) forfeit() {
+	l := o.lobby
+	o.Mutex.Lock()
+	if o.Finished {
 		o.Mutex.Unlock()
+		return
+	}
+	o.finish(l.tick.Load(), true)
+	o.Mutex.Unlock()
+
+	o.logger().Info("Octapod forfeited")
+	l.saveResult(o)
+	if err := o.Send(ResultMessage{Finished: true, DNF: true}); err != nil {
+		o.logger().Error("Error sending result", "err", err)
 	}
+	l.notifyOthers(PlayerForfeited, o)
+	l.DiscordBot.SendMessage("Octapod [" + displayId(o.Id) + "] forfeited (DNF)")
+	l.finishRoundIfDone()
 }
 
-func (o *Octapod) writePump() {
-	for sensor := range o.Sensor {
+// deliver queues a sensor reading for the write pump without ever blocking,
+// dropping the oldest queued sensor when the queue is full. A nil timeout signal is
+// only queued when nothing is waiting, it must not push out a real sensor.
+func (o *Octapod) deliver(s *Sensor) (ok bool) {
+	if s == nil {
+		select {
+		case o.Sensor <- nil:
+			return true
+		default:
+			return false
+		}
+	}
+	// Bounded, another sender or the pump may race us for the slot
+	for attempt := 0; attempt < 3; attempt++ {
+		select {
+		case o.Sensor <- s:
+			return true
+		default:
+		}
+		select {
+		case stale := <-o.Sensor:
+			if stale != nil {
+				dropped := o.droppedTicks.Add(1)
+				o.lobby.metrics.sensorFramesDropped.Inc()
+				o.logger().Warn("Dropped an unsent sensor, the octapod is not keeping up", "dropped", dropped)
+			}
+		default:
+		}
+	}
+	return false
+}
+
+// writePump drains sensors for the connection the pod had when it started. Once the
+// connection is replaced it hands over the sensor it holds and exits.
+func (o *Octapod) writePump(ctx context.Context) {
+	o.Mutex.Lock()
+	mine := o.Conn
+	o.Mutex.Unlock()
+
+	var pings <-chan time.Time
+	if PingInterval > 0 && mine != nil {
+		ticker := time.NewTicker(PingInterval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	for {
+		var sensor *Sensor
+		select {
+		case <-ctx.Done():
+			return
+		case <-pings:
+			if err := o.ping(mine); err != nil {
+				o.disconnectConn(mine)
+				return
+			}
+			continue
+		case sensor = <-o.Sensor:
+		}
 		o.Mutex.Lock()
 		conn := o.Conn
 		pos := o.Position
+		polling := o.polling
 		o.Mutex.Unlock()
 
 		if conn == nil {
+			// Hand the sensor over to the HTTP session that took over
+			if polling && sensor != nil {
+				o.deliver(sensor)
+			}
 			return
 		}
 		if sensor == nil {
+			if conn != mine {
+				return
+			}
 			continue
 		}
 
 		msg := PingMessage{Sensor: sensor, Position: pos}
-		b, _ := json.Marshal(msg)
-		if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
-			log.Println("Write error for", o.Id, err)
-			o.Disconnect()
+		if err := o.write(conn, msg); err != nil {
+			o.logger().Warn("Write error", "err", err)
+			o.disconnectConn(conn)
+			return
+		}
+		if conn != mine {
 			return
 		}
 	}
 }
 
+// write sends a JSON message, writes are serialised so it is safe alongside the write pump
+func (o *Octapod) write(conn *websocket.Conn, v any) error {
+	b, err := encodeMessage(int(o.protocol.Load()), v)
+	if err != nil {
+		return err
+	}
+	o.writeMutex.Lock()
+	defer o.writeMutex.Unlock()
+	// A client that stops reading must not hold up the writer for good
+	conn.SetWriteDeadline(time.Now().Add(TimeoutInterval))
+	if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+		o.lobby.metrics.websocketErrors.WithLabelValues("write").Inc()
+		return err
+	}
+	o.logCommand("sent", b)
+	return nil
+}
+
 func hashPassword(pw string) string {
 	h, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
 	if err != nil {
-		log.Println("Password hash error:", err)
+		slog.Error("Password hash error", "err", err)
 		return pw
 	}
 	return string(h)
@@ -0,0 +1,404 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"gbccsclub/octopod-challenge/internal/store"
+	"github.com/gorilla/websocket"
+)
+
+func TestForfeitRecordsDNF(t *testing.T) {
+	setFor(t, &ReplayDir, t.TempDir())
+	l := newTestLobby(t, 9, 9, 1)
+	results, err := store.OpenBolt(filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer results.Close()
+	l.Store = results
+	l.openMatch()
+
+	o := addPod(t, l, "quitter")
+	watcher := dialPod(t, l, AuthMessage{ID: "watcher", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, watcher, WelcomeMessageType, &welcome)
+	l.Update()
+	o.forfeit()
+
+	var presence PresenceMessage
+	readEnvelope(t, watcher, PresenceMessageType, &presence)
+	if presence != (PresenceMessage{Type: PlayerForfeited, Id: "quitter"}) {
+		t.Errorf("watcher got %+v, want the forfeit", presence)
+	}
+
+	if state := o.State(); !state.Finished || !state.DNF {
+		t.Errorf("state finished %v dnf %v, want both", state.Finished, state.DNF)
+	}
+	board, err := results.Leaderboard(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(board) != 1 || !board[0].DNF {
+		t.Errorf("stored results %+v, want one DNF", board)
+	}
+
+	file, err := os.Open(l.match.Load().Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	events, err := LoadMatch(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := events[len(events)-1]; last.Type != MatchForfeit || last.Id != "quitter" {
+		t.Errorf("last match event %s for %s, want a forfeit", last.Type, last.Id)
+	}
+	frames := MatchFrames(events)
+	if p := frames[len(frames)-1].Pods[0]; !p.DNF {
+		t.Error("replayed pod is not a DNF")
+	}
+
+	// Forfeiting twice must not record a second result
+	o.forfeit()
+	if board, _ := results.Leaderboard(10); len(board) != 1 {
+		t.Errorf("%d stored results after a second forfeit", len(board))
+	}
+}
+
+func TestTickReportsLobbyInterval(t *testing.T) {
+	l := newTestLobby(t, 5, 5, 1)
+	l.SetUpdateInterval(250 * time.Millisecond)
+	conn := dialPod(t, l, AuthMessage{ID: "pod", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+
+	if err := conn.WriteJSON(Envelope{Type: MessageType(TickCommand)}); err != nil {
+		t.Fatal(err)
+	}
+	var tick TickMessage
+	readEnvelope(t, conn, TickMessageType, &tick)
+	if tick.UpdateInterval != 250 {
+		t.Errorf("tick reports an update interval of %dms, want the lobby's 250ms", tick.UpdateInterval)
+	}
+}
+
+func TestTickIncrementsAcrossUpdates(t *testing.T) {
+	setFor(t, &MaxInactive, 1000)
+	l := newTestLobby(t, 5, 5, 1)
+	conn := dialPod(t, l, AuthMessage{ID: "pod", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+
+	for want := int64(0); want <= 3; want++ {
+		if err := conn.WriteJSON(Envelope{Type: MessageType(TickCommand)}); err != nil {
+			t.Fatal(err)
+		}
+		var tick TickMessage
+		readEnvelope(t, conn, TickMessageType, &tick)
+		if tick.Tick != want {
+			t.Errorf("tick %d reported after %d updates", tick.Tick, want)
+		}
+		l.Update()
+	}
+}
+
+func TestInactiveKickDoesNotBlockUpdate(t *testing.T) {
+	setFor(t, &GoodbyeTimeout, 2*time.Second)
+	setFor(t, &MaxInactive, 1)
+	setFor(t, &JoinGraceTicks, 0)
+	l := newTestLobby(t, 5, 5, 1)
+	// The client never acknowledges the goodbye, so it takes the whole GoodbyeTimeout
+	conn := dialPod(t, l, AuthMessage{ID: "idle", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	l.Mutex.RLock()
+	o := l.Octapods["idle"]
+	l.Mutex.RUnlock()
+
+	for range 5 {
+		start := time.Now()
+		l.Update()
+		if took := time.Since(start); took > GoodbyeTimeout/2 {
+			t.Fatalf("Update took %s, it waited on the goodbye", took)
+		}
+		o.Mutex.Lock()
+		connected := o.connected()
+		o.Mutex.Unlock()
+		if !connected {
+			var goodbye GoodbyeMessage
+			readEnvelope(t, conn, GoodbyeMessageType, &goodbye)
+			if goodbye.Reason != "Inactive for too long" {
+				t.Errorf("goodbye reason %q", goodbye.Reason)
+			}
+			// Acknowledge and wait for the close, so the goodbye is done with the settings
+			conn.WriteJSON(Envelope{Type: MessageType(GoodbyeCommand)})
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}
+	}
+	t.Fatal("inactive pod was never kicked")
+}
+
+func TestTickMultiplierSpacesSensors(t *testing.T) {
+	setFor(t, &MaxInactive, 1000)
+	l := newTestLobby(t, 9, 9, 1)
+	every := joinPod(t, l, AuthMessage{ID: "every", Password: "secret", TickMultiplier: 1})
+	third := joinPod(t, l, AuthMessage{ID: "third", Password: "secret", TickMultiplier: 3})
+	counts := map[*Octapod]int{}
+	for range 12 {
+		l.Update()
+		for _, o := range []*Octapod{every, third} {
+			if takeSensor(o) != nil {
+				counts[o]++
+			}
+		}
+	}
+	if counts[every] != 12 || counts[third] != 4 {
+		t.Errorf("got %d and %d sensors in 12 ticks, want 12 and 4", counts[every], counts[third])
+	}
+
+	clamped := joinPod(t, l, AuthMessage{ID: "clamped", Password: "secret", TickMultiplier: MaxTickMultiplier + 5})
+	if clamped.TickMultiplier != MaxTickMultiplier {
+		t.Errorf("multiplier %d, want it clamped to %d", clamped.TickMultiplier, MaxTickMultiplier)
+	}
+}
+
+// openMove returns a move from the pod's cell onto an open cell
+func openMove(t *testing.T, o *Octapod) Move {
+	t.Helper()
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	for _, move := range []Move{Up, Down, Left, Right} {
+		if o.Maze.IsAvailable(o.Position.Add(move.ToVector())) {
+			return move
+		}
+	}
+	t.Fatalf("no open cell next to %v", o.Position)
+	return ""
+}
+
+// blockedMove returns a move from the pod's cell into a wall or off the grid
+func blockedMove(t *testing.T, o *Octapod) Move {
+	t.Helper()
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	for _, move := range []Move{Up, Down, Left, Right} {
+		if !o.Maze.IsAvailable(o.Position.Add(move.ToVector())) {
+			return move
+		}
+	}
+	t.Fatalf("no wall next to %v", o.Position)
+	return ""
+}
+
+func TestTooManyIllegalMovesDisconnects(t *testing.T) {
+	setFor(t, &MaxIllegalMoves, 2)
+	l := newTestLobby(t, 9, 9, 1)
+	o := addPod(t, l, "bumper")
+	l.Update()
+	move := blockedMove(t, o)
+
+	for i := 0; i < MaxIllegalMoves; i++ {
+		o.move(move)
+	}
+	o.Mutex.Lock()
+	connected, illegal := o.connected(), o.IllegalMoves
+	o.Mutex.Unlock()
+	if !connected || illegal != MaxIllegalMoves {
+		t.Fatalf("after %d illegal moves connected %v count %d, want still connected", MaxIllegalMoves, connected, illegal)
+	}
+
+	o.move(move)
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	if o.connected() {
+		t.Error("pod still connected past MaxIllegalMoves")
+	}
+}
+
+// openRoomLobby is a lobby on a 7x7 board without walls
+func openRoomLobby(t *testing.T) *Lobby {
+	t.Helper()
+	l := newTestLobby(t, 7, 7, 1)
+	calls := 0
+	l.Maze.GenerateWith(templateGenerator{layouts: []MazeMessage{openMaze(7, 7)}, calls: &calls}, nil)
+	return l
+}
+
+func TestMoveResolutions(t *testing.T) {
+	burst := []Move{Right, Down, Down}
+	for _, tc := range []struct {
+		resolution Resolution
+		want       []Point // Position after the burst, then after each of three ticks
+	}{
+		{ResolveImmediately, []Point{{1, 2}, {1, 2}, {1, 2}, {1, 2}}},
+		{ResolveLastWins, []Point{{0, 0}, {0, 1}, {0, 1}, {0, 1}}},
+		{ResolveFirstWins, []Point{{0, 0}, {1, 0}, {1, 0}, {1, 0}}},
+		{ResolveQueue, []Point{{0, 0}, {1, 0}, {1, 1}, {1, 2}}},
+	} {
+		t.Run(string(tc.resolution), func(t *testing.T) {
+			setFor(t, &MoveResolution, tc.resolution)
+			setFor(t, &MaxInactive, 1000)
+			l := openRoomLobby(t)
+			o := addPod(t, l, "burst")
+			if got := o.State().Position; got != (Point{0, 0}) {
+				t.Fatalf("pod spawned at %v, want the entrance", got)
+			}
+			for _, move := range burst {
+				o.move(move)
+			}
+			got := []Point{o.State().Position}
+			for range 3 {
+				l.Update()
+				got = append(got, o.State().Position)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("positions %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQueuedMovesAreCapped(t *testing.T) {
+	setFor(t, &MoveResolution, ResolveQueue)
+	setFor(t, &MaxQueuedMoves, 2)
+	setFor(t, &MaxInactive, 1000)
+	l := openRoomLobby(t)
+	o := addPod(t, l, "flood")
+	for range 5 {
+		o.move(Right)
+	}
+	for range 5 {
+		l.Update()
+	}
+	if got := o.State().Position; got != (Point{2, 0}) {
+		t.Errorf("pod at %v after a flood of moves, want %v with the queue capped at 2", got, Point{2, 0})
+	}
+}
+
+// podNamed returns the lobby's pod with the given ID
+func podNamed(t *testing.T, l *Lobby, id string) *Octapod {
+	t.Helper()
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	o, ok := l.Octapods[id]
+	if !ok {
+		t.Fatalf("no octapod %s", id)
+	}
+	return o
+}
+
+func TestPanicEndsOnlyThatPod(t *testing.T) {
+	l := openRoomLobby(t)
+	var welcome WelcomeMessage
+	crash := dialPod(t, l, AuthMessage{ID: "crash", Password: "secret", Version: 2})
+	readEnvelope(t, crash, WelcomeMessageType, &welcome)
+	steady := dialPod(t, l, AuthMessage{ID: "steady", Password: "secret", Version: 2})
+	readEnvelope(t, steady, WelcomeMessageType, &welcome)
+
+	// A move on a pod without a maze dereferences nil in the read pump
+	broken := podNamed(t, l, "crash")
+	broken.Mutex.Lock()
+	broken.Maze = nil
+	broken.Mutex.Unlock()
+	if err := crash.WriteJSON(CommandMessage{Type: MoveCommand, Move: Right}); err != nil {
+		t.Fatal(err)
+	}
+	var bye GoodbyeMessage
+	readEnvelope(t, crash, GoodbyeMessageType, &bye)
+	if bye.Code != websocket.CloseInternalServerErr {
+		t.Errorf("goodbye %+v, want an internal error", bye)
+	}
+	crash.WriteJSON(CommandMessage{Type: GoodbyeCommand})
+
+	// The goodbye goes out before the pod is removed
+	within(t, 5*time.Second, func() {
+		for l.ConnectedCount() != 1 || len(l.Snapshot().Pods) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	o := podNamed(t, l, "steady")
+	if err := steady.WriteJSON(CommandMessage{Type: MoveCommand, Move: Down}); err != nil {
+		t.Fatal(err)
+	}
+	within(t, 5*time.Second, func() {
+		for o.State().Steps == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	if !o.State().Connected {
+		t.Error("the other pod lost its session")
+	}
+}
+
+// pumpCount counts the goroutines running fn, e.g. "(*Octapod).readPump"
+func pumpCount(fn string) int {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return strings.Count(string(buf[:n]), "internal."+fn+"(")
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+func TestNewPodHasOneReader(t *testing.T) {
+	// Pumps of earlier tests wind down once their connections close
+	within(t, 5*time.Second, func() {
+		for pumpCount("(*Octapod).readPump") > 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+	l := newTestLobby(t, 7, 7, 1)
+	conn := dialPod(t, l, AuthMessage{ID: "fresh", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	time.Sleep(20 * time.Millisecond)
+
+	if n := pumpCount("(*Octapod).readPump"); n != 1 {
+		t.Errorf("%d read pumps for one new pod", n)
+	}
+	if n := pumpCount("(*Octapod).writePump"); n != 1 {
+		t.Errorf("%d write pumps for one new pod", n)
+	}
+}
+
+func TestWriteToStalledClientTimesOut(t *testing.T) {
+	setFor(t, &TimeoutInterval, 50*time.Millisecond)
+	l := newTestLobby(t, 5, 5, 1)
+	written := make(chan error, 1)
+	// The client never reads, so the socket buffers fill up and a write blocks
+	dialHandler(t, func(conn *websocket.Conn) {
+		o := newOctapod("stalled", "", conn, l)
+		big := MazeMessage{Walls: make([][]bool, 300)}
+		for x := range big.Walls {
+			big.Walls[x] = make([]bool, 300)
+		}
+		for {
+			if err := o.write(conn, big); err != nil {
+				written <- err
+				return
+			}
+		}
+	})
+	select {
+	case err := <-written:
+		if !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("write failed with %v, want a timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("writing to a stalled client never gave up")
+	}
+}
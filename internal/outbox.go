@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Messages wait in an in-memory queue and are posted by one sender goroutine, so a
+// slow or unreachable Discord never holds up the game. A failed post is retried with
+// a backoff doubling from DiscordRetryBackoff up to DiscordMaxBackoff.
+var DiscordQueueSize = 100 // Messages past it are dropped until the queue drains
+var DiscordRetries = 5
+var DiscordRetryBackoff = time.Second
+var DiscordMaxBackoff = time.Minute
+var DiscordFlushTimeout = 5 * time.Second // How long Close waits for the queue to drain
+
+type outboundMessage struct {
+	text     string
+	channel  string // A command reply's channel, the bot's channel when empty
+	board    bool   // Only the latest queued board is posted
+	attempts int
+}
+
+// outbox is the bot's send queue. Its mutex is a leaf lock.
+type outbox struct {
+	mutex     sync.Mutex
+	queue     []outboundMessage
+	startOnce sync.Once
+	closeOnce sync.Once
+	wake      chan struct{}
+	closing   chan struct{}
+	stopped   chan struct{}
+}
+
+func (d *DiscordBot) startSender() {
+	d.outbox.startOnce.Do(func() {
+		d.outbox.wake = make(chan struct{}, 1)
+		d.outbox.closing = make(chan struct{})
+		d.outbox.stopped = make(chan struct{})
+		go d.sendLoop()
+	})
+}
+
+// enqueue never blocks. A board replaces the text of a board still in the queue,
+// keeping its place so boards are not delayed by a rapid stream of updates.
+func (d *DiscordBot) enqueue(message outboundMessage) {
+	d.startSender()
+	q := &d.outbox
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if message.board {
+		for i := range q.queue {
+			if q.queue[i].board {
+				q.queue[i] = message
+				return
+			}
+		}
+	}
+	if DiscordQueueSize > 0 && len(q.queue) >= DiscordQueueSize {
+		discordDropped.Inc()
+		slog.Warn("Discord queue full, dropping message", "queued", len(q.queue))
+		return
+	}
+	q.queue = append(q.queue, message)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the head of the queue, merging the plain messages queued behind it
+// while they fit one Discord message. Command replies are never merged. It waits for a message, false once closing
+// with nothing left to send.
+func (d *DiscordBot) next() (outboundMessage, bool) {
+	q := &d.outbox
+	for {
+		q.mutex.Lock()
+		if len(q.queue) > 0 {
+			message := q.queue[0]
+			q.queue = q.queue[1:]
+			for !message.board && message.channel == "" && len(q.queue) > 0 && !q.queue[0].board && q.queue[0].channel == "" &&
+				len(message.text)+1+len(q.queue[0].text) <= MaxMessageLength {
+				message.text += "\n" + q.queue[0].text
+				q.queue = q.queue[1:]
+			}
+			q.mutex.Unlock()
+			return message, true
+		}
+		q.mutex.Unlock()
+
+		select {
+		case <-q.wake:
+		case <-q.closing:
+			q.mutex.Lock()
+			empty := len(q.queue) == 0
+			q.mutex.Unlock()
+			if empty {
+				return outboundMessage{}, false
+			}
+		}
+	}
+}
+
+// requeue puts a failed message back at the head, unless it is a board that a newer
+// one already replaced
+func (d *DiscordBot) requeue(message outboundMessage) {
+	q := &d.outbox
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if message.board {
+		for _, queued := range q.queue {
+			if queued.board {
+				return
+			}
+		}
+	}
+	q.queue = append([]outboundMessage{message}, q.queue...)
+}
+
+func (d *DiscordBot) sendLoop() {
+	defer close(d.outbox.stopped)
+	backoff := DiscordRetryBackoff
+	for {
+		// Wait for the budget before taking a message, so boards keep coalescing meanwhile
+		if wait := d.limiter.Wait(); wait > 0 {
+			time.Sleep(wait)
+		}
+		message, ok := d.next()
+		if !ok {
+			return
+		}
+		d.limiter.Allow()
+
+		if err := d.send(message); err != nil {
+			// A Discord outage must not take the update loop down with it
+			discordFailures.Inc()
+			message.attempts++
+			if message.attempts > DiscordRetries {
+				discordDropped.Inc()
+				slog.Error("Dropping Discord message", "attempts", message.attempts, "err", err)
+				continue
+			}
+			slog.Warn("Error sending Discord message, retrying", "backoff", backoff, "err", err)
+			d.requeue(message)
+			time.Sleep(backoff)
+			backoff = min(backoff*2, DiscordMaxBackoff)
+			continue
+		}
+		backoff = DiscordRetryBackoff
+	}
+}
+
+// flush stops the sender once the queue is empty, waiting at most timeout
+func (d *DiscordBot) flush(timeout time.Duration) {
+	d.startSender()
+	d.outbox.closeOnce.Do(func() { close(d.outbox.closing) })
+	select {
+	case <-d.outbox.stopped:
+	case <-time.After(timeout):
+		d.outbox.mutex.Lock()
+		left := len(d.outbox.queue)
+		d.outbox.mutex.Unlock()
+		slog.Warn("Discord queue not drained", "lost", left)
+	}
+}
@@ -1,6 +1,10 @@
 package internal
 
-import "github.com/quartercastle/vector"
+import (
+	"time"
+
+	"github.com/quartercastle/vector"
+)
 
 type PingMessage struct {
 	Sensor   *Sensor       `json:"sensor"`
@@ -16,8 +20,20 @@ const (
 	Right Move = "Right"
 )
 
-type MoveMessage struct {
-	Move Move `json:"move"`
+type CommandType string
+
+const (
+	MoveCommand    CommandType = "move"
+	ForfeitCommand CommandType = "forfeit"
+	TickCommand    CommandType = "tick"
+	ExitsCommand   CommandType = "exits"   // Answered with an ExitsMessage when ExitBearings is on
+	GoodbyeCommand CommandType = "goodbye" // Sent by the server before closing, echoed by clients to acknowledge
+)
+
+// CommandMessage is sent by octapods. A missing type is treated as a move.
+type CommandMessage struct {
+	Type CommandType `json:"type"`
+	Move Move        `json:"move"`
 }
 
 func (move Move) ToVector() vector.Vector {
@@ -36,10 +52,99 @@ func (move Move) ToVector() vector.Vector {
 }
 
 type AuthMessage struct {
-	ID       string `json:"id"`
-	Password string `json:"password"`
+	ID             string `json:"id"`
+	Password       string `json:"password"`
+	TickMultiplier int    `json:"tickMultiplier"` // Optional, receive sensor data every n-th update
+	Tag            string `json:"tag"`            // Optional, 1-2 characters shown on the board
+	Takeover       bool   `json:"takeover"`       // Optional, replace a connection that is still open
+	Version        int    `json:"version"`        // Optional, the protocol version, see ProtocolVersion
+	Token          string `json:"token"`          // Optional, resume with the token from the welcome instead of the password
+	Team           string `json:"team"`           // Optional, join or found a team on registration
+}
+
+type MazeMessage struct {
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Walls    [][]bool `json:"walls"` // Indexed [x][y], true: wall
+	Entrance Point    `json:"entrance"`
+	Exit     Point    `json:"exit"`
+
+	ExtraExits []Point `json:"extraExits,omitempty"` // Further exits, see ExtraExitCount
+	Theme      string  `json:"theme"`
+	Palette    Palette `json:"palette"` // The theme's colours, so clients need no table of themes
+}
+
+type PresenceType string
+
+const (
+	PlayerJoined    PresenceType = "player_joined"
+	PlayerLeft      PresenceType = "player_left"
+	PlayerForfeited PresenceType = "player_forfeited" // Sent whether or not BroadcastPresence is on
+)
+
+type PresenceMessage struct {
+	Type PresenceType `json:"type"`
+	Id   string       `json:"id"`
+}
+
+// TickMessage answers a tick command so clients can align with the server cadence
+type TickMessage struct {
+	Type           CommandType `json:"type"`
+	Tick           int64       `json:"tick"`
+	UpdateInterval int64       `json:"updateInterval"` // Milliseconds
+}
+
+// ExitsMessage answers an exits command with a rough heading to every exit
+type ExitsMessage struct {
+	Type  CommandType   `json:"type"`
+	Exits []ExitBearing `json:"exits"`
+}
+
+// ExitBearing gives a compass point (N is up, "here" on the exit itself) and a
+// distance bucket, never the exit's coordinates
+type ExitBearing struct {
+	Bearing  string `json:"bearing"`
+	Distance string `json:"distance"` // near, medium or far
+}
+
+type ResultMessage struct {
+	Finished bool `json:"finished"`
+	DNF      bool `json:"dnf"`
+}
+
+// PodState is the public view of an octapod, it must never carry credentials
+type PodState struct {
+	Id             string `json:"id"`
+	Tag            string `json:"tag"`
+	Team           string `json:"team,omitempty"`
+	Position       Point  `json:"position"`
+	Connected      bool   `json:"connected"`
+	Steps          int    `json:"steps"`
+	Score          int    `json:"score"` // Points from pickups
+	IllegalMoves   int    `json:"illegalMoves"`
+	InactiveCount  int    `json:"inactiveCount"`
+	TickMultiplier int    `json:"tickMultiplier"`
+	Discovered     int    `json:"discovered"`
+	Finished       bool   `json:"finished"`
+	DNF            bool   `json:"dnf"`
+
+	FinishTicks  int64      `json:"finishTicks,omitempty"` // Ticks from joining to reaching the exit
+	JoinedAt     time.Time  `json:"joinedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"` // Nil until the pod finishes
+	Disconnects  int        `json:"disconnects"`
+	DroppedTicks int64      `json:"droppedTicks"` // Sensors dropped because the pod did not keep up
+	RTTMs        float64    `json:"rttMs"`        // Last ping round trip, 0 until a pong arrives
 }
 
 type ErrorMessage struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"` // Machine-readable reason, e.g. RateLimitedError
+}
+
+// GoodbyeMessage precedes the close frame, clients may answer {"type":"goodbye"}
+// to have the socket closed without waiting for GoodbyeTimeout
+type GoodbyeMessage struct {
+	Type   CommandType `json:"type"`
+	Code   int         `json:"code"`
+	Reason string      `json:"reason"`
 }
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var PodLogDir = "" // Empty disables per-pod command logs
+
+// podLog records the commands a pod sent and the messages it was sent, one line each
+type podLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func openPodLog(id string) *podLog {
+	if PodLogDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(PodLogDir, 0755); err != nil {
+		slog.Error("Error creating pod log directory", "err", err)
+		return nil
+	}
+	path := filepath.Join(PodLogDir, logFileName(id))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		slog.Error("Error opening pod log", "octapod", id, "err", err)
+		return nil
+	}
+	return &podLog{file: file}
+}
+
+func (p *podLog) write(tick int64, direction string, payload []byte) {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.file == nil {
+		return
+	}
+	// Clients often end messages with a newline, keep one entry per line
+	payload = bytes.TrimRight(payload, "\r\n")
+	fmt.Fprintf(p.file, "%s tick=%d %s %s\n", time.Now().Format(time.RFC3339Nano), tick, direction, payload)
+}
+
+func (p *podLog) Close() {
+	if p == nil {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.file != nil {
+		p.file.Close()
+		p.file = nil
+	}
+}
+
+// logFileName keeps IDs from escaping the log directory
+func logFileName(id string) string {
+	return safeFileName(id) + ".log"
+}
+
+// safeFileName reduces an ID to characters safe in a file name. An ID that had to
+// change gets a short hash of the original after a dot, which the reduced
+// characters never contain, so distinct IDs never share a file.
+func safeFileName(id string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, id)
+	if name == id {
+		return name
+	}
+	sum := sha256.Sum256([]byte(id))
+	return name + "." + hex.EncodeToString(sum[:6])
+}
+
+// openCommandLog starts a fresh log for the current connection, replacing any previous one
+func (o *Octapod) openCommandLog() {
+	if previous := o.commandLog.Swap(openPodLog(o.Id)); previous != nil {
+		previous.Close()
+	}
+}
+
+func (o *Octapod) closeCommandLog() {
+	if previous := o.commandLog.Swap(nil); previous != nil {
+		previous.Close()
+	}
+}
+
+func (o *Octapod) logCommand(direction string, payload []byte) {
+	o.commandLog.Load().write(o.lobby.tick.Load(), direction, payload)
+}
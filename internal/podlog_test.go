@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFileNameKeepsIdsApart(t *testing.T) {
+	ids := []string{"a_b", "a.b", "a/b", "a b", "a\\b", "ab", "..", "__", "été", "ete"}
+	names := make(map[string]string)
+	for _, id := range ids {
+		name := logFileName(id)
+		if other, taken := names[name]; taken {
+			t.Errorf("%q and %q share the log file %q", id, other, name)
+		}
+		names[name] = id
+		if filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+			t.Errorf("log file %q for %q escapes or hides in the log directory", name, id)
+		}
+	}
+	if got := logFileName("pod-1_a"); got != "pod-1_a.log" {
+		t.Errorf("plain IDs keep their name, got %q", got)
+	}
+}
+
+func TestCommandLogRecordsMoves(t *testing.T) {
+	setFor(t, &PodLogDir, t.TempDir())
+	l := openRoomLobby(t)
+	conn := dialPod(t, l, AuthMessage{ID: "Chatty", Password: "secret", Version: 2})
+	var welcome WelcomeMessage
+	readEnvelope(t, conn, WelcomeMessageType, &welcome)
+	o := podNamed(t, l, "chatty")
+	for _, move := range []Move{Right, Down} {
+		if err := conn.WriteJSON(CommandMessage{Type: MoveCommand, Move: move}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	within(t, 5*time.Second, func() {
+		for o.State().Steps < 2 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+	conn.Close()
+	within(t, 5*time.Second, func() {
+		for o.commandLog.Load() != nil {
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	data, err := os.ReadFile(filepath.Join(PodLogDir, "chatty.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, want := range []string{`recv {"type":"move","move":"Right"}`, `recv {"type":"move","move":"Down"}`, `sent {"type":"welcome"`} {
+		if !strings.Contains(log, want) {
+			t.Errorf("command log has no %s:\n%s", want, log)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		if !strings.Contains(line, " tick=") {
+			t.Errorf("line without a tick: %s", line)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PollTimeout is how long GET /api/octapod/:id/sensor waits for the next sensor
+// before answering 204, keep it below PongWait so waiting sessions are not reaped
+var PollTimeout = 10 * time.Second
+
+// pollSession authenticates an HTTP API request with basic auth, the octapod ID as
+// user. A pod without a session is registered or resumed like a WebSocket join,
+// ?tag=, ?team=, ?tickMultiplier= and ?takeover=true work as in the AuthMessage.
+func (l *Lobby) pollSession(c *gin.Context) *Octapod {
+	id := strings.ToLower(c.Param("id"))
+	user, password, ok := c.Request.BasicAuth()
+	if !ok || strings.ToLower(user) != id {
+		c.Header("WWW-Authenticate", `Basic realm="octapod"`)
+		c.JSON(http.StatusUnauthorized, ErrorMessage{Error: "Authenticate with basic auth, using the octapod ID as user name."})
+		return nil
+	}
+
+	l.Mutex.RLock()
+	o, exists := l.Octapods[id]
+	l.Mutex.RUnlock()
+	if exists {
+		o.Mutex.Lock()
+		polling := o.polling
+		o.Mutex.Unlock()
+		if polling {
+			if !o.VerifyPassword(password) {
+				l.metrics.authFailures.WithLabelValues("wrong_password").Inc()
+				c.JSON(http.StatusUnauthorized, ErrorMessage{Error: "Invalid password for octapod"})
+				return nil
+			}
+			o.touch()
+			return o
+		}
+	}
+
+	multiplier, _ := strconv.Atoi(c.Query("tickMultiplier"))
+	auth := &AuthMessage{
+		ID:             id,
+		Password:       password,
+		TickMultiplier: multiplier,
+		Tag:            c.Query("tag"),
+		Team:           c.Query("team"),
+		Takeover:       c.Query("takeover") == "true",
+		Version:        1, // Responses are plain JSON
+	}
+	o, err := l.identifyOctapod(auth, nil, nextConnId())
+	var refused *joinError
+	if errors.As(err, &refused) {
+		c.JSON(refused.status, ErrorMessage{Error: refused.message})
+		return nil
+	}
+	o.touch()
+	return o
+}
+
+// HandlePollSensor waits up to PollTimeout for the pod's next sensor, answering with
+// the same message the WebSocket pushes
+func (l *Lobby) HandlePollSensor(c *gin.Context) {
+	o := l.pollSession(c)
+	if o == nil {
+		return
+	}
+	timer := time.NewTimer(PollTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-timer.C:
+			o.touch()
+			c.Status(http.StatusNoContent)
+			return
+		case sensor := <-o.Sensor:
+			if sensor == nil {
+				continue // Timeout signals only concern the write pump
+			}
+			o.Mutex.Lock()
+			polling := o.polling
+			pos := o.Position
+			o.Mutex.Unlock()
+			if !polling {
+				// A WebSocket took over while we waited, its write pump gets the sensor
+				o.deliver(sensor)
+				c.JSON(http.StatusConflict, ErrorMessage{Error: "Session taken over"})
+				return
+			}
+			o.touch()
+			l.metrics.sensorFramesSent.Inc()
+			c.JSON(http.StatusOK, PingMessage{Sensor: sensor, Position: pos})
+			return
+		}
+	}
+}
+
+// HandlePollMove applies a CommandMessage move under the same round, rate limit and
+// collision rules as the WebSocket, answering with the pod's state
+func (l *Lobby) HandlePollMove(c *gin.Context) {
+	var cmd CommandMessage
+	if err := c.ShouldBindJSON(&cmd); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorMessage{Error: "Invalid move: " + err.Error()})
+		return
+	}
+	o := l.pollSession(c)
+	if o == nil {
+		return
+	}
+	if phase := l.Phase(); phase != PhaseRunning {
+		c.JSON(http.StatusConflict, ErrorMessage{Error: "The round is " + string(phase) + ", moves are ignored until it runs"})
+		return
+	}
+	if refusal, _ := o.checkMoveQuota(); refusal != nil {
+		c.JSON(http.StatusTooManyRequests, *refusal)
+		return
+	}
+	if b, err := json.Marshal(cmd); err == nil {
+		o.logCommand("recv", b)
+	}
+	o.move(cmd.Move)
+	c.JSON(http.StatusOK, o.State())
+}
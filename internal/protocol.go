@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Protocol versions, requested with the version field of the AuthMessage:
+//
+//	1: Messages are bare JSON objects, e.g. {"sensor":{...},"position":[x,y]} and
+//	   {"type":"move","move":"Up"}. Clients that send no version speak 1.
+//	2: Every message in both directions is an Envelope, e.g.
+//	   {"type":"sensor","payload":{"sensor":{...},"position":[x,y]}} and
+//	   {"type":"move","payload":{"move":"Up"}}. The server opens with a welcome
+//	   carrying a single-use token to reconnect with instead of the password.
+//
+// A client asking for a version outside MinProtocolVersion..ProtocolVersion is
+// refused with an error naming the supported range.
+const ProtocolVersion = 2
+
+var MinProtocolVersion = 1
+
+type MessageType string
+
+const (
+	SensorMessageType   MessageType = "sensor"   // PingMessage
+	MazeMessageType     MessageType = "maze"     // MazeMessage, when RevealMaze is on
+	ErrorMessageType    MessageType = "error"    // ErrorMessage
+	ResultMessageType   MessageType = "result"   // ResultMessage
+	TickMessageType     MessageType = "tick"     // TickMessage
+	GoodbyeMessageType  MessageType = "goodbye"  // GoodbyeMessage
+	PresenceMessageType MessageType = "presence" // PresenceMessage, joins and leaves when BroadcastPresence is on
+	WelcomeMessageType  MessageType = "welcome"  // WelcomeMessage
+	EventMessageType    MessageType = "event"    // EventMessage
+	ExitsMessageType    MessageType = "exits"    // ExitsMessage
+)
+
+// messageTypes registers every message the server sends. Encoding an unregistered
+// message fails, so a new shape can't reach clients without a declared type.
+var messageTypes = map[reflect.Type]MessageType{
+	reflect.TypeOf(PingMessage{}):     SensorMessageType,
+	reflect.TypeOf(MazeMessage{}):     MazeMessageType,
+	reflect.TypeOf(ErrorMessage{}):    ErrorMessageType,
+	reflect.TypeOf(ResultMessage{}):   ResultMessageType,
+	reflect.TypeOf(TickMessage{}):     TickMessageType,
+	reflect.TypeOf(GoodbyeMessage{}):  GoodbyeMessageType,
+	reflect.TypeOf(PresenceMessage{}): PresenceMessageType,
+	reflect.TypeOf(WelcomeMessage{}):  WelcomeMessageType,
+	reflect.TypeOf(EventMessage{}):    EventMessageType,
+	reflect.TypeOf(ExitsMessage{}):    ExitsMessageType,
+}
+
+// Envelope wraps every message from protocol 2 on
+type Envelope struct {
+	Type    MessageType     `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// WelcomeMessage is the first message of a protocol 2 connection
+type WelcomeMessage struct {
+	Version        int   `json:"version"`
+	MinVersion     int   `json:"minVersion"`
+	MaxVersion     int   `json:"maxVersion"`
+	Tick           int64 `json:"tick"`
+	UpdateInterval int64 `json:"updateInterval"` // Milliseconds
+	Phase          Phase `json:"phase"`
+
+	Token        string    `json:"token"`        // Resumes this session once, instead of the password
+	TokenExpires time.Time `json:"tokenExpires"` // Deadline to resume if the connection dropped now
+}
+
+// negotiateVersion picks the protocol a client asked for, 0 predates versioning
+func negotiateVersion(requested int) (int, error) {
+	if requested == 0 {
+		requested = 1
+	}
+	if requested < MinProtocolVersion || requested > ProtocolVersion {
+		return 0, fmt.Errorf("protocol version %d is not supported, this server speaks versions %d to %d",
+			requested, MinProtocolVersion, ProtocolVersion)
+	}
+	return requested, nil
+}
+
+// encodeMessage marshals v in the shape of the given protocol version
+func encodeMessage(version int, v any) ([]byte, error) {
+	if version < 2 {
+		return json.Marshal(v)
+	}
+	t, registered := messageTypes[reflect.TypeOf(v)]
+	if !registered {
+		return nil, fmt.Errorf("message %T has no registered type", v)
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Envelope{Type: t, Payload: payload})
+}
+
+// decodeCommand reads a command in the shape of the given protocol version
+func decodeCommand(version int, msg []byte) (CommandMessage, error) {
+	var cmd CommandMessage
+	if version < 2 {
+		err := json.Unmarshal(msg, &cmd)
+		return cmd, err
+	}
+	var envelope Envelope
+	if err := json.Unmarshal(msg, &envelope); err != nil {
+		return cmd, err
+	}
+	if envelope.Type == "" {
+		return cmd, fmt.Errorf("protocol %d commands need a type", version)
+	}
+	if len(envelope.Payload) > 0 {
+		if err := json.Unmarshal(envelope.Payload, &cmd); err != nil {
+			return cmd, err
+		}
+	}
+	cmd.Type = CommandType(envelope.Type)
+	return cmd, nil
+}
+
+// greet opens a connection with the welcome, when the protocol has one, and the maze
+func (l *Lobby) greet(o *Octapod, conn *websocket.Conn) {
+	if o.protocol.Load() >= 2 {
+		welcome := WelcomeMessage{
+			Version:        int(o.protocol.Load()),
+			MinVersion:     MinProtocolVersion,
+			MaxVersion:     ProtocolVersion,
+			Tick:           l.tick.Load(),
+			UpdateInterval: l.UpdateInterval().Milliseconds(),
+			Phase:          l.Phase(),
+			Token:          l.issueToken(o.Id),
+			TokenExpires:   time.Now().Add(ReconnectTokenTTL),
+		}
+		if err := o.write(conn, welcome); err != nil {
+			o.logger().Error("Error sending welcome", "err", err)
+		}
+	}
+	l.revealMaze(o, conn)
+}
@@ -0,0 +1,22 @@
+package internal
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
+)
+
+// newRand derives a reproducible generator from the lobby seed and a label such as
+// "maze" or a pod ID, so each consumer gets an independent stream from one seed
+func newRand(seed int64, label string) *rand.Rand {
+	return rand.New(rand.NewSource(deriveSeed(seed, label)))
+}
+
+func deriveSeed(seed int64, label string) int64 {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(seed))
+	h.Write(b[:])
+	h.Write([]byte(label))
+	return int64(h.Sum64())
+}
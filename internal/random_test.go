@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func draws(seed int64, label string) []int64 {
+	rng := newRand(seed, label)
+	out := make([]int64, 8)
+	for i := range out {
+		out[i] = rng.Int63()
+	}
+	return out
+}
+
+func TestNewRandReproducible(t *testing.T) {
+	for _, label := range []string{"maze", "spawn", "octo", ""} {
+		if a, b := draws(42, label), draws(42, label); !reflect.DeepEqual(a, b) {
+			t.Errorf("seed 42 label %q gave %v then %v", label, a, b)
+		}
+	}
+}
+
+func TestNewRandLabelsDiverge(t *testing.T) {
+	seen := map[int64]string{}
+	for _, tc := range []struct {
+		seed  int64
+		label string
+	}{
+		{42, "maze"}, {42, "spawn"}, {42, "octo"}, {42, "octo2"}, {43, "maze"}, {0, "maze"},
+	} {
+		first := draws(tc.seed, tc.label)[0]
+		if other, taken := seen[first]; taken {
+			t.Errorf("seed %d label %q starts like %s", tc.seed, tc.label, other)
+		}
+		seen[first] = tc.label
+	}
+	if deriveSeed(1, "a") == deriveSeed(1, "b") || deriveSeed(1, "a") == deriveSeed(2, "a") {
+		t.Error("deriveSeed ignores its seed or label")
+	}
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket allows bursts up to its capacity and refills continuously, a
+// capacity of 0 or less disables limiting
+type tokenBucket struct {
+	mutex    sync.Mutex
+	capacity float64
+	rate     float64 // Tokens per second
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(capacity int, per time.Duration) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(capacity),
+		rate:     float64(capacity) / per.Seconds(),
+		tokens:   float64(capacity),
+		last:     time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) Allow() bool {
+	if b.capacity <= 0 {
+		return true
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait returns how long until the next token is available
+func (b *tokenBucket) Wait() time.Duration {
+	if b.capacity <= 0 {
+		return 0
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(time.Now())
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// Each octapod may send MovesPerTick moves per sensor tick, saving up to MoveBurst.
+// Moves over the limit are refused, and a pod refused more than MaxRateViolations
+// times in a row (one sensor tick of calm forgives one) is suspended for
+// SuspensionDuration. MovesPerTick 0 disables limiting.
+var MovesPerTick = 1
+var MoveBurst = 2
+var MaxRateViolations = 10
+var SuspensionDuration = 30 * time.Second
+
+const (
+	RateLimitedError = "rate_limited"
+	SuspendedError   = "suspended"
+)
+
+type moveQuota struct {
+	tokens         int
+	lastTick       int64 // Sensor tick, the lobby tick divided by the pod's multiplier
+	violations     int
+	warnedTick     int64 // Refusals are reported at most once per sensor tick
+	suspendedUntil time.Time
+}
+
+// allowMove takes a move from the pod's quota. When refused it returns the error
+// code, and whether the pod should be told this time.
+func (l *Lobby) allowMove(id string, multiplier int) (code string, notify bool) {
+	if MovesPerTick <= 0 {
+		return "", false
+	}
+	tick := l.tick.Load() / int64(max(multiplier, 1))
+
+	l.quotaMutex.Lock()
+	defer l.quotaMutex.Unlock()
+	if l.moveQuotas == nil {
+		l.moveQuotas = make(map[string]*moveQuota)
+	}
+	q, exists := l.moveQuotas[id]
+	if !exists {
+		q = &moveQuota{tokens: MoveBurst, lastTick: tick, warnedTick: -1}
+		l.moveQuotas[id] = q
+	}
+	if elapsed := int(tick - q.lastTick); elapsed > 0 {
+		q.tokens = min(q.tokens+elapsed*MovesPerTick, max(MoveBurst, MovesPerTick))
+		q.violations = max(q.violations-elapsed, 0)
+		q.lastTick = tick
+	}
+
+	if time.Now().Before(q.suspendedUntil) {
+		return SuspendedError, false
+	}
+	if q.tokens > 0 {
+		q.tokens--
+		return "", false
+	}
+	q.violations++
+	if MaxRateViolations > 0 && q.violations > MaxRateViolations {
+		q.violations = 0
+		q.suspendedUntil = time.Now().Add(SuspensionDuration)
+		return SuspendedError, true
+	}
+	notify = q.warnedTick != tick
+	q.warnedTick = tick
+	return RateLimitedError, notify
+}
+
+func (l *Lobby) resetMoveQuotas() {
+	l.quotaMutex.Lock()
+	defer l.quotaMutex.Unlock()
+	l.moveQuotas = nil
+}
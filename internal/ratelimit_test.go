@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(3, time.Minute)
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("token %d refused within the burst", i+1)
+		}
+	}
+	if b.Allow() {
+		t.Error("fourth token allowed, capacity is 3")
+	}
+	if wait := b.Wait(); wait <= 0 || wait > 20*time.Second {
+		t.Errorf("Wait() = %v, want about 20s for 3 tokens a minute", wait)
+	}
+	if unlimited := newTokenBucket(0, time.Minute); !unlimited.Allow() || unlimited.Wait() != 0 {
+		t.Error("a zero capacity bucket limits sends")
+	}
+}
+
+func TestDiscordFloodStaysWithinBudget(t *testing.T) {
+	logs := captureLogs(t)
+	const budget = 5
+	bot := &DiscordBot{limiter: newTokenBucket(budget, time.Second)}
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		bot.SendBoard(fmt.Sprintf("board %d", i))
+	}
+	time.Sleep(500 * time.Millisecond)
+	bot.flush(5 * time.Second)
+	elapsed := time.Since(start)
+
+	var posted []string
+	for _, entry := range logs() {
+		if entry["msg"] == "Discord (offline)" {
+			posted = append(posted, entry["message"].(string))
+		}
+	}
+	allowed := budget + int(elapsed.Seconds()*budget) + 1
+	if len(posted) == 0 || len(posted) > allowed {
+		t.Fatalf("%d messages posted in %v, budget allows %d", len(posted), elapsed, allowed)
+	}
+	if last := posted[len(posted)-1]; last != "board 99" {
+		t.Errorf("last post %q, want the latest board", last)
+	}
+	if n := strings.Count(strings.Join(posted, "\n"), "board"); n == 100 {
+		t.Error("no board was coalesced")
+	}
+}
+
+func TestDiscordRepliesShareTheBudget(t *testing.T) {
+	logs := captureLogs(t)
+	const budget, period = 3, 150 * time.Millisecond
+	bot := &DiscordBot{ChannelId: "updates", limiter: newTokenBucket(budget, period)}
+	session := &discordgo.Session{State: discordgo.NewState()}
+	session.State.User = &discordgo.User{ID: "bot"}
+	handle := bot.makeMessageHandler()
+	start := time.Now()
+	bot.SendMessage("notice")
+	for i := 0; i < 10; i++ {
+		handle(session, &discordgo.MessageCreate{Message: &discordgo.Message{
+			Author:    &discordgo.User{ID: "player"},
+			ChannelID: "commands",
+			Content:   "!where",
+		}})
+	}
+	bot.flush(5 * time.Second)
+	elapsed := time.Since(start)
+
+	channels := map[string]int{}
+	for _, entry := range logs() {
+		if entry["msg"] == "Discord (offline)" {
+			channels[entry["channel"].(string)]++
+			if entry["channel"] == "commands" && entry["message"] != "Usage: `!where <ID>`" {
+				t.Errorf("reply %q merged with other messages", entry["message"])
+			}
+		}
+	}
+	if channels["updates"] != 1 || channels["commands"] != 10 {
+		t.Errorf("posts per channel %v, want the notice and 10 separate replies", channels)
+	}
+	if minimum := (11 - budget) * period / budget; elapsed < minimum*9/10 {
+		t.Errorf("11 posts took %v, a budget of %d per %v needs about %v", elapsed, budget, period, minimum)
+	}
+}
@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var MaxReplayLength = 1000 // Oldest positions are dropped beyond this
+
+// ReplayStep is a position a pod occupied, starting with where it spawned
+type ReplayStep struct {
+	Tick     int64     `json:"tick"`
+	Time     time.Time `json:"time"`
+	Position Point     `json:"position"`
+}
+
+type PodReplay struct {
+	Id    string       `json:"id"`
+	Steps []ReplayStep `json:"steps"`
+}
+
+// record appends the pod's current position to its history, must hold o.Mutex
+func (o *Octapod) record() {
+	o.history = append(o.history, ReplayStep{
+		Tick:     o.lobby.tick.Load(),
+		Time:     time.Now(),
+		Position: pointOf(o.Position),
+	})
+	if MaxReplayLength > 0 && len(o.history) > MaxReplayLength {
+		o.history = append([]ReplayStep(nil), o.history[len(o.history)-MaxReplayLength:]...)
+	}
+}
+
+// ExportReplay marshals the accepted positions of one pod, or of every pod in ID
+// order when id is empty
+func (l *Lobby) ExportReplay(id string) ([]byte, error) {
+	id = strings.ToLower(id)
+	l.Mutex.RLock()
+	pods := make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		if id == "" || o.Id == id {
+			pods = append(pods, o)
+		}
+	}
+	l.Mutex.RUnlock()
+	if id != "" && len(pods) == 0 {
+		return nil, errors.New("no octapod [" + id + "] in the lobby")
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Id < pods[j].Id
+	})
+	replays := make([]PodReplay, 0, len(pods))
+	for _, o := range pods {
+		o.Mutex.Lock()
+		replays = append(replays, PodReplay{Id: o.Id, Steps: append([]ReplayStep(nil), o.history...)})
+		o.Mutex.Unlock()
+	}
+	return json.Marshal(replays)
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func exportedReplays(t *testing.T, l *Lobby, id string) []PodReplay {
+	t.Helper()
+	data, err := l.ExportReplay(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replays []PodReplay
+	if err := json.Unmarshal(data, &replays); err != nil {
+		t.Fatal(err)
+	}
+	return replays
+}
+
+func TestExportReplayFollowsPath(t *testing.T) {
+	l := openRoomLobby(t)
+	o := addPod(t, l, "walker")
+	addPod(t, l, "idler")
+	path, ticks := []Point{pointOf(o.Position)}, []int64{0}
+	// Along the top border, bumping into it first is not an accepted move
+	for range 3 {
+		o.move(Up)
+		o.move(Right)
+		path, ticks = append(path, pointOf(o.Position)), append(ticks, l.tick.Load())
+		l.Update()
+	}
+
+	replays := exportedReplays(t, l, "Walker")
+	if len(replays) != 1 || replays[0].Id != "walker" {
+		t.Fatalf("replays %+v, want walker's alone", replays)
+	}
+	steps := replays[0].Steps
+	if len(steps) != len(path) {
+		t.Fatalf("%d steps recorded, want %d: %+v", len(steps), len(path), steps)
+	}
+	for i, step := range steps {
+		if step.Position != path[i] || step.Tick != ticks[i] {
+			t.Errorf("step %d at %v on tick %d, want %v on tick %d", i, step.Position, step.Tick, path[i], ticks[i])
+		}
+		if step.Time.IsZero() {
+			t.Errorf("step %d has no timestamp", i)
+		}
+	}
+
+	if all := exportedReplays(t, l, ""); len(all) != 2 || all[0].Id != "idler" || all[1].Id != "walker" {
+		t.Errorf("replays of every pod %+v, want idler and walker", all)
+	}
+	if _, err := l.ExportReplay("nobody"); err == nil {
+		t.Error("exported a replay for an unknown pod")
+	}
+}
+
+func TestReplayDropsOldestSteps(t *testing.T) {
+	setFor(t, &MaxReplayLength, 2)
+	l := openRoomLobby(t)
+	o := addPod(t, l, "walker")
+	for _, move := range []Move{Right, Right, Down} {
+		o.move(move)
+	}
+	steps := exportedReplays(t, l, "walker")[0].Steps
+	if want := pointOf(o.Position); len(steps) != 2 || steps[1].Position != want || steps[0].Position != (Point{want.X, want.Y - 1}) {
+		t.Errorf("capped history %+v, want the last two positions ending at %v", steps, want)
+	}
+}
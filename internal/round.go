@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"errors"
+	"time"
+)
+
+type Phase string
+
+const (
+	PhaseWaiting   Phase = "waiting"   // Pods may join, nothing moves
+	PhaseCountdown Phase = "countdown" // Pods may still join, the round starts soon
+	PhaseRunning   Phase = "running"   // Sensors tick and moves are applied
+	PhaseFinished  Phase = "finished"  // Positions are frozen and results announced
+)
+
+// RoundMode runs the lobby in discrete rounds. When off the lobby is always running.
+var RoundMode = false
+var CountdownDuration = 10 * time.Second
+var RoundDuration = 10 * time.Minute // 0 runs until every connected pod has finished
+
+// round holds the lobby phase. Its mutex is a leaf lock, it may be taken while
+// holding lobby or pod locks.
+type round struct {
+	phase Phase
+	timer *time.Timer // Pending countdown or round end
+}
+
+func (l *Lobby) Phase() Phase {
+	if !RoundMode {
+		return PhaseRunning
+	}
+	l.roundMutex.Lock()
+	defer l.roundMutex.Unlock()
+	if l.round.phase == "" {
+		return PhaseWaiting
+	}
+	return l.round.phase
+}
+
+func (l *Lobby) setPhase(phase Phase, after time.Duration, next func()) {
+	l.roundMutex.Lock()
+	if l.round.timer != nil {
+		l.round.timer.Stop()
+		l.round.timer = nil
+	}
+	l.round.phase = phase
+	if next != nil && after > 0 {
+		l.round.timer = time.AfterFunc(after, next)
+	}
+	l.roundMutex.Unlock()
+	l.logger().Info("Lobby phase changed", "phase", phase)
+}
+
+// StartRound counts down and then runs the round, only from the waiting phase
+func (l *Lobby) StartRound() error {
+	if !RoundMode {
+		return errors.New("round mode is off, the lobby is always running")
+	}
+	if phase := l.Phase(); phase != PhaseWaiting {
+		return errors.New("a round can only start while waiting, the lobby is " + string(phase))
+	}
+	l.setPhase(PhaseCountdown, CountdownDuration, l.runRound)
+	l.DiscordBot.SendMessage("Round starting in " + CountdownDuration.String() + "!")
+	if CountdownDuration <= 0 {
+		l.runRound()
+	}
+	return nil
+}
+
+func (l *Lobby) runRound() {
+	l.setPhase(PhaseRunning, RoundDuration, l.FinishRound)
+	l.DiscordBot.SendMessage("Round started, go!")
+}
+
+// FinishRound freezes the board and announces the final standings
+func (l *Lobby) FinishRound() {
+	if l.Phase() != PhaseRunning {
+		return
+	}
+	l.setPhase(PhaseFinished, 0, nil)
+
+	l.Mutex.RLock()
+	pods := make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		pods = append(pods, o)
+	}
+	l.Mutex.RUnlock()
+	for _, o := range pods {
+		l.saveResult(o)
+	}
+	l.DiscordBot.SendMessage("Round over! Final standings:\n" + l.Scoreboard().Render())
+}
+
+// finishRoundIfDone ends a running round once every connected pod has finished
+func (l *Lobby) finishRoundIfDone() {
+	if !RoundMode || l.Phase() != PhaseRunning {
+		return
+	}
+	snapshot := l.Snapshot()
+	playing := 0
+	for _, p := range snapshot.Pods {
+		if p.Connected && !p.Finished {
+			playing++
+		}
+	}
+	if playing == 0 && len(snapshot.Pods) > 0 {
+		l.FinishRound()
+	}
+}
+
+// resetRound returns the lobby to waiting for the next round
+func (l *Lobby) resetRound() {
+	if RoundMode {
+		l.setPhase(PhaseWaiting, 0, nil)
+	}
+}
+
+// restorePhase resumes a saved phase, restarting its countdown or round timer in full
+func (l *Lobby) restorePhase(phase Phase) {
+	if !RoundMode {
+		return
+	}
+	switch phase {
+	case PhaseCountdown:
+		l.setPhase(PhaseCountdown, CountdownDuration, l.runRound)
+	case PhaseRunning:
+		l.setPhase(PhaseRunning, RoundDuration, l.FinishRound)
+	case PhaseFinished:
+		l.setPhase(PhaseFinished, 0, nil)
+	default:
+		l.setPhase(PhaseWaiting, 0, nil)
+	}
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ScoreEntry is one scoreboard row, built from a pod's public state
+type ScoreEntry struct {
+	Id             string  `json:"id"`
+	Tag            string  `json:"tag"`
+	Team           string  `json:"team,omitempty"`
+	Connected      bool    `json:"connected"`
+	Steps          int     `json:"steps"`
+	Score          int     `json:"score"`
+	Finished       bool    `json:"finished"`
+	DNF            bool    `json:"dnf"`
+	FinishTicks    int64   `json:"finishTicks,omitempty"`
+	CompletionMs   int64   `json:"completionMs,omitempty"` // Wall time from joining to reaching the exit
+	DistanceToExit int     `json:"distanceToExit"`         // -1 when the exit is unreachable
+	Handicap       float64 `json:"handicap"`               // Difficulty factor, see Handicaps
+
+	Rating float64 `json:"rating,omitempty"` // From the lobby's Scorer, see Ranked
+	Ranked bool    `json:"ranked"`           // Whether the Scorer rated the pod
+}
+
+// ScoreBoard is a ranked list of pods, best first
+type ScoreBoard []ScoreEntry
+
+// Winners are the pods tied for first place among those that reached the exit and
+// the Scorer ranked
+func (b ScoreBoard) Winners() []ScoreEntry {
+	var winners []ScoreEntry
+	for _, e := range b {
+		if !e.Finished || e.DNF || !e.Ranked || (len(winners) > 0 && e.Rating != winners[0].Rating) {
+			break
+		}
+		winners = append(winners, e)
+	}
+	return winners
+}
+
+// Render formats the board for Discord, one line per pod followed by the teams, if any
+func (b ScoreBoard) Render() string {
+	if len(b) == 0 {
+		return "No octapods in the lobby."
+	}
+	lines := make([]string, 0, len(b))
+	for i, e := range b {
+		var status string
+		switch {
+		case e.DNF:
+			status = "forfeited"
+		case e.Finished:
+			status = fmt.Sprintf("finished in %d ticks (%.1fs)", e.FinishTicks, float64(e.CompletionMs)/1000)
+		case e.DistanceToExit >= 0:
+			status = fmt.Sprintf("%d steps from the exit", e.DistanceToExit)
+		default:
+			status = "cut off from the exit"
+		}
+		team := ""
+		if e.Team != "" {
+			team = " (" + displayId(e.Team) + ")"
+		}
+		if e.Score > 0 {
+			status += fmt.Sprintf(", %d points", e.Score)
+		}
+		lines = append(lines, fmt.Sprintf("%d. [%s]%s %s, %d steps taken", i+1, displayId(e.Id), team, status, e.Steps))
+	}
+	if teams := b.Teams(); len(teams) > 0 {
+		lines = append(lines, "", renderTeams(teams))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Scoreboard ranks the pods by the lobby's Scorer. Ties and unranked pods fall back
+// to finished pods by fewest ticks to the exit, then pods still playing by distance
+// to the exit, then forfeited pods, with pickup score, then steps breaking ties.
+func (l *Lobby) Scoreboard() ScoreBoard {
+	snapshot := l.Snapshot()
+	l.Mutex.RLock()
+	distances := l.Maze.ExitDistances()
+	l.Mutex.RUnlock()
+
+	entries := make(ScoreBoard, 0, len(snapshot.Pods))
+	for _, p := range snapshot.Pods {
+		distance, reachable := distances[p.Position]
+		if !reachable {
+			distance = -1
+		}
+		var completion int64
+		if p.Finished && !p.DNF && p.FinishedAt != nil {
+			completion = p.FinishedAt.Sub(p.JoinedAt).Milliseconds()
+		}
+		entries = append(entries, ScoreEntry{
+			Id:             p.Id,
+			Tag:            p.Tag,
+			Team:           p.Team,
+			Connected:      p.Connected,
+			Steps:          p.Steps,
+			Score:          p.Score,
+			Finished:       p.Finished,
+			DNF:            p.DNF,
+			FinishTicks:    p.FinishTicks,
+			CompletionMs:   completion,
+			DistanceToExit: distance,
+			Handicap:       handicap(p.Id),
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if rankGroup(a) != rankGroup(b) {
+			return rankGroup(a) < rankGroup(b)
+		}
+		if a.Finished && !a.DNF && a.FinishTicks != b.FinishTicks {
+			return a.FinishTicks < b.FinishTicks
+		}
+		if !a.Finished && a.DistanceToExit != b.DistanceToExit {
+			return b.DistanceToExit < 0 || (a.DistanceToExit >= 0 && a.DistanceToExit < b.DistanceToExit)
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Steps < b.Steps
+	})
+	entries.rank(l.scorer())
+	return entries
+}
+
+func rankGroup(e ScoreEntry) int {
+	switch {
+	case e.DNF:
+		return 2
+	case e.Finished:
+		return 0
+	default:
+		return 1
+	}
+}
+
+func (l *Lobby) HandleScoreboard(c *gin.Context) {
+	c.JSON(http.StatusOK, l.Scoreboard())
+}
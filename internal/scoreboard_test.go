@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestScoreboardOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := openRoomLobby(t)
+	exit := l.Maze.Exit
+	setResult(addPod(t, l, "finished"), true, false, 30, 50)
+	near, frugal, far := addPod(t, l, "near"), addPod(t, l, "frugal"), addPod(t, l, "far")
+	placeAt(t, l, near, Point{exit.X - 1, exit.Y})
+	placeAt(t, l, frugal, Point{exit.X, exit.Y - 1})
+	placeAt(t, l, far, Point{exit.X - 3, exit.Y - 3})
+	setResult(near, false, false, 0, 9)
+	setResult(frugal, false, false, 0, 2)
+	setResult(far, false, false, 0, 1)
+
+	router := gin.New()
+	router.GET("/scoreboard", l.HandleScoreboard)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/scoreboard", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var rows []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		for key := range row {
+			if k := strings.ToLower(key); strings.Contains(k, "password") || k == "conn" {
+				t.Errorf("scoreboard row has %q", key)
+			}
+		}
+	}
+	var board ScoreBoard
+	if err := json.Unmarshal(w.Body.Bytes(), &board); err != nil {
+		t.Fatal(err)
+	}
+	// Finished first, then by distance to the exit, fewer steps breaking the tie
+	assertStandings(t, board, "finished", "frugal", "near", "far")
+	if e := board[3]; !e.Connected || e.Finished || e.Steps != 1 || e.DistanceToExit != 6 {
+		t.Errorf("far pod entry %+v, want connected, unfinished, 1 step and 6 from the exit", e)
+	}
+}
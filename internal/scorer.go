@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Scorer rates a pod's scoreboard entry for the standings, higher is better. Pods it
+// leaves unranked, such as unfinished ones for TimeScorer, follow the ranked ones in
+// the default order. Equal scores keep the default order too.
+type Scorer interface {
+	Score(entry ScoreEntry) (score float64, ranked bool)
+}
+
+// DefaultScorer ranks lobbies without a Scorer of their own
+var DefaultScorer Scorer = TimeScorer{}
+
+// Scorers can be picked by name, e.g. from config
+var Scorers = map[string]Scorer{
+	"time":        TimeScorer{},
+	"steps":       StepScorer{},
+	"checkpoints": CheckpointScorer{Every: 5},
+}
+
+func ScorerByName(name string) (Scorer, error) {
+	if s, ok := Scorers[strings.ToLower(name)]; ok {
+		return s, nil
+	}
+	names := make([]string, 0, len(Scorers))
+	for n := range Scorers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown scorer %q, pick one of %s", name, strings.Join(names, ", "))
+}
+
+func reachedExit(e ScoreEntry) bool {
+	return e.Finished && !e.DNF
+}
+
+// TimeScorer ranks pods that reached the exit by fewest ticks
+type TimeScorer struct{}
+
+func (TimeScorer) Score(e ScoreEntry) (float64, bool) {
+	return -float64(e.FinishTicks), reachedExit(e)
+}
+
+// StepScorer ranks pods that reached the exit by fewest steps taken
+type StepScorer struct{}
+
+func (StepScorer) Score(e ScoreEntry) (float64, bool) {
+	return -float64(e.Steps), reachedExit(e)
+}
+
+// CheckpointScorer splits the way to the exit into legs of Every cells and ranks
+// every pod still in the race by the legs it has left, so pods that did not make
+// it are compared by how far they got. Pickup points break ties within a leg.
+type CheckpointScorer struct {
+	Every int
+}
+
+func (s CheckpointScorer) Score(e ScoreEntry) (float64, bool) {
+	if e.DNF || e.DistanceToExit < 0 {
+		return 0, false
+	}
+	every := max(s.Every, 1)
+	legs := (e.DistanceToExit + every - 1) / every
+	// Pickup points are far below one leg, they only order pods within a leg
+	return -float64(legs) + float64(e.Score)/1e6, true
+}
+
+// scorer is the lobby's scorer, falling back to DefaultScorer
+func (l *Lobby) scorer() Scorer {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+	if l.Scorer != nil {
+		return l.Scorer
+	}
+	return DefaultScorer
+}
+
+// rank orders an already sorted board by the scorer, keeping the order among
+// unranked entries and equal scores
+func (b ScoreBoard) rank(scorer Scorer) {
+	if scorer == nil {
+		return
+	}
+	for i, e := range b {
+		b[i].Rating, b[i].Ranked = scorer.Score(e)
+		if !b[i].Ranked {
+			b[i].Rating = 0
+		}
+	}
+	sort.SliceStable(b, func(i, j int) bool {
+		a, c := b[i], b[j]
+		if a.Ranked != c.Ranked {
+			return a.Ranked
+		}
+		return a.Ranked && a.Rating > c.Rating
+	})
+}
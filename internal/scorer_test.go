@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+// setResult fakes a pod's outcome
+func setResult(o *Octapod, finished, dnf bool, ticks int64, steps int) {
+	o.Mutex.Lock()
+	defer o.Mutex.Unlock()
+	o.Finished, o.DNF, o.FinishTicks, o.Steps = finished, dnf, ticks, steps
+	if finished {
+		o.FinishedAt = time.Now()
+	}
+}
+
+func standings(b ScoreBoard) []string {
+	ids := make([]string, 0, len(b))
+	for _, e := range b {
+		ids = append(ids, e.Id)
+	}
+	return ids
+}
+
+func assertStandings(t *testing.T, b ScoreBoard, want ...string) {
+	t.Helper()
+	got := standings(b)
+	if len(got) != len(want) {
+		t.Fatalf("standings %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("standings %v, want %v", got, want)
+		}
+	}
+}
+
+func scoredLobby(t *testing.T) *Lobby {
+	l := newTestLobby(t, 9, 9, 1)
+	setResult(addPod(t, l, "fast"), true, false, 10, 40)
+	setResult(addPod(t, l, "frugal"), true, false, 20, 16)
+	setResult(addPod(t, l, "playing"), false, false, 0, 3)
+	setResult(addPod(t, l, "quitter"), true, true, 0, 1)
+	return l
+}
+
+func TestTimeScorerIsTheDefault(t *testing.T) {
+	l := scoredLobby(t)
+	board := l.Scoreboard()
+	assertStandings(t, board, "fast", "frugal", "playing", "quitter")
+	if winners := board.Winners(); len(winners) != 1 || winners[0].Id != "fast" {
+		t.Errorf("winners %v, want fast", standings(winners))
+	}
+}
+
+func TestStepScorer(t *testing.T) {
+	l := scoredLobby(t)
+	l.Scorer = StepScorer{}
+	board := l.Scoreboard()
+	assertStandings(t, board, "frugal", "fast", "playing", "quitter")
+	if winners := board.Winners(); len(winners) != 1 || winners[0].Id != "frugal" {
+		t.Errorf("winners %v, want frugal", standings(winners))
+	}
+}
+
+func TestCheckpointScorer(t *testing.T) {
+	s := CheckpointScorer{Every: 5}
+	near, _ := s.Score(ScoreEntry{DistanceToExit: 4})
+	far, _ := s.Score(ScoreEntry{DistanceToExit: 6})
+	sameLeg, _ := s.Score(ScoreEntry{DistanceToExit: 1})
+	if near <= far || near != sameLeg {
+		t.Errorf("scores near %v far %v same leg %v", near, far, sameLeg)
+	}
+	if _, ranked := s.Score(ScoreEntry{DNF: true}); ranked {
+		t.Error("a DNF was ranked")
+	}
+	if _, ranked := s.Score(ScoreEntry{DistanceToExit: -1}); ranked {
+		t.Error("a pod cut off from the exit was ranked")
+	}
+}
+
+// mostSteps rewards exploring, finished or not
+type mostSteps struct{}
+
+func (mostSteps) Score(e ScoreEntry) (float64, bool) {
+	return float64(e.Steps), !e.DNF
+}
+
+func TestCustomScorerDrivesStandings(t *testing.T) {
+	l := scoredLobby(t)
+	l.Scorer = mostSteps{}
+	board := l.Scoreboard()
+	assertStandings(t, board, "fast", "frugal", "playing", "quitter")
+	setResult(l.Octapods["playing"], false, false, 0, 100)
+	assertStandings(t, l.Scoreboard(), "playing", "fast", "frugal", "quitter")
+	if winners := l.Scoreboard().Winners(); len(winners) != 0 {
+		t.Errorf("winners %v, the leader has not reached the exit", standings(winners))
+	}
+}
+
+func TestScorerByName(t *testing.T) {
+	if _, err := ScorerByName("Steps"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ScorerByName("luck"); err == nil {
+		t.Error("unknown scorer accepted")
+	}
+}
+
+func TestHandicapScalesEachPodByItsFactor(t *testing.T) {
+	l := newTestLobby(t, 9, 9, 1)
+	// The hard region takes longer to cross, so its pod is given twice the credit
+	setFor(t, &Handicaps, map[string]float64{"hard": 2, "easy": 0.5})
+	setResult(addPod(t, l, "hard"), true, false, 30, 30)
+	setResult(addPod(t, l, "easy"), true, false, 20, 20)
+	setResult(addPod(t, l, "plain"), true, false, 25, 25)
+
+	l.Scorer = TimeScorer{}
+	assertStandings(t, l.Scoreboard(), "easy", "plain", "hard")
+
+	l.Scorer = HandicapScorer{Scorer: TimeScorer{}}
+	board := l.Scoreboard()
+	assertStandings(t, board, "hard", "plain", "easy")
+	want := map[string]float64{"hard": -15, "plain": -25, "easy": -40}
+	for _, e := range board {
+		if e.Rating != want[e.Id] {
+			t.Errorf("%s rated %v with handicap %v, want %v", e.Id, e.Rating, e.Handicap, want[e.Id])
+		}
+	}
+
+	// Positive ratings are multiplied instead
+	l.Scorer = HandicapScorer{Scorer: mostSteps{}}
+	for _, e := range l.Scoreboard() {
+		if e.Rating != float64(e.Steps)*e.Handicap {
+			t.Errorf("%s rated %v, want %v", e.Id, e.Rating, float64(e.Steps)*e.Handicap)
+		}
+	}
+}
@@ -1,8 +1,115 @@
 package internal
 
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// EdgeAsWall reports the maze border like any other wall. When off, sensors also
+// carry a boundary marker for neighbours outside the grid.
+var EdgeAsWall = true
+
+// SensorRange caps the North/South/East/West readings, the number of open cells
+// before the nearest wall or the border. 1 matches the neighbour booleans.
+var SensorRange = 1
+
+// LineOfSight limits the window to cells in an unobstructed straight line from the
+// pod. A wall itself is seen, the window cells behind it are hidden.
+var LineOfSight = false
+
 type Sensor struct {
+	Left     bool        `json:"left"`
+	Right    bool        `json:"right"`
+	Up       bool        `json:"up"`
+	Down     bool        `json:"down"`
+	North    int         `json:"north"`
+	South    int         `json:"south"`
+	East     int         `json:"east"`
+	West     int         `json:"west"`
+	Trail    *Directions `json:"trail,omitempty"`    // Neighbours the pod recently visited, when SensorTrail is on
+	Boundary *Directions `json:"boundary,omitempty"` // Neighbours off the grid, when EdgeAsWall is off
+	Window   [][]bool    `json:"window,omitempty"`   // Cells around the pod indexed [dx+r][dy+r], true: wall, off the grid or hidden
+	Hidden   [][]bool    `json:"hidden,omitempty"`   // Window cells out of sight, when LineOfSight is on
+	Beacon   *int        `json:"beacon,omitempty"`   // Path length to the exit, give or take BeaconNoise
+	Smell    []int       `json:"smell,omitempty"`    // Manhattan distances to other pods within SmellRadius, nearest first
+	Team     *TeamSensor `json:"team,omitempty"`     // Teammates and what they saw, for pods in a team
+}
+
+type Directions struct {
 	Left  bool `json:"left"`
 	Right bool `json:"right"`
 	Up    bool `json:"up"`
 	Down  bool `json:"down"`
 }
+
+// SensorPackage selects what a lobby's pods sense, so rounds can offer difficulty tiers
+type SensorPackage struct {
+	Range       int  `json:"range"`       // Length of the North/South/East/West rays, 0 uses SensorRange
+	Window      int  `json:"window"`      // Radius of the grid window around the pod, 0 disables it
+	Beacon      bool `json:"beacon"`      // Report the distance to the exit
+	BeaconNoise int  `json:"beaconNoise"` // The beacon is off by up to this many cells either way
+	SmellRadius int  `json:"smellRadius"` // Report other pods this close, 0 disables smell
+}
+
+var SensorPackages = map[string]SensorPackage{
+	"basic":  {},
+	"rays":   {Range: 5},
+	"window": {Window: 2},
+	"beacon": {Beacon: true, BeaconNoise: 2},
+	"smell":  {SmellRadius: 4},
+	"full":   {Range: 5, Window: 2, Beacon: true, SmellRadius: 4},
+}
+
+var DefaultSensorPackage = "basic"
+
+func SensorPackageByName(name string) (SensorPackage, error) {
+	if p, exists := SensorPackages[strings.ToLower(name)]; exists {
+		return p, nil
+	}
+	names := make([]string, 0, len(SensorPackages))
+	for n := range SensorPackages {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return SensorPackage{}, errors.New("unknown sensor package " + name + ", expected one of " + strings.Join(names, ", "))
+}
+
+// sensorField is what the package's sensors need from the whole lobby, gathered once per tick
+type sensorField struct {
+	exitDistances map[Point]int
+	positions     map[string]Point      // Connected, unfinished pods
+	mates         map[string][]TeamMate // Connected, unfinished pods by team name
+}
+
+// sense builds the reading for a pod at p under the package, must hold the pod's lock
+func (pkg SensorPackage) sense(m *Maze, id string, p Point, field sensorField, rng *rand.Rand) *Sensor {
+	rays := pkg.Range
+	if rays <= 0 {
+		rays = SensorRange
+	}
+	s := m.GetSensorWithRange(p, rays)
+	if pkg.Window > 0 {
+		s.Window, s.Hidden = m.window(p, pkg.Window)
+	}
+	if pkg.Beacon {
+		if distance, reachable := field.exitDistances[p]; reachable {
+			if pkg.BeaconNoise > 0 {
+				distance += rng.Intn(2*pkg.BeaconNoise+1) - pkg.BeaconNoise
+				distance = max(distance, 0)
+			}
+			s.Beacon = &distance
+		}
+	}
+	if pkg.SmellRadius > 0 {
+		for other, q := range field.positions {
+			d := abs(q.X-p.X) + abs(q.Y-p.Y)
+			if other != id && d <= pkg.SmellRadius {
+				s.Smell = append(s.Smell, d)
+			}
+		}
+		sort.Ints(s.Smell)
+	}
+	return s
+}
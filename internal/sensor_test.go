@@ -0,0 +1,176 @@
+package internal
+
+import "testing"
+
+// roomWithWall is an open 7x5 room split by a wall at x=3 for y 1 to 3:
+//
+//	.......
+//	...#...
+//	...#...
+//	...#...
+//	.......
+func roomWithWall(t *testing.T) *Maze {
+	t.Helper()
+	walls := make([][]bool, 7)
+	for x := range walls {
+		walls[x] = make([]bool, 5)
+	}
+	for y := 1; y <= 3; y++ {
+		walls[3][y] = true
+	}
+	m, err := MazeFromMessage(MazeMessage{Width: 7, Height: 5, Walls: walls, Exit: Point{6, 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLineOfSightHidesCellsBehindWalls(t *testing.T) {
+	setFor(t, &LineOfSight, true)
+	const radius = 3
+	pod := Point{1, 2}
+	s := SensorPackage{Window: radius}.sense(roomWithWall(t), "a", pod, sensorField{}, nil)
+	if s.Hidden == nil {
+		t.Fatal("no hidden cells reported under LineOfSight")
+	}
+	at := func(cells [][]bool, p Point) bool {
+		return cells[p.X-pod.X+radius][p.Y-pod.Y+radius]
+	}
+
+	for _, p := range []Point{{4, 2}, {4, 1}, {4, 3}} {
+		if !at(s.Hidden, p) {
+			t.Errorf("%v behind the wall is visible", p)
+		}
+		if !at(s.Window, p) {
+			t.Errorf("%v behind the wall reads open", p)
+		}
+	}
+	for _, p := range []Point{{3, 2}, {3, 1}, {0, 2}, {1, 0}, {2, 4}, {3, 0}} {
+		if at(s.Hidden, p) {
+			t.Errorf("%v in plain sight is hidden", p)
+		}
+	}
+	if !at(s.Window, Point{3, 2}) {
+		t.Error("the wall itself reads open")
+	}
+	if at(s.Window, Point{2, 2}) {
+		t.Error("open cell before the wall reads as a wall")
+	}
+}
+
+func TestWindowSeesThroughWallsWithoutLineOfSight(t *testing.T) {
+	setFor(t, &LineOfSight, false)
+	const radius = 3
+	s := SensorPackage{Window: radius}.sense(roomWithWall(t), "a", Point{1, 2}, sensorField{}, nil)
+	if s.Hidden != nil {
+		t.Error("hidden cells reported without LineOfSight")
+	}
+	if s.Window[4-1+radius][2-2+radius] {
+		t.Error("open cell behind the wall reads as a wall")
+	}
+}
+
+func TestTrailFlagsCellJustLeft(t *testing.T) {
+	setFor(t, &SensorTrail, true)
+	l := newTestLobby(t, 9, 9, 1)
+	o := addPod(t, l, "pod")
+	l.Update()
+	s := takeSensor(o)
+	if s == nil {
+		t.Fatal("no sensor on the first tick")
+	}
+	if *s.Trail != (Directions{}) {
+		t.Errorf("trail %+v before moving, want none", *s.Trail)
+	}
+
+	var move, back Move
+	for i, m := range clockwise {
+		if openTowards(s, m) {
+			move, back = m, clockwise[(i+2)%len(clockwise)]
+			break
+		}
+	}
+	if move == "" {
+		t.Fatal("the entrance has no open neighbour")
+	}
+	o.move(move)
+	l.Update()
+	s = takeSensor(o)
+	if s == nil {
+		t.Fatal("no sensor after moving")
+	}
+	trail := map[Move]bool{Up: s.Trail.Up, Right: s.Trail.Right, Down: s.Trail.Down, Left: s.Trail.Left}
+	for m, visited := range trail {
+		if visited != (m == back) {
+			t.Errorf("trail %s is %v after moving %s", m, visited, move)
+		}
+	}
+
+	setFor(t, &SensorTrail, false)
+	l.Update()
+	if s = takeSensor(o); s == nil || s.Trail != nil {
+		t.Errorf("trail reported with SensorTrail off: %+v", s)
+	}
+}
+
+func TestEdgeRepresentation(t *testing.T) {
+	m := roomWithWall(t)
+	setFor(t, &EdgeAsWall, true)
+	s := m.GetSensorWithRange(Point{0, 0}, 1)
+	if s.Left || s.Up || s.Boundary != nil {
+		t.Errorf("corner with EdgeAsWall: %+v, want closed edges and no boundary marker", s)
+	}
+
+	setFor(t, &EdgeAsWall, false)
+	s = m.GetSensorWithRange(Point{0, 0}, 1)
+	if s.Left || s.Up {
+		t.Errorf("off-grid neighbours read as open: %+v", s)
+	}
+	if s.Boundary == nil || *s.Boundary != (Directions{Left: true, Up: true}) {
+		t.Errorf("corner boundary %+v, want left and up", s.Boundary)
+	}
+	// A wall inside the grid is not a boundary
+	s = m.GetSensorWithRange(Point{2, 2}, 1)
+	if s.Right || s.Boundary == nil || *s.Boundary != (Directions{}) {
+		t.Errorf("next to an inner wall: right open %v, boundary %+v", s.Right, s.Boundary)
+	}
+}
+
+func TestSensorRays(t *testing.T) {
+	room := roomWithWall(t)
+	// A dead end: a corridor along y=1 closed at x=4, walls above and below
+	msg := openMaze(5, 3)
+	for x := range msg.Walls {
+		msg.Walls[x][0], msg.Walls[x][2] = true, true
+	}
+	msg.Walls[4][1] = true
+	msg.Exit = Point{0, 1}
+	deadEnd, err := MazeFromMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name                     string
+		maze                     *Maze
+		at                       Point
+		rays                     int
+		north, south, east, west int
+	}{
+		{"open corridor", room, Point{1, 0}, 10, 0, 4, 5, 1},
+		{"open corridor capped", room, Point{1, 0}, 2, 0, 2, 2, 1},
+		{"against a wall", room, Point{2, 2}, 10, 2, 2, 0, 2},
+		{"far side of the wall", room, Point{4, 2}, 10, 2, 2, 2, 0},
+		{"dead end", deadEnd, Point{3, 1}, 10, 0, 0, 0, 3},
+		{"dead end default range", deadEnd, Point{3, 1}, 1, 0, 0, 0, 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := c.maze.GetSensorWithRange(c.at, c.rays)
+			got := [4]int{s.North, s.South, s.East, s.West}
+			if want := [4]int{c.north, c.south, c.east, c.west}; got != want {
+				t.Errorf("north, south, east, west = %v, want %v", got, want)
+			}
+		})
+	}
+}
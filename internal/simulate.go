@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Strategy is a bot's logic for one octapod. It gets the sensor pushed on a tick and
+// the pod's position, and returns the next move. An empty move skips the tick.
+type Strategy func(tick int64, position Point, sensor *Sensor) Move
+
+type SimPod struct {
+	Id       string
+	Team     string
+	Strategy Strategy
+}
+
+// Simulation runs the lobby loop without Gin, WebSockets or Discord. Ticks run back
+// to back, so thousands of them take seconds, and a fixed seed replays the same game.
+type Simulation struct {
+	Width, Height int
+	Seed          int64 // Random when 0, the result reports the one used
+	Ticks         int   // At most, the simulation stops once no pod is playing
+	Pods          []SimPod
+}
+
+type SimulationResult struct {
+	Seed       int64
+	MazeSeed   int64
+	Ticks      int64
+	Scoreboard ScoreBoard
+	Board      string
+}
+
+// simPassword authenticates the simulated pods, nothing can connect to them
+const simPassword = "simulation"
+
+// Run plays the simulation. Pods join in the given order and are asked for their
+// moves in ID order, under the same rate limit and collision rules as real bots.
+func (s Simulation) Run() (SimulationResult, error) {
+	if s.Width < 2 || s.Height < 2 {
+		return SimulationResult{}, fmt.Errorf("maze must be at least 2x2, got %dx%d", s.Width, s.Height)
+	}
+	if len(s.Pods) == 0 {
+		return SimulationResult{}, errors.New("a simulation needs at least one octapod")
+	}
+
+	lobby := newLobby(s.Width, s.Height, s.Seed, nil)
+	pods := make([]*Octapod, 0, len(s.Pods))
+	strategies := make(map[*Octapod]Strategy, len(s.Pods))
+	for _, p := range s.Pods {
+		if p.Strategy == nil {
+			return SimulationResult{}, fmt.Errorf("octapod %q has no strategy", p.Id)
+		}
+		o, err := lobby.identifyOctapod(&AuthMessage{ID: p.Id, Password: simPassword, Team: p.Team, Version: 1}, nil, nextConnId())
+		if err != nil {
+			return SimulationResult{}, fmt.Errorf("octapod %q: %w", p.Id, err)
+		}
+		pods = append(pods, o)
+		strategies[o] = p.Strategy
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Id < pods[j].Id })
+	if RoundMode {
+		// No countdown, the round runs until every pod is done or the ticks run out
+		lobby.setPhase(PhaseRunning, 0, nil)
+	}
+
+	for i := 0; i < s.Ticks && lobby.simulating(pods); i++ {
+		lobby.Update()
+		tick := lobby.tick.Load()
+		for _, o := range pods {
+			var sensor *Sensor
+			select {
+			case sensor = <-o.Sensor:
+			default:
+			}
+			if sensor == nil {
+				continue
+			}
+			o.Mutex.Lock()
+			position := pointOf(o.Position)
+			o.Mutex.Unlock()
+			move := strategies[o](tick, position, sensor)
+			if move == "" {
+				continue
+			}
+			if refusal, _ := o.checkMoveQuota(); refusal != nil {
+				continue
+			}
+			o.move(move)
+		}
+	}
+	if RoundMode {
+		lobby.FinishRound()
+	}
+
+	return SimulationResult{
+		Seed:       lobby.Seed,
+		MazeSeed:   lobby.mazeSeed(),
+		Ticks:      lobby.tick.Load(),
+		Scoreboard: lobby.Scoreboard(),
+		Board:      lobby.DisplayMaze(""),
+	}, nil
+}
+
+// simulating is true while some pod is connected and has not finished
+func (l *Lobby) simulating(pods []*Octapod) bool {
+	if l.Phase() != PhaseRunning {
+		return false
+	}
+	for _, o := range pods {
+		o.Mutex.Lock()
+		playing := o.connected() && !o.Finished
+		o.Mutex.Unlock()
+		if playing {
+			return true
+		}
+	}
+	return false
+}
+
+// clockwise lists the moves in turning order, so index+1 turns right
+var clockwise = []Move{Up, Right, Down, Left}
+
+func openTowards(s *Sensor, move Move) bool {
+	switch move {
+	case Up:
+		return s.Up
+	case Right:
+		return s.Right
+	case Down:
+		return s.Down
+	case Left:
+		return s.Left
+	}
+	return false
+}
+
+// WallFollower keeps its right hand on the wall, which reaches the exit of any maze
+// without loops
+func WallFollower() Strategy {
+	heading := 0
+	return func(tick int64, position Point, sensor *Sensor) Move {
+		// Right, straight on, left, then back the way it came
+		for _, turn := range []int{1, 0, 3, 2} {
+			next := (heading + turn) % len(clockwise)
+			if openTowards(sensor, clockwise[next]) {
+				heading = next
+				return clockwise[next]
+			}
+		}
+		return ""
+	}
+}
+
+// RandomWalk moves to a random open neighbour, seeded so runs repeat
+func RandomWalk(seed int64) Strategy {
+	r := rand.New(rand.NewSource(seed))
+	return func(tick int64, position Point, sensor *Sensor) Move {
+		var open []Move
+		for _, move := range clockwise {
+			if openTowards(sensor, move) {
+				open = append(open, move)
+			}
+		}
+		if len(open) == 0 {
+			return ""
+		}
+		return open[r.Intn(len(open))]
+	}
+}
+
+// Strategies are the built-in strategies the simulate command can run, by name
+var Strategies = map[string]func(seed int64) Strategy{
+	"wallfollower": func(int64) Strategy { return WallFollower() },
+	"random":       RandomWalk,
+}
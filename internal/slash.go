@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// minMazeSize keeps /newround options in line with MinMazeSize
+var minMazeSize = float64(MinMazeSize)
+
+// SlashCommands are registered in the bot's GuildId, or globally when it is unset.
+// Global commands can take up to an hour to show up in clients.
+var SlashCommands = []*discordgo.ApplicationCommand{
+	{
+		Name:        "board",
+		Description: "Show the maze, or only one octapod",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "Octapod ID"},
+		},
+	},
+	{
+		Name:        "standings",
+		Description: "Show the scoreboard of the current round",
+	},
+	{
+		Name:        "status",
+		Description: "Show the round, or one octapod",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "Octapod ID"},
+		},
+	},
+	{
+		Name:        "kick",
+		Description: "Disconnect an octapod (organizers only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "id", Description: "Octapod ID", Required: true},
+		},
+	},
+	{
+		Name:        "newround",
+		Description: "Disconnect everyone and start over on a new maze (organizers only)",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "width", Description: "Maze width, keeps the current one when unset", MinValue: &minMazeSize},
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "height", Description: "Maze height, keeps the current one when unset", MinValue: &minMazeSize},
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "seed", Description: "Maze seed, random when unset"},
+		},
+	},
+}
+
+// organizerCommands change the round and are limited to members with the bot's AdminRole
+var organizerCommands = map[string]bool{"kick": true, "newround": true}
+
+// registerCommands runs on every Ready, overwriting replaces commands left over from older builds
+func (d *DiscordBot) registerCommands(s *discordgo.Session, r *discordgo.Ready) {
+	if _, err := s.ApplicationCommandBulkOverwrite(r.User.ID, d.GuildId, SlashCommands); err != nil {
+		slog.Error("Failed to register slash commands", "err", err)
+	}
+}
+
+func (d *DiscordBot) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	data := i.ApplicationCommandData()
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(data.Options))
+	for _, option := range data.Options {
+		options[option.Name] = option
+	}
+
+	var reply string
+	var flags discordgo.MessageFlags
+	if organizerCommands[data.Name] && !d.isOrganizer(i.Member) {
+		reply = "Only organizers can use /" + data.Name + "."
+		flags = discordgo.MessageFlagsEphemeral
+	} else if d.Lobby == nil {
+		reply = "Lobby not initialized."
+	} else {
+		reply = d.slashCommand(data.Name, options)
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Content: truncateMessage(reply), Flags: flags},
+	})
+	if err != nil {
+		slog.Error("Error responding to a slash command", "command", data.Name, "err", err)
+	}
+}
+
+func (d *DiscordBot) slashCommand(name string, options map[string]*discordgo.ApplicationCommandInteractionDataOption) string {
+	id := ""
+	if option, ok := options["id"]; ok {
+		id = option.StringValue()
+	}
+
+	switch name {
+	case "board":
+		return d.Lobby.DisplayMaze(id)
+	case "standings":
+		return d.Lobby.Scoreboard().Render()
+	case "status":
+		if id == "" {
+			return d.Lobby.LobbyReport()
+		}
+		return d.Lobby.StatusReport(id)
+	case "kick":
+		if _, exists := d.Lobby.KickPod(id); !exists {
+			return "No such octapod [" + displayId(id) + "]."
+		}
+		return "Octapod [" + displayId(id) + "] kicked, it may reconnect with its password."
+	case "newround":
+		var width, height int
+		var seed int64
+		if option, ok := options["width"]; ok {
+			width = int(option.IntValue())
+		}
+		if option, ok := options["height"]; ok {
+			height = int(option.IntValue())
+		}
+		if option, ok := options["seed"]; ok {
+			seed = option.IntValue()
+		}
+		count, err := d.Lobby.ResizeRound(width, height, seed)
+		if err != nil {
+			return "Could not start a new round: " + err.Error()
+		}
+		return fmt.Sprintf("New round started on a new maze (maze seed %d), %d octapods disconnected.",
+			d.Lobby.mazeSeed(), count)
+	default:
+		return "Unknown command /" + name + "."
+	}
+}
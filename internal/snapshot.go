@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LobbySnapshot is an immutable copy of the lobby taken under a single set of locks,
+// so renders and exports never mix maze and pod states from different moments
+type LobbySnapshot struct {
+	Tick     int64       `json:"tick"`
+	Seed     int64       `json:"seed"`
+	MazeSeed int64       `json:"mazeSeed"`
+	Phase    Phase       `json:"phase"`
+	Maze     MazeMessage `json:"maze"`
+	Pods     []PodState  `json:"pods"`              // Sorted by ID
+	Pickups  []Pickup    `json:"pickups,omitempty"` // See EventPickup
+}
+
+// lockPods locks every pod in ID order. Code holding several pod locks must take
+// them this way, map order differs between callers and two of them could deadlock.
+// Must hold l.Mutex, the pods are returned sorted and unlock releases them.
+func (l *Lobby) lockPods() (pods []*Octapod, unlock func()) {
+	pods = make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		pods = append(pods, o)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Id < pods[j].Id })
+	for _, o := range pods {
+		o.Mutex.Lock()
+	}
+	return pods, func() {
+		for _, o := range pods {
+			o.Mutex.Unlock()
+		}
+	}
+}
+
+func (l *Lobby) Snapshot() LobbySnapshot {
+	l.Mutex.RLock()
+	defer l.Mutex.RUnlock()
+
+	// Hold every pod lock at once so no move lands halfway through the copy
+	pods, unlock := l.lockPods()
+	defer unlock()
+
+	snapshot := LobbySnapshot{
+		Tick:     l.tick.Load(),
+		Seed:     l.Seed,
+		MazeSeed: l.MazeSeed,
+		Phase:    l.Phase(),
+		Maze:     l.Maze.Message(),
+		Pods:     make([]PodState, 0, len(pods)),
+		Pickups:  l.pickups(),
+	}
+	for _, o := range pods {
+		snapshot.Pods = append(snapshot.Pods, o.state())
+	}
+	sort.Slice(snapshot.Pickups, func(i, j int) bool {
+		a, b := snapshot.Pickups[i].Position, snapshot.Pickups[j].Position
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+	return snapshot
+}
+
+// HandleState serves the snapshot as JSON for spectators, ?id= keeps only that pod
+// like DisplayMaze does
+func (l *Lobby) HandleState(c *gin.Context) {
+	snapshot := l.Snapshot()
+	if id := strings.ToLower(c.Query("id")); id != "" {
+		pods := make([]PodState, 0, 1)
+		for _, p := range snapshot.Pods {
+			if p.Id == id {
+				pods = append(pods, p)
+			}
+		}
+		snapshot.Pods = pods
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// Render draws the board as a Discord code block, showing only the pod with the given ID if set.
+// With teams and TeamColors on it is an ansi block, each team's tags in its own color.
+func (s LobbySnapshot) Render(id string) string {
+	octapodPositions := make(map[Point]PodState)
+	stacked := make(map[Point]int)
+	for _, p := range s.Pods {
+		if id == "" || p.Id == strings.ToLower(id) {
+			octapodPositions[p.Position] = p
+			stacked[p.Position]++
+		}
+	}
+
+	if len(octapodPositions) == 0 {
+		if id == "" {
+			return "No octapods in the lobby."
+		} else {
+			return "No octapods [" + displayId(id) + "] in the lobby."
+		}
+	}
+
+	colors := s.teamColors()
+	pickups := make(map[Point]Pickup, len(s.Pickups))
+	for _, p := range s.Pickups {
+		pickups[p.Position] = p
+	}
+	var result string
+	for y := 0; y < s.Maze.Height; y++ {
+		for x := 0; x < s.Maze.Width; x++ {
+			if s.Maze.Walls[x][y] {
+				result += "# " // Wall
+			} else if n := stacked[Point{x, y}]; n > 1 {
+				result += stackTag(n) // Several pods share the cell
+			} else if octapod, exists := octapodPositions[Point{x, y}]; exists && colors[octapod.Team] != "" {
+				result += colorize(renderTag(octapod.Tag), colors[octapod.Team])
+			} else if exists {
+				result += renderTag(octapod.Tag)
+			} else if pickup, exists := pickups[Point{x, y}]; exists {
+				result += pickupMarker(pickup.Kind)
+			} else {
+				result += "  "
+			}
+		}
+		result += "# \n"
+	}
+	for x := 0; x < s.Maze.Width; x++ {
+		result += "# "
+	}
+	result += "# \n"
+	if colors != nil {
+		return "```ansi\n" + result + "```"
+	}
+	return "```\n" + result + "```"
+}
+
+func pickupMarker(kind PickupKind) string {
+	if kind == PickupRange {
+		return "+ "
+	}
+	return "* "
+}
+
+func stackTag(n int) string {
+	if n > 9 {
+		return "++"
+	}
+	return strconv.Itoa(n) + "+"
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSnapshotSortsPods(t *testing.T) {
+	l := newTestLobby(t, 8, 8, 1)
+	for _, id := range []string{"c", "a", "b"} {
+		addPod(t, l, id)
+	}
+	snapshot := l.Snapshot()
+	if len(snapshot.Pods) != 3 {
+		t.Fatalf("got %d pods, want 3", len(snapshot.Pods))
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		if snapshot.Pods[i].Id != id {
+			t.Errorf("pod %d is %s, want %s", i, snapshot.Pods[i].Id, id)
+		}
+	}
+}
+
+func TestConcurrentSnapshots(t *testing.T) {
+	l := newTestLobby(t, 10, 10, 1)
+	for i := 0; i < 8; i++ {
+		addPod(t, l, fmt.Sprintf("pod%d", i))
+	}
+	within(t, 10*time.Second, func() {
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 2000; i++ {
+					l.Snapshot()
+				}
+			}()
+		}
+		// The tick loop and board renders run alongside snapshots in the server
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				l.Update()
+				l.DisplayMaze("")
+			}
+		}()
+		wg.Wait()
+	})
+}
+
+func TestTagsRenderDistinctly(t *testing.T) {
+	l := openRoomLobby(t)
+	octo := joinPod(t, l, AuthMessage{ID: "octo", Password: "secret", Version: 1, Tag: "O1"})
+	octo.move(Right)
+	octo.move(Right)
+	joinPod(t, l, AuthMessage{ID: "otter", Password: "secret", Version: 1, Tag: "O2"})
+
+	board := l.DisplayMaze("")
+	for _, tag := range []string{"O1", "O2"} {
+		if n := strings.Count(board, tag); n != 1 {
+			t.Errorf("tag %s drawn %d times:\n%s", tag, n, board)
+		}
+	}
+	if strings.Contains(board, "o ") {
+		t.Errorf("ID initials drawn instead of tags:\n%s", board)
+	}
+	frame := l.Frame()
+	tags := map[string]string{}
+	for _, p := range frame.Octapods {
+		tags[p.Id] = p.Tag
+	}
+	if tags["octo"] != "O1" || tags["otter"] != "O2" {
+		t.Errorf("frame tags %v", tags)
+	}
+
+	auth := AuthMessage{ID: "owl", Password: "secret", Version: 1, Tag: "O1"}
+	if _, err := l.identifyOctapod(&auth, nil, nextConnId()); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("joining with a taken tag = %v, want it refused", err)
+	}
+	auth = AuthMessage{ID: "owl", Password: "secret", Version: 1, Tag: "OWL"}
+	if _, err := l.identifyOctapod(&auth, nil, nextConnId()); err == nil {
+		t.Error("joining with a three character tag was accepted")
+	}
+}
+
+func TestDefaultTagsAvoidChosenOnes(t *testing.T) {
+	l := openRoomLobby(t)
+	joinPod(t, l, AuthMessage{ID: "squid", Password: "secret", Version: 1, Tag: "o"})
+	octo := addPod(t, l, "octo")
+	otter := addPod(t, l, "otter")
+	orca := joinPod(t, l, AuthMessage{ID: "orca", Password: "secret", Version: 1, Tag: "or"})
+	ox := addPod(t, l, "or")
+	if octo.Tag != "oc" || otter.Tag != "ot" || orca.Tag != "or" || ox.Tag != "o1" {
+		t.Errorf("default tags octo=%q otter=%q or=%q, want oc, ot and o1", octo.Tag, otter.Tag, ox.Tag)
+	}
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+var SpectatorBuffer = 16 // Messages queued per spectator, the oldest is dropped when full
+
+// SpectatorMessage is streamed to spectators: a full snapshot every tick and a
+// move for every accepted pod move in between
+type SpectatorMessage struct {
+	Type     string         `json:"type"` // "snapshot" or "move"
+	Tick     int64          `json:"tick"`
+	Snapshot *LobbySnapshot `json:"snapshot,omitempty"`
+	Pod      *PodFrame      `json:"pod,omitempty"`
+}
+
+type spectator struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+// spectatorHub fans messages out to spectators without ever blocking the caller.
+// Its mutex is a leaf lock, it may be taken while holding lobby or pod locks.
+type spectatorHub struct {
+	mutex      sync.Mutex
+	spectators map[*spectator]bool
+}
+
+func (h *spectatorHub) add(s *spectator) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.spectators == nil {
+		h.spectators = make(map[*spectator]bool)
+	}
+	h.spectators[s] = true
+}
+
+func (h *spectatorHub) remove(s *spectator) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.spectators[s] {
+		delete(h.spectators, s)
+		close(s.send)
+	}
+}
+
+func (h *spectatorHub) empty() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.spectators) == 0
+}
+
+func (h *spectatorHub) publish(msg SpectatorMessage) {
+	if h.empty() {
+		return
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		slog.Error("Error encoding spectator message", "err", err)
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for s := range h.spectators {
+		queue(s.send, b)
+	}
+}
+
+// queue sends without blocking, making room by dropping the oldest message
+func queue(send chan []byte, b []byte) {
+	for {
+		select {
+		case send <- b:
+			return
+		default:
+		}
+		select {
+		case <-send:
+		default:
+		}
+	}
+}
+
+// publishSnapshot streams the whole board to spectators, called once per tick
+func (l *Lobby) publishSnapshot() {
+	if l.spectators.empty() {
+		return
+	}
+	snapshot := l.Snapshot()
+	l.spectators.publish(SpectatorMessage{Type: "snapshot", Tick: snapshot.Tick, Snapshot: &snapshot})
+}
+
+// publishMove streams a single pod's new position, must hold o.Mutex
+func (l *Lobby) publishMove(o *Octapod) {
+	l.spectators.publish(SpectatorMessage{
+		Type: "move",
+		Tick: l.tick.Load(),
+		Pod:  &PodFrame{Id: o.Id, Tag: o.Tag, Position: pointOf(o.Position)},
+	})
+}
+
+// HandleSpectate upgrades to a read-only websocket that streams the board
+func (l *Lobby) HandleSpectate(c *gin.Context) {
+	upgrader := newUpgrader()
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		l.metrics.websocketErrors.WithLabelValues("upgrade").Inc()
+		l.logger().Warn("Spectator websocket upgrade failed", "err", err)
+		return
+	}
+	l.logger().Info("Spectator connected", "remote", c.ClientIP())
+
+	// Queue the current board before joining the hub, which owns closing send
+	s := &spectator{conn: conn, send: make(chan []byte, SpectatorBuffer)}
+	snapshot := l.Snapshot()
+	if b, err := json.Marshal(SpectatorMessage{Type: "snapshot", Tick: snapshot.Tick, Snapshot: &snapshot}); err == nil {
+		queue(s.send, b)
+	}
+	l.spectators.add(s)
+
+	go func() {
+		for b := range s.send {
+			conn.SetWriteDeadline(time.Now().Add(TimeoutInterval))
+			if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+				break
+			}
+		}
+		l.spectators.remove(s)
+		conn.Close()
+	}()
+
+	// Spectators only listen, reading just notices when they leave
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				l.spectators.remove(s)
+				l.logger().Info("Spectator disconnected")
+				return
+			}
+		}
+	}()
+}
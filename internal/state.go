@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/quartercastle/vector"
+)
+
+var StatePath = "" // Empty disables saving the lobby on shutdown and restoring it on startup
+
+// SavedLobby is everything needed to resume a lobby after a restart. Pods come back
+// disconnected and resume by reconnecting with their password.
+type SavedLobby struct {
+	Lobby    string      `json:"lobby,omitempty"` // Manager ID, empty for a lobby saved on its own
+	SavedAt  time.Time   `json:"savedAt"`
+	Tick     int64       `json:"tick"`
+	Seed     int64       `json:"seed"`
+	MazeSeed int64       `json:"mazeSeed"`
+	Phase    Phase       `json:"phase"`
+	Maze     MazeMessage `json:"maze"`
+	Pods     []SavedPod  `json:"pods"`
+}
+
+type SavedPod struct {
+	Id             string       `json:"id"`
+	Tag            string       `json:"tag"`
+	Team           string       `json:"team,omitempty"`
+	HashedPassword string       `json:"hashedPassword"`
+	Position       Point        `json:"position"`
+	InactiveCount  int          `json:"inactiveCount"`
+	IllegalMoves   int          `json:"illegalMoves"`
+	Steps          int          `json:"steps"`
+	Score          int          `json:"score"`
+	TickMultiplier int          `json:"tickMultiplier"`
+	Disconnects    int          `json:"disconnects"`
+	Finished       bool         `json:"finished"`
+	DNF            bool         `json:"dnf"`
+	JoinedAt       time.Time    `json:"joinedAt"`
+	JoinedTick     int64        `json:"joinedTick"`
+	FinishTicks    int64        `json:"finishTicks"`
+	FinishedAt     time.Time    `json:"finishedAt"`
+	Discovered     []Point      `json:"discovered"`
+	History        []ReplayStep `json:"history"`
+}
+
+// SaveState writes the lobby to path, through a temporary file so a crash never
+// leaves half a state behind
+func (l *Lobby) SaveState(path string) error {
+	return l.saveState(path, "")
+}
+
+func (l *Lobby) saveState(path, id string) error {
+	l.Mutex.RLock()
+	pods, unlock := l.lockPods()
+	saved := SavedLobby{
+		Lobby:    id,
+		SavedAt:  time.Now(),
+		Tick:     l.tick.Load(),
+		Seed:     l.Seed,
+		MazeSeed: l.MazeSeed,
+		Phase:    l.Phase(),
+		Maze:     l.Maze.Message(),
+		Pods:     make([]SavedPod, 0, len(pods)),
+	}
+	for _, o := range pods {
+		saved.Pods = append(saved.Pods, o.saved())
+	}
+	unlock()
+	l.Mutex.RUnlock()
+
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err := temp.Write(b); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return err
+	}
+	l.logger().Info("Saved lobby state", "octapods", len(saved.Pods), "path", path)
+	return nil
+}
+
+// saved must hold o.Mutex
+func (o *Octapod) saved() SavedPod {
+	discovered := make([]Point, 0, len(o.discovered))
+	for p := range o.discovered {
+		discovered = append(discovered, p)
+	}
+	return SavedPod{
+		Id:             o.Id,
+		Tag:            o.Tag,
+		Team:           o.Team,
+		HashedPassword: o.HashedPassword,
+		Position:       pointOf(o.Position),
+		InactiveCount:  o.InactiveCount,
+		IllegalMoves:   o.IllegalMoves,
+		Steps:          o.Steps,
+		Score:          o.Score,
+		TickMultiplier: o.TickMultiplier,
+		Disconnects:    o.Disconnects,
+		Finished:       o.Finished,
+		DNF:            o.DNF,
+		JoinedAt:       o.JoinedAt,
+		JoinedTick:     o.joinedTick,
+		FinishTicks:    o.FinishTicks,
+		FinishedAt:     o.FinishedAt,
+		Discovered:     discovered,
+		History:        append([]ReplayStep(nil), o.history...),
+	}
+}
+
+// RestoreState replaces the lobby's maze and pods with the state saved at path.
+// Connected pods are kicked, so restore before serving joins.
+func (l *Lobby) RestoreState(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var saved SavedLobby
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	maze, err := MazeFromMessage(saved.Maze)
+	if err != nil {
+		return fmt.Errorf("saved maze: %w", err)
+	}
+	if err := maze.Validate(); err != nil {
+		return fmt.Errorf("saved maze: %w", err)
+	}
+	// Check every pod first, a bad file must leave the running lobby untouched
+	ids := make(map[string]bool, len(saved.Pods))
+	for _, p := range saved.Pods {
+		if p.Id == "" {
+			return errors.New("saved octapod without an ID")
+		}
+		if ids[p.Id] {
+			return fmt.Errorf("saved octapod %s appears twice", p.Id)
+		}
+		ids[p.Id] = true
+		if !maze.isOpen(p.Position) {
+			return fmt.Errorf("saved octapod %s is at (%d,%d), outside the maze or in a wall", p.Id, p.Position.X, p.Position.Y)
+		}
+	}
+
+	l.Mutex.Lock()
+	previous := make([]*Octapod, 0, len(l.Octapods))
+	for _, o := range l.Octapods {
+		previous = append(previous, o)
+	}
+	l.Maze = maze
+	l.Seed = saved.Seed
+	l.MazeSeed = saved.MazeSeed
+	// Random draws follow the restored seeds, so a restored game replays the same way
+	l.mazeRand = rand.New(rand.NewSource(saved.MazeSeed))
+	l.chaosRand = newRand(saved.Seed, "chaos")
+	l.sensorRand = newRand(saved.Seed, "sensor")
+	l.tick.Store(saved.Tick)
+	l.Octapods = make(map[string]*Octapod, len(saved.Pods))
+	l.teams = nil
+	l.occupyMutex.Lock()
+	l.occupied = make(map[Point]*Octapod)
+	l.occupyMutex.Unlock()
+	for _, p := range saved.Pods {
+		o := l.restoredOctapod(p)
+		l.Octapods[o.Id] = o
+		l.joinTeam(o)
+		if o.team != nil {
+			o.team.share(maze, p.Discovered)
+		}
+		if !o.Finished {
+			l.occupy(o, p.Position, p.Position)
+		}
+	}
+	l.Mutex.Unlock()
+
+	for _, o := range previous {
+		o.Kick(websocket.CloseServiceRestart, "Server restored a saved lobby")
+	}
+	l.revokeTokens()
+	l.resetEvents()
+	l.restorePhase(saved.Phase)
+	l.openMatch()
+	l.Mutex.RLock()
+	for _, o := range l.Octapods {
+		o.Mutex.Lock()
+		o.recordMatch(MatchJoin, nil)
+		o.Mutex.Unlock()
+	}
+	l.Mutex.RUnlock()
+	l.logger().Info("Restored lobby state", "octapods", len(saved.Pods), "path", path, "savedAt", saved.SavedAt)
+	return nil
+}
+
+func (l *Lobby) restoredOctapod(p SavedPod) *Octapod {
+	o := newOctapod(p.Id, p.HashedPassword, nil, l)
+	o.Tag = p.Tag
+	o.Team = p.Team
+	o.Position = vector.Vector{float64(p.Position.X), float64(p.Position.Y)}
+	o.InactiveCount = p.InactiveCount
+	o.IllegalMoves = p.IllegalMoves
+	o.Steps = p.Steps
+	o.Score = p.Score
+	o.TickMultiplier = clampTickMultiplier(p.TickMultiplier)
+	o.Disconnects = p.Disconnects
+	o.Finished = p.Finished
+	o.DNF = p.DNF
+	o.JoinedAt = p.JoinedAt
+	o.joinedTick = p.JoinedTick
+	o.FinishTicks = p.FinishTicks
+	o.FinishedAt = p.FinishedAt
+	o.trail = nil
+	o.visit(o.Position)
+	for _, cell := range p.Discovered {
+		o.discovered[cell] = true
+	}
+	if len(p.History) > 0 {
+		o.history = p.History
+	}
+	return o
+}
+
+// statePath is where the lobby with the given ID saves its state, e.g.
+// state-practice.json for state.json. StatePath for the main lobby.
+func statePath(id string) string {
+	if StatePath == "" || id == "" {
+		return StatePath
+	}
+	ext := filepath.Ext(StatePath)
+	return strings.TrimSuffix(StatePath, ext) + "-" + safeFileName(id) + ext
+}
+
+// SaveStates pauses and saves every lobby, the main one to StatePath and the others
+// next to it, see statePath
+func (m *LobbyManager) SaveStates() error {
+	m.mutex.RLock()
+	lobbies := make(map[string]*Lobby, len(m.lobbies))
+	for id, lobby := range m.lobbies {
+		lobbies[id] = lobby
+	}
+	main := m.DiscordBot.Lobby
+	m.mutex.RUnlock()
+
+	var errs []error
+	for id, lobby := range lobbies {
+		path := statePath(id)
+		if lobby == main {
+			path = StatePath
+		}
+		lobby.Pause()
+		if err := lobby.saveState(path, id); err != nil {
+			errs = append(errs, fmt.Errorf("lobby %s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RestoreStates recreates the lobbies SaveStates saved besides the main one, which
+// is restored on its own before serving. Restored files are kept with a .restored
+// suffix so a crash never restores them twice.
+func (m *LobbyManager) RestoreStates() error {
+	ext := filepath.Ext(StatePath)
+	paths, err := filepath.Glob(strings.TrimSuffix(StatePath, ext) + "-*" + ext)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range paths {
+		if err := m.restoreLobby(path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		_ = os.Rename(path, path+".restored")
+	}
+	return errors.Join(errs...)
+}
+
+func (m *LobbyManager) restoreLobby(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var saved SavedLobby
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	if saved.Lobby == "" {
+		return errors.New("saved state has no lobby ID")
+	}
+	lobby, err := m.CreateLobby(saved.Lobby, saved.Maze.Width, saved.Maze.Height)
+	if err != nil {
+		return err
+	}
+	if err := lobby.RestoreState(path); err != nil {
+		_ = m.RemoveLobby(saved.Lobby)
+		return err
+	}
+	return nil
+}
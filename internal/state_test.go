@@ -0,0 +1,232 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSaveAndRestoreState(t *testing.T) {
+	l := newTestLobby(t, 8, 8, 3)
+	addPod(t, l, "alpha")
+	addPod(t, l, "beta")
+	for i := 0; i < 3; i++ {
+		l.Update()
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := l.SaveState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := newTestLobby(t, 8, 8, 99)
+	if err := restored.RestoreState(path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.MazeSeed != l.MazeSeed || restored.tick.Load() != l.tick.Load() {
+		t.Errorf("restored maze seed %d tick %d, want %d and %d",
+			restored.MazeSeed, restored.tick.Load(), l.MazeSeed, l.tick.Load())
+	}
+	if restored.Maze.Print() != l.Maze.Print() {
+		t.Error("restored maze differs")
+	}
+	for _, id := range []string{"alpha", "beta"} {
+		o, exists := restored.Octapods[id]
+		if !exists {
+			t.Fatalf("%s not restored", id)
+		}
+		if o.connected() {
+			t.Errorf("%s restored as connected, it must reconnect", id)
+		}
+		if !o.VerifyPassword("secret") {
+			t.Errorf("%s lost its password", id)
+		}
+	}
+}
+
+func TestMazeThemeIsExported(t *testing.T) {
+	setFor(t, &MazeTheme, "ocean")
+	l := newTestLobby(t, 8, 8, 3)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/state", l.HandleState)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/state", nil))
+	var snapshot LobbySnapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Maze.Theme != "ocean" || snapshot.Maze.Palette != Themes["ocean"] {
+		t.Errorf("state exports theme %q palette %+v, want ocean", snapshot.Maze.Theme, snapshot.Maze.Palette)
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := l.SaveState(path); err != nil {
+		t.Fatal(err)
+	}
+	var saved SavedLobby
+	if data, err := os.ReadFile(path); err != nil || json.Unmarshal(data, &saved) != nil || saved.Maze.Theme != "ocean" {
+		t.Fatalf("saved maze theme %q, want ocean", saved.Maze.Theme)
+	}
+	MazeTheme = NeutralTheme
+	restored := newTestLobby(t, 8, 8, 99)
+	if err := restored.RestoreState(path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Maze.Theme != "ocean" {
+		t.Errorf("restored theme %q, want the saved ocean", restored.Maze.Theme)
+	}
+
+	// Mazes saved before themes existed come back neutral
+	saved.Maze.Theme = ""
+	maze, err := MazeFromMessage(saved.Maze)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := maze.Message(); message.Theme != NeutralTheme || message.Palette != Themes[NeutralTheme] {
+		t.Errorf("unthemed maze exports theme %q palette %+v, want neutral", message.Theme, message.Palette)
+	}
+}
+
+func TestConcurrentSaveState(t *testing.T) {
+	l := newTestLobby(t, 10, 10, 1)
+	for i := 0; i < 8; i++ {
+		addPod(t, l, fmt.Sprintf("pod%d", i))
+	}
+	dir := t.TempDir()
+	within(t, 20*time.Second, func() {
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				path := filepath.Join(dir, fmt.Sprintf("state%d.json", g))
+				for i := 0; i < 200; i++ {
+					if err := l.SaveState(path); err != nil {
+						t.Error(err)
+						return
+					}
+					l.Snapshot()
+				}
+			}()
+		}
+		wg.Wait()
+	})
+}
+
+func TestRestoreStateRejectsBadPodsUntouched(t *testing.T) {
+	saved := newTestLobby(t, 8, 8, 3)
+	addPod(t, saved, "alpha")
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saved.SaveState(path); err != nil {
+		t.Fatal(err)
+	}
+	walls := saved.Maze.Walls()
+	var wall Point
+	for x := range walls {
+		for y := range walls[x] {
+			if walls[x][y] {
+				wall = Point{x, y}
+			}
+		}
+	}
+
+	for name, corrupt := range map[string]func(*SavedLobby){
+		"empty id":   func(s *SavedLobby) { s.Pods[0].Id = "" },
+		"in a wall":  func(s *SavedLobby) { s.Pods[0].Position = wall },
+		"off bounds": func(s *SavedLobby) { s.Pods[0].Position = Point{-1, 0} },
+		"duplicate":  func(s *SavedLobby) { s.Pods = append(s.Pods, s.Pods[0]) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var state SavedLobby
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(b, &state); err != nil {
+				t.Fatal(err)
+			}
+			corrupt(&state)
+			bad := filepath.Join(t.TempDir(), "bad.json")
+			b, _ = json.Marshal(state)
+			if err := os.WriteFile(bad, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			l := newTestLobby(t, 6, 6, 9)
+			addPod(t, l, "running")
+			maze := l.Maze
+			if err := l.RestoreState(bad); err == nil {
+				t.Fatal("corrupt state was restored")
+			}
+			if l.Maze != maze || l.Octapods["running"] == nil || len(l.Octapods) != 1 {
+				t.Error("a rejected restore changed the lobby")
+			}
+		})
+	}
+}
+
+func TestRestoreStateReseedsRandomness(t *testing.T) {
+	l := newTestLobby(t, 8, 8, 3)
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := l.SaveState(path); err != nil {
+		t.Fatal(err)
+	}
+	var draws []int64
+	for _, seed := range []int64{11, 12} {
+		restored := newTestLobby(t, 8, 8, seed)
+		if err := restored.RestoreState(path); err != nil {
+			t.Fatal(err)
+		}
+		draws = append(draws, restored.chaosRand.Int63(), restored.sensorRand.Int63(), restored.mazeRand.Int63())
+	}
+	for i := 0; i < 3; i++ {
+		if draws[i] != draws[i+3] {
+			t.Errorf("draw %d differs between restores: %d and %d", i, draws[i], draws[i+3])
+		}
+	}
+}
+
+func TestManagerSavesAndRestoresEveryLobby(t *testing.T) {
+	setFor(t, &StatePath, filepath.Join(t.TempDir(), "state.json"))
+	m := newTestManager(t)
+	if err := m.AddLobby("main", newTestLobby(t, 8, 8, 1)); err != nil {
+		t.Fatal(err)
+	}
+	practice, err := m.CreateLobby("practice", 7, 9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addPod(t, practice, "trainee")
+	if err := m.SaveStates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(StatePath); err != nil {
+		t.Errorf("main lobby not saved: %v", err)
+	}
+
+	restarted := newTestManager(t)
+	if err := restarted.AddLobby("main", newTestLobby(t, 8, 8, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.RestoreStates(); err != nil {
+		t.Fatal(err)
+	}
+	restored, exists := restarted.GetLobby("practice")
+	if !exists {
+		t.Fatal("practice lobby not restored")
+	}
+	if restored.Maze.Print() != practice.Maze.Print() || restored.Octapods["trainee"] == nil {
+		t.Error("practice lobby restored with a different maze or without its pod")
+	}
+	if _, err := os.Stat(statePath("practice") + ".restored"); err != nil {
+		t.Errorf("restored state not set aside: %v", err)
+	}
+}
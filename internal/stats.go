@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LobbyStats are updated with atomics so any goroutine can record events without lobby locks
+type LobbyStats struct {
+	joins        atomic.Int64
+	disconnects  atomic.Int64
+	updates      atomic.Int64
+	updatesNanos atomic.Int64
+}
+
+type StatsMessage struct {
+	ActivePods        int        `json:"activePods"`
+	Ticks             int64      `json:"ticks"`
+	AverageTickMillis float64    `json:"averageTickMillis"`
+	Joins             int64      `json:"joins"`
+	Disconnects       int64      `json:"disconnects"`
+	Pods              []PodState `json:"pods"`
+}
+
+func (s *LobbyStats) recordUpdate(duration time.Duration) {
+	s.updates.Add(1)
+	s.updatesNanos.Add(int64(duration))
+}
+
+func (l *Lobby) Stats() StatsMessage {
+	snapshot := l.Snapshot()
+	active := 0
+	for _, p := range snapshot.Pods {
+		if p.Connected && !p.Finished {
+			active++
+		}
+	}
+
+	average := 0.0
+	if updates := l.stats.updates.Load(); updates > 0 {
+		average = float64(l.stats.updatesNanos.Load()) / float64(updates) / float64(time.Millisecond)
+	}
+
+	return StatsMessage{
+		ActivePods:        active,
+		Ticks:             snapshot.Tick,
+		AverageTickMillis: average,
+		Joins:             l.stats.joins.Load(),
+		Disconnects:       l.stats.disconnects.Load(),
+		Pods:              snapshot.Pods,
+	}
+}
+
+func (l *Lobby) HandleStatsJSON(c *gin.Context) {
+	c.JSON(http.StatusOK, l.Stats())
+}
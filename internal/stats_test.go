@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStatsJSONKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	l := newTestLobby(t, 9, 9, 1)
+	addPod(t, l, "counted")
+	addPod(t, l, "leaver").Disconnect()
+	l.Update()
+	l.stats.recordUpdate(4 * time.Millisecond)
+
+	router := gin.New()
+	router.GET("/stats", l.HandleStatsJSON)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var stats map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"activePods":        1.0,
+		"ticks":             1.0,
+		"averageTickMillis": 4.0,
+		"joins":             2.0,
+		"disconnects":       1.0,
+	} {
+		if stats[key] != want {
+			t.Errorf("%s = %v, want %v", key, stats[key], want)
+		}
+	}
+	pods, _ := stats["pods"].([]any)
+	if len(pods) != 2 {
+		t.Fatalf("pods %v, want both pods summarised", stats["pods"])
+	}
+	for _, pod := range pods {
+		for _, key := range []string{"id", "position", "connected", "steps", "droppedTicks"} {
+			if _, ok := pod.(map[string]any)[key]; !ok {
+				t.Errorf("pod summary %v has no %q", pod, key)
+			}
+		}
+	}
+}
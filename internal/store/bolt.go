@@ -0,0 +1,73 @@
+package store
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var resultsBucket = []byte("results")
+
+// BoltStore keeps results in a single BoltDB file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(resultsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func resultKey(id string, seed int64) []byte {
+	return []byte(id + "/" + strconv.FormatInt(seed, 10))
+}
+
+func (s *BoltStore) Save(result Result) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).Put(resultKey(result.Id, result.Seed), b)
+	})
+}
+
+// Leaderboard returns the best results first, all of them when limit is 0
+func (s *BoltStore) Leaderboard(limit int) ([]Result, error) {
+	var results []Result
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(resultsBucket).ForEach(func(_, v []byte) error {
+			var r Result
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			results = append(results, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	Rank(results)
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
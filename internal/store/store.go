@@ -0,0 +1,52 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// Result is one octapod's outcome on one maze, identified by pod ID and maze seed
+type Result struct {
+	Id           string    `json:"id"`
+	Seed         int64     `json:"seed"`
+	Team         string    `json:"team,omitempty"`
+	Steps        int       `json:"steps"`
+	Finished     bool      `json:"finished"`
+	DNF          bool      `json:"dnf"`
+	CompletionMs int64     `json:"completionMs,omitempty"`
+	Disconnects  int       `json:"disconnects"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Store keeps results across restarts. Save replaces the result for the same pod
+// and seed, so it can be called on every disconnect and again on completion.
+type Store interface {
+	Save(result Result) error
+	Leaderboard(limit int) ([]Result, error)
+	Close() error
+}
+
+// Rank sorts results best first: finished runs by completion time, then unfinished
+// runs, then forfeits, with fewer steps breaking ties
+func Rank(results []Result) {
+	group := func(r Result) int {
+		switch {
+		case r.DNF:
+			return 2
+		case r.Finished:
+			return 0
+		default:
+			return 1
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if group(a) != group(b) {
+			return group(a) < group(b)
+		}
+		if group(a) == 0 && a.CompletionMs != b.CompletionMs {
+			return a.CompletionMs < b.CompletionMs
+		}
+		return a.Steps < b.Steps
+	})
+}
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+var MaxTeamNameLength = 32
+
+// TeamColors renders boards as Discord ansi code blocks with one color per team
+var TeamColors = true
+
+// teamPalette holds the ANSI foreground colors Discord renders, assigned to teams by name order
+var teamPalette = []string{"31", "32", "33", "34", "35", "36"}
+
+// team is the vision shared by its members. Its mutex is a leaf lock, taken while
+// holding a member's lock.
+type team struct {
+	name  string
+	mutex sync.Mutex
+	seen  map[Point]bool
+	cells []SeenCell // In the order they were first seen, members keep a cursor into it
+}
+
+// SeenCell is a cell some member of the team has sensed
+type SeenCell struct {
+	X    int  `json:"x"`
+	Y    int  `json:"y"`
+	Wall bool `json:"wall"`
+}
+
+type TeamMate struct {
+	Id       string `json:"id"`
+	Position Point  `json:"position"`
+}
+
+// TeamSensor is the team's part of a sensor reading
+type TeamSensor struct {
+	Name  string     `json:"name"`
+	Mates []TeamMate `json:"mates"` // Connected, unfinished teammates
+	Cells []SeenCell `json:"cells"` // Cells seen by the team, the pod included, since its last reading
+}
+
+func validTeam(name string) bool {
+	if name == "" || utf8.RuneCountInString(name) > MaxTeamNameLength || strings.TrimSpace(name) != name {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// joinTeam adds the pod to its team, creating the team on first use. Must hold l.Mutex.
+func (l *Lobby) joinTeam(o *Octapod) {
+	if o.Team == "" {
+		return
+	}
+	key := strings.ToLower(o.Team)
+	t, exists := l.teams[key]
+	if !exists {
+		if l.teams == nil {
+			l.teams = make(map[string]*team)
+		}
+		t = &team{name: o.Team, seen: make(map[Point]bool)}
+		l.teams[key] = t
+	}
+	o.Team = t.name // The first member's spelling wins
+	o.team = t
+	o.teamCursor = 0
+}
+
+// share adds cells to the team's vision, must hold the member's lock
+func (t *team) share(m *Maze, cells []Point) {
+	m.mutex.RLock()
+	seen := make([]SeenCell, 0, len(cells))
+	for _, cell := range cells {
+		seen = append(seen, SeenCell{X: cell.X, Y: cell.Y, Wall: !m.isOpen(cell)})
+	}
+	m.mutex.RUnlock()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, cell := range seen {
+		p := Point{cell.X, cell.Y}
+		if !t.seen[p] {
+			t.seen[p] = true
+			t.cells = append(t.cells, cell)
+		}
+	}
+}
+
+// teamSensor reports the teammates and the cells the team saw since the pod's last
+// reading, nil when the pod has no team. Must hold o.Mutex.
+func (o *Octapod) teamSensor(field sensorField) *TeamSensor {
+	if o.team == nil {
+		return nil
+	}
+	s := &TeamSensor{Name: o.team.name, Mates: []TeamMate{}}
+	for _, mate := range field.mates[o.team.name] {
+		if mate.Id != o.Id {
+			s.Mates = append(s.Mates, mate)
+		}
+	}
+	o.team.mutex.Lock()
+	s.Cells = append([]SeenCell{}, o.team.cells[o.teamCursor:]...)
+	o.teamCursor = len(o.team.cells)
+	o.team.mutex.Unlock()
+	return s
+}
+
+// teamColors maps each team on the board to a palette color, nil when colors are off
+func (s LobbySnapshot) teamColors() map[string]string {
+	if !TeamColors {
+		return nil
+	}
+	var names []string
+	for _, p := range s.Pods {
+		if p.Team != "" && !slices.Contains(names, p.Team) {
+			names = append(names, p.Team)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	colors := make(map[string]string, len(names))
+	for i, name := range names {
+		colors[name] = teamPalette[i%len(teamPalette)]
+	}
+	return colors
+}
+
+func colorize(cell, color string) string {
+	return "\u001b[1;" + color + "m" + cell + "\u001b[0m"
+}
+
+// TeamScore aggregates a team's members on the scoreboard
+type TeamScore struct {
+	Team       string   `json:"team"`
+	Members    []string `json:"members"` // Pod IDs, in scoreboard order
+	Finished   int      `json:"finished"`
+	TotalTicks int64    `json:"totalTicks"` // Summed over the members that reached the exit
+	Score      int      `json:"score"`
+	Steps      int      `json:"steps"`
+}
+
+// Teams ranks teams by most members at the exit, then fewest summed ticks, then
+// pickup score, then fewest steps
+func (b ScoreBoard) Teams() []TeamScore {
+	byName := make(map[string]*TeamScore)
+	var teams []*TeamScore
+	for _, e := range b {
+		if e.Team == "" {
+			continue
+		}
+		t, exists := byName[e.Team]
+		if !exists {
+			t = &TeamScore{Team: e.Team}
+			byName[e.Team] = t
+			teams = append(teams, t)
+		}
+		t.Members = append(t.Members, e.Id)
+		t.Steps += e.Steps
+		t.Score += e.Score
+		if e.Finished && !e.DNF {
+			t.Finished++
+			t.TotalTicks += e.FinishTicks
+		}
+	}
+	sort.SliceStable(teams, func(i, j int) bool {
+		a, b := teams[i], teams[j]
+		if a.Finished != b.Finished {
+			return a.Finished > b.Finished
+		}
+		if a.TotalTicks != b.TotalTicks {
+			return a.TotalTicks < b.TotalTicks
+		}
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Steps < b.Steps
+	})
+	scores := make([]TeamScore, 0, len(teams))
+	for _, t := range teams {
+		scores = append(scores, *t)
+	}
+	return scores
+}
+
+func renderTeams(teams []TeamScore) string {
+	lines := make([]string, 0, len(teams)+1)
+	lines = append(lines, "Teams:")
+	for i, t := range teams {
+		status := fmt.Sprintf("%d/%d at the exit", t.Finished, len(t.Members))
+		if t.Finished > 0 {
+			status += fmt.Sprintf(" in %d ticks", t.TotalTicks)
+		}
+		lines = append(lines, fmt.Sprintf("%d. [%s] %s, %d steps taken", i+1, displayId(t.Team), status, t.Steps))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (l *Lobby) HandleTeamScoreboard(c *gin.Context) {
+	c.JSON(http.StatusOK, l.Scoreboard().Teams())
+}
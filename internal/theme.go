@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// Palette holds the colours a maze is drawn in, as CSS hex strings. The board
+// viewer reads it; the ASCII boards posted to Discord are uncoloured.
+type Palette struct {
+	Wall  string `json:"wall"`
+	Floor string `json:"floor"`
+}
+
+// NeutralTheme is the plain grey look mazes had before themes, and the theme of
+// mazes saved without one
+const NeutralTheme = "neutral"
+
+var Themes = map[string]Palette{
+	NeutralTheme: {Wall: "#4e5058", Floor: "#2b2d31"},
+	"ocean":      {Wall: "#1f4e79", Floor: "#0b2239"},
+	"forest":     {Wall: "#3e6b34", Floor: "#1c2b18"},
+	"lava":       {Wall: "#8c2f1b", Floor: "#2a1410"},
+}
+
+// MazeTheme is given to new mazes
+var MazeTheme = NeutralTheme
+
+func ThemeByName(name string) (Palette, error) {
+	if p, exists := Themes[strings.ToLower(name)]; exists {
+		return p, nil
+	}
+	names := make([]string, 0, len(Themes))
+	for n := range Themes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return Palette{}, errors.New("unknown maze theme " + name + ", expected one of " + strings.Join(names, ", "))
+}
+
+// themePalette is the theme's palette, the neutral one for themes no longer known
+func themePalette(name string) Palette {
+	if p, err := ThemeByName(name); err == nil {
+		return p
+	}
+	return Themes[NeutralTheme]
+}
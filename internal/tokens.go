@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"time"
+)
+
+// ReconnectTokenTTL is how long a dropped octapod can resume with its token
+var ReconnectTokenTTL = 2 * time.Minute
+
+// sessionToken is the only token a pod can resume with. Only its hash is kept.
+type sessionToken struct {
+	hash    [32]byte
+	expires time.Time // Zero while the session is connected
+}
+
+// issueToken replaces the pod's token with a fresh one. Tokens are single use, every
+// connection gets a new one.
+func (l *Lobby) issueToken(id string) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+
+	l.tokenMutex.Lock()
+	defer l.tokenMutex.Unlock()
+	if l.tokens == nil {
+		l.tokens = make(map[string]*sessionToken)
+	}
+	l.tokens[id] = &sessionToken{hash: sha256.Sum256([]byte(token))}
+	return token
+}
+
+// redeemToken reports whether token is the pod's current token and still valid.
+// A matching token is used up even when it has expired.
+func (l *Lobby) redeemToken(id, token string) bool {
+	l.tokenMutex.Lock()
+	defer l.tokenMutex.Unlock()
+	current, exists := l.tokens[id]
+	if !exists {
+		return false
+	}
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(hash[:], current.hash[:]) != 1 {
+		return false
+	}
+	delete(l.tokens, id)
+	return current.expires.IsZero() || time.Now().Before(current.expires)
+}
+
+// expireToken starts the pod's token countdown once its connection is gone
+func (l *Lobby) expireToken(id string) {
+	l.tokenMutex.Lock()
+	defer l.tokenMutex.Unlock()
+	if current, exists := l.tokens[id]; exists && current.expires.IsZero() {
+		current.expires = time.Now().Add(ReconnectTokenTTL)
+	}
+}
+
+func (l *Lobby) revokeTokens() {
+	l.tokenMutex.Lock()
+	defer l.tokenMutex.Unlock()
+	l.tokens = nil
+}
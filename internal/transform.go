@@ -0,0 +1,62 @@
+package internal
+
+type Transform int
+
+const (
+	FlipHorizontal Transform = iota // Mirror left to right
+	FlipVertical                    // Mirror top to bottom
+	Rotate90                        // Clockwise
+	Rotate180
+	Rotate270
+)
+
+// Transform mirrors or rotates the maze in place, moving the entrance and exit with it.
+// Rotating by 90 or 270 degrees swaps the width and height.
+func (m *Maze) Transform(t Transform) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	width, height := m.Width, m.Height
+	if t == Rotate90 || t == Rotate270 {
+		width, height = height, width
+	}
+
+	cells := make([][]bool, width)
+	visited := make([][]bool, width)
+	for x := range cells {
+		cells[x] = make([]bool, height)
+		visited[x] = make([]bool, height)
+	}
+	for x := 0; x < m.Width; x++ {
+		for y := 0; y < m.Height; y++ {
+			p := t.apply(Point{x, y}, m.Width, m.Height)
+			cells[p.X][p.Y] = m.cells[x][y]
+			visited[p.X][p.Y] = m.visited[x][y]
+		}
+	}
+
+	m.Entrance = t.apply(m.Entrance, m.Width, m.Height)
+	m.Exit = t.apply(m.Exit, m.Width, m.Height)
+	for i, exit := range m.ExtraExits {
+		m.ExtraExits[i] = t.apply(exit, m.Width, m.Height)
+	}
+	m.Width, m.Height = width, height
+	m.cells, m.visited = cells, visited
+}
+
+func (t Transform) apply(p Point, width, height int) Point {
+	switch t {
+	case FlipHorizontal:
+		return Point{width - 1 - p.X, p.Y}
+	case FlipVertical:
+		return Point{p.X, height - 1 - p.Y}
+	case Rotate90:
+		return Point{height - 1 - p.Y, p.X}
+	case Rotate180:
+		return Point{width - 1 - p.X, height - 1 - p.Y}
+	case Rotate270:
+		return Point{p.Y, width - 1 - p.X}
+	default:
+		return p
+	}
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func generatedMaze(t *testing.T, width, height int) *Maze {
+	t.Helper()
+	m, err := NewMazeWithSeed(width, height, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDoubleTransformsRestoreMaze(t *testing.T) {
+	for _, pair := range [][]Transform{
+		{FlipHorizontal, FlipHorizontal},
+		{FlipVertical, FlipVertical},
+		{Rotate180, Rotate180},
+		{Rotate90, Rotate270},
+		{Rotate90, Rotate90, Rotate90, Rotate90},
+	} {
+		m := generatedMaze(t, 9, 5)
+		want := m.Message()
+		for _, tr := range pair {
+			m.Transform(tr)
+		}
+		if got := m.Message(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v did not restore the maze", pair)
+		}
+	}
+}
+
+func TestTransformMovesEndpoints(t *testing.T) {
+	for _, tc := range []struct {
+		transform      Transform
+		width, height  int
+		entrance, exit Point
+	}{
+		// A 9x5 maze runs from (0,0) to (8,4)
+		{FlipHorizontal, 9, 5, Point{8, 0}, Point{0, 4}},
+		{FlipVertical, 9, 5, Point{0, 4}, Point{8, 0}},
+		{Rotate90, 5, 9, Point{4, 0}, Point{0, 8}},
+		{Rotate180, 9, 5, Point{8, 4}, Point{0, 0}},
+		{Rotate270, 5, 9, Point{0, 8}, Point{4, 0}},
+	} {
+		m := generatedMaze(t, 9, 5)
+		original := m.Walls()
+		if m.Entrance != (Point{0, 0}) || m.Exit != (Point{8, 4}) {
+			t.Fatalf("maze runs from %v to %v, want corner to corner", m.Entrance, m.Exit)
+		}
+		m.Transform(tc.transform)
+		if m.Width != tc.width || m.Height != tc.height || m.Entrance != tc.entrance || m.Exit != tc.exit {
+			t.Errorf("%v: %dx%d from %v to %v, want %dx%d from %v to %v", tc.transform,
+				m.Width, m.Height, m.Entrance, m.Exit, tc.width, tc.height, tc.entrance, tc.exit)
+		}
+		if err := m.Validate(); err != nil {
+			t.Errorf("%v: transformed maze invalid: %v", tc.transform, err)
+		}
+		// Every wall moves with the cells
+		walls := m.Walls()
+		for x := range original {
+			for y := range original[x] {
+				if p := tc.transform.apply(Point{x, y}, 9, 5); walls[p.X][p.Y] != original[x][y] {
+					t.Fatalf("%v: cell (%d,%d) did not move to %v", tc.transform, x, y, p)
+				}
+			}
+		}
+	}
+}
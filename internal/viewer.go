@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Viewer serves a board viewer page that follows the lobby over /spectate
+var Viewer = true
+
+//go:embed viewer/index.html
+var viewerFiles embed.FS
+
+// HandleViewer serves the built-in viewer, a page that renders the spectator feed live
+func (l *Lobby) HandleViewer(c *gin.Context) {
+	if !Viewer {
+		c.String(http.StatusNotFound, "Viewer is disabled")
+		return
+	}
+	page, err := viewerFiles.ReadFile("viewer/index.html")
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+}
@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveViewer(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/viewer", newTestLobby(t, 5, 5, 1).HandleViewer)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/viewer", nil))
+	return w
+}
+
+func TestHandleViewerServesPage(t *testing.T) {
+	w := serveViewer(t)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("content type %q, want text/html", ct)
+	}
+	body := w.Body.String()
+	if body == "" {
+		t.Fatal("empty page")
+	}
+	for _, want := range []string{"<html", "new WebSocket(", `"spectate"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+}
+
+func TestHandleViewerDisabled(t *testing.T) {
+	setFor(t, &Viewer, false)
+	if w := serveViewer(t); w.Code != http.StatusNotFound {
+		t.Errorf("status %d, want 404", w.Code)
+	}
+}
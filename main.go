@@ -1,46 +1,271 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"gbccsclub/octopod-challenge/internal"
+	"gbccsclub/octopod-challenge/internal/store"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
 	_ = godotenv.Load(".env")
 
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replay(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		simulate(os.Args[2:])
+		return
+	}
+
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file, environment variables override it")
+	flag.Parse()
+	config, err := internal.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Invalid configuration: ", err)
+	}
+	if err := config.Apply(); err != nil {
+		log.Fatal(err)
+	}
+
 	router := gin.Default()
-	lobby := internal.NewLobby(10, 10)
+	lobby := internal.NewLobby(config)
+	if path := config.StorePath; path != "" {
+		results, err := store.OpenBolt(path)
+		if err != nil {
+			slog.Error("Failed to open result store", "path", path, "err", err)
+			os.Exit(1)
+		}
+		lobby.Store = results
+		slog.Info("Storing results", "path", path)
+	}
+	if internal.StatePath != "" {
+		if err := lobby.RestoreState(internal.StatePath); err == nil {
+			// Keep the file for inspection, but never restore it twice after a crash
+			_ = os.Rename(internal.StatePath, internal.StatePath+".restored")
+		} else if !os.IsNotExist(err) {
+			slog.Error("Failed to restore lobby state", "path", internal.StatePath, "err", err)
+			os.Exit(1)
+		}
+	}
+	lobbies := internal.NewLobbyManager(lobby.DiscordBot)
+	if err := lobbies.AddLobby("main", lobby); err != nil {
+		slog.Error("Failed to register the main lobby", "err", err)
+		os.Exit(1)
+	}
+	if internal.StatePath != "" {
+		if err := lobbies.RestoreStates(); err != nil {
+			slog.Error("Failed to restore lobby state", "err", err)
+			os.Exit(1)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lobbies.StartSweeper(ctx)
 
 	router.GET("/", func(c *gin.Context) {
+		// One snapshot, so the board and the positions below agree
+		snapshot := lobby.Snapshot()
 		content := "Octapod Challenge Server" + "\n"
 		content += "-----------------------" + "\n"
-		content += "Maze:\n" + lobby.DisplayMaze("") + "\n"
+		content += "Maze:\n" + snapshot.Render("") + "\n"
 		content += "Octapods:\n"
-		for id, oct := range lobby.Octapods {
-			position := oct.Position
-			content += id + " (" + strconv.Itoa(int(position.X())) + "," + strconv.Itoa(int(position.Y())) + ")\n"
+		for _, pod := range snapshot.Pods {
+			content += pod.Id + " (" + strconv.Itoa(pod.Position.X) + "," + strconv.Itoa(pod.Position.Y) + ")\n"
 		}
 
 		c.String(200, content)
 	})
 	router.GET("/join", lobby.HandleJoin)
+	router.GET("/join/:lobby", lobbies.HandleJoin)
+	router.GET("/lobbies", lobbies.HandleListLobbies)
+	router.POST("/lobbies", lobbies.HandleCreateLobby)
+	router.DELETE("/lobbies/:lobby", lobbies.HandleCloseLobby)
+	router.GET("/pods/:id", lobby.HandlePod)
+	router.GET("/api/octapod/:id/sensor", lobby.HandlePollSensor)
+	router.POST("/api/octapod/:id/move", lobby.HandlePollMove)
+	router.GET("/state", lobby.HandleState)
+	router.GET("/spectate", lobby.HandleSpectate)
+	router.GET("/viewer", lobby.HandleViewer)
+	router.GET("/stats", lobby.HandleStatsJSON)
+	router.GET("/scoreboard", lobby.HandleScoreboard)
+	router.GET("/scoreboard/teams", lobby.HandleTeamScoreboard)
+	router.GET("/leaderboard", lobby.HandleLeaderboard)
+	router.GET("/replay", lobby.HandleReplay)
+	router.GET("/metrics", lobby.HandleMetrics)
+	router.POST("/admin/reset", lobby.HandleResetAll)
+	router.POST("/admin/pods/:id/kick", lobby.HandleKick)
+	router.POST("/admin/pause", lobby.HandlePause)
+	router.POST("/admin/resume", lobby.HandleResume)
+	router.POST("/admin/interval", lobby.HandleSetInterval)
+	router.POST("/admin/timeout", lobby.HandleForceTimeout)
+	router.POST("/admin/sensors", lobby.HandleSetSensors)
+	router.POST("/admin/events", lobby.HandleTriggerEvent)
+	router.POST("/admin/round/start", lobby.HandleStartRound)
+	router.POST("/admin/round/finish", lobby.HandleFinishRound)
+	// Every lobby, the main one included, also answers under /lobbies/<id>
+	perLobby := router.Group("/lobbies/:lobby")
+	perLobby.GET("/pods/:id", lobbies.Route((*internal.Lobby).HandlePod))
+	perLobby.GET("/api/octapod/:id/sensor", lobbies.Route((*internal.Lobby).HandlePollSensor))
+	perLobby.POST("/api/octapod/:id/move", lobbies.Route((*internal.Lobby).HandlePollMove))
+	perLobby.GET("/state", lobbies.Route((*internal.Lobby).HandleState))
+	perLobby.GET("/spectate", lobbies.Route((*internal.Lobby).HandleSpectate))
+	perLobby.GET("/viewer", lobbies.Route((*internal.Lobby).HandleViewer))
+	perLobby.GET("/stats", lobbies.Route((*internal.Lobby).HandleStatsJSON))
+	perLobby.GET("/scoreboard", lobbies.Route((*internal.Lobby).HandleScoreboard))
+	perLobby.GET("/scoreboard/teams", lobbies.Route((*internal.Lobby).HandleTeamScoreboard))
+	perLobby.GET("/leaderboard", lobbies.Route((*internal.Lobby).HandleLeaderboard))
+	perLobby.GET("/replay", lobbies.Route((*internal.Lobby).HandleReplay))
+	perLobby.GET("/metrics", lobbies.Route((*internal.Lobby).HandleMetrics))
+	perLobby.POST("/admin/reset", lobbies.Route((*internal.Lobby).HandleResetAll))
+	perLobby.POST("/admin/pods/:id/kick", lobbies.Route((*internal.Lobby).HandleKick))
+	perLobby.POST("/admin/pause", lobbies.Route((*internal.Lobby).HandlePause))
+	perLobby.POST("/admin/resume", lobbies.Route((*internal.Lobby).HandleResume))
+	perLobby.POST("/admin/interval", lobbies.Route((*internal.Lobby).HandleSetInterval))
+	perLobby.POST("/admin/timeout", lobbies.Route((*internal.Lobby).HandleForceTimeout))
+	perLobby.POST("/admin/sensors", lobbies.Route((*internal.Lobby).HandleSetSensors))
+	perLobby.POST("/admin/events", lobbies.Route((*internal.Lobby).HandleTriggerEvent))
+	perLobby.POST("/admin/round/start", lobbies.Route((*internal.Lobby).HandleStartRound))
+	perLobby.POST("/admin/round/finish", lobbies.Route((*internal.Lobby).HandleFinishRound))
 	// For chron job on render to prevent sleep
 	router.GET("/ping", func(c *gin.Context) {
 		c.String(200, ".")
 	})
 
-	var port = "3000"
-	if os.Getenv("PORT") != "" {
-		port = os.Getenv("PORT")
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+		if internal.StatePath != "" {
+			if err := lobbies.SaveStates(); err != nil {
+				slog.Error("Failed to save lobby state", "path", internal.StatePath, "err", err)
+			}
+		}
+		lobbies.Shutdown()
+		lobby.DiscordBot.Close()
+		if lobby.Store != nil {
+			lobby.Store.Close()
+		}
+		os.Exit(0)
+	}()
+
+	slog.Info("Starting a lobby server", "listen", config.Listen)
+	if err := router.Run(config.Listen); err != nil {
+		slog.Error("Server stopped", "err", err)
+		os.Exit(1)
 	}
+}
 
-	log.Println("Starting a lobby server on port", port)
-	err := router.Run(":" + port)
-	if err != nil {
+// replay prints a recorded match frame by frame: replay [-delay 200ms] <match.jsonl>
+func replay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	delay := flags.Duration("delay", 200*time.Millisecond, "Pause between frames")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: replay [-delay 200ms] <match.jsonl>")
+	}
+	if err := internal.PlayMatch(os.Stdout, flags.Arg(0), *delay); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// diff reports where two recorded matches diverge: diff <a.jsonl> <b.jsonl>
+func diff(args []string) {
+	if len(args) != 2 {
+		log.Fatal("Usage: diff <a.jsonl> <b.jsonl>")
+	}
+	var replays [2][]internal.Event
+	for i, path := range args {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		replays[i], err = internal.LoadMatch(file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+	index, diverged := internal.DiffReplays(replays[0], replays[1])
+	if !diverged {
+		fmt.Printf("Identical, %d events\n", len(replays[0]))
+		return
+	}
+	for i, path := range args {
+		if index < len(replays[i]) {
+			event := replays[i][index]
+			fmt.Printf("%s: event %d, tick %d, %s %s\n", path, index, event.Tick, event.Type, event.Id)
+		} else {
+			fmt.Printf("%s: ends after %d events\n", path, len(replays[i]))
+		}
+	}
+	os.Exit(1)
+}
+
+func simulate(args []string) {
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	width := flags.Int("width", internal.DefaultLobbyWidth, "Maze width")
+	height := flags.Int("height", internal.DefaultLobbyHeight, "Maze height")
+	seed := flags.Int64("seed", 1, "Lobby seed, random when 0")
+	ticks := flags.Int("ticks", 10000, "Ticks to run at most")
+	pods := flags.Int("pods", 1, "Number of octapods")
+	strategy := flags.String("strategy", "wallfollower", "Built-in strategy: wallfollower or random")
+	collisions := flags.String("collisions", string(internal.Collisions), "Collision policy: stack, block or tag")
+	verbose := flags.Bool("v", false, "Log the lobby as it runs")
+	_ = flags.Parse(args)
+	switch policy := internal.CollisionPolicy(*collisions); policy {
+	case internal.CollisionStack, internal.CollisionBlock, internal.CollisionTag:
+		internal.Collisions = policy
+	default:
+		log.Fatalf("Invalid collisions %q, expected stack, block or tag", *collisions)
+	}
+
+	newStrategy, exists := internal.Strategies[strings.ToLower(*strategy)]
+	if !exists {
+		log.Fatalf("Unknown strategy %q", *strategy)
+	}
+	level := "warn"
+	if *verbose {
+		level = "debug"
+	}
+	if err := internal.ConfigureLogging(level, "", ""); err != nil {
+		log.Fatal(err)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	sim := internal.Simulation{Width: *width, Height: *height, Seed: *seed, Ticks: *ticks}
+	for i := 1; i <= *pods; i++ {
+		sim.Pods = append(sim.Pods, internal.SimPod{
+			Id:       fmt.Sprintf("sim%d", i),
+			Strategy: newStrategy(*seed + int64(i)),
+		})
+	}
+	start := time.Now()
+	result, err := sim.Run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Seed %d (maze seed %d), %d ticks in %s\n", result.Seed, result.MazeSeed, result.Ticks, time.Since(start).Round(time.Millisecond))
+	fmt.Println(result.Board)
+	fmt.Println(result.Scoreboard.Render())
+}